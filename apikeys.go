@@ -0,0 +1,284 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Every API key starts with this so it can be told apart from a JWT
+const apiKeyPrefix = "sk_"
+
+// Routes that manage credentials cannot be reached with an API key, so a
+// leaked key cannot be used to mint new keys or take over the account.
+var apiKeyDeniedRoutePrefixes = []string{
+	"/api/logout",
+	"/api/profile/",
+	"/api/api-keys",
+	"/api/service-accounts",
+	"/api/users/:id/impersonate",
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=read write"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=3650"`
+}
+
+type CreateServiceAccountRequest struct {
+	Username  string `json:"username" binding:"required"`
+	StudentID string `json:"student_id"`
+	Email     string `json:"email" binding:"required,email"`
+	Name      string `json:"name" binding:"required"`
+	Role      string `json:"role" binding:"required"`
+}
+
+// apiKeyFromRequest returns the API key sent in the X-API-Key header or as a
+// bearer token, if any.
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); strings.HasPrefix(token, apiKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+func apiKeyHasScope(key models.APIKey, scope string) bool {
+	for _, s := range strings.Split(key.Scopes, ",") {
+		if s == scope || s == models.APIKeyScopeWrite {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey is the API key branch of authMiddleware. The key acts
+// with the current role of its user. Keys are revoked with the user's tokens,
+// so they do not outlive a password or role change or logging out everywhere.
+func authenticateAPIKey(c *gin.Context, key string) {
+	var apiKey models.APIKey
+	if err := DB.Preload("User").Where("key_hash = ?", hashToken(key)).First(&apiKey).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+	if apiKey.RevokedAt != nil {
+		c.JSON(401, gin.H{"error": "API key has been revoked"})
+		c.Abort()
+		return
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		c.JSON(401, gin.H{"error": "API key has expired"})
+		c.Abort()
+		return
+	}
+	if apiKey.User.ID == 0 {
+		c.JSON(401, gin.H{"error": "User no longer exists"})
+		c.Abort()
+		return
+	}
+	if !apiKey.User.IsActive {
+		c.JSON(401, gin.H{"error": "Account has been deactivated"})
+		c.Abort()
+		return
+	}
+	// Keys are bound by the same gates as the owner's login sessions
+	if apiKey.User.MustChangePassword {
+		c.JSON(403, gin.H{"error": "Password change required", "code": "password_change_required"})
+		c.Abort()
+		return
+	}
+	if twoFactorSetupRequired(apiKey.User) {
+		c.JSON(403, gin.H{"error": "Two-factor authentication setup required", "code": "two_factor_setup_required"})
+		c.Abort()
+		return
+	}
+
+	for _, prefix := range apiKeyDeniedRoutePrefixes {
+		if strings.HasPrefix(c.FullPath(), prefix) {
+			c.JSON(403, gin.H{"error": "This endpoint cannot be used with an API key"})
+			c.Abort()
+			return
+		}
+	}
+	scope := models.APIKeyScopeWrite
+	if c.Request.Method == "GET" || c.Request.Method == "HEAD" {
+		scope = models.APIKeyScopeRead
+	}
+	if !apiKeyHasScope(apiKey, scope) {
+		c.JSON(403, gin.H{"error": "API key does not have the " + scope + " scope"})
+		c.Abort()
+		return
+	}
+
+	DB.Model(&apiKey).UpdateColumn("last_used_at", time.Now())
+
+	c.Set("user_id", apiKey.UserID)
+	c.Set("user_role", string(apiKey.User.Role))
+	c.Set("username", apiKey.User.Username)
+	c.Set("api_key_id", apiKey.ID)
+	c.Next()
+}
+
+// createAPIKey stores a new key for the user. The plain key is only returned
+// here and cannot be retrieved later.
+func createAPIKey(c *gin.Context, userID uint) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := models.APIKey{
+		UserID:      userID,
+		Name:        req.Name,
+		Prefix:      key[:len(apiKeyPrefix)+8],
+		KeyHash:     hashToken(key),
+		Scopes:      strings.Join(req.Scopes, ","),
+		CreatedByID: getUserID(c),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := DB.Create(&apiKey).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"data":    apiKey,
+		"key":     key,
+		"message": "Store this key now, it will not be shown again",
+	})
+}
+
+func revokeAPIKey(c *gin.Context, query *gorm.DB) {
+	var apiKey models.APIKey
+	if err := query.First(&apiKey, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "API key not found"})
+		return
+	}
+	if apiKey.RevokedAt == nil {
+		if err := DB.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke API key"})
+			return
+		}
+	}
+	c.JSON(200, gin.H{"message": "API key revoked successfully"})
+}
+
+func getMyAPIKeys(c *gin.Context) {
+	var keys []models.APIKey
+	DB.Where("user_id = ?", getUserID(c)).Order("created_at DESC").Find(&keys)
+	c.JSON(200, gin.H{"data": keys})
+}
+
+func createMyAPIKey(c *gin.Context) {
+	createAPIKey(c, getUserID(c))
+}
+
+func revokeMyAPIKey(c *gin.Context) {
+	revokeAPIKey(c, DB.Where("user_id = ?", getUserID(c)))
+}
+
+// getAPIKeys lists the keys of all users for administrators.
+func getAPIKeys(c *gin.Context) {
+	query := DB.Preload("User")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if c.Query("active") == "true" {
+		query = query.Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+	}
+	var keys []models.APIKey
+	query.Order("created_at DESC").Find(&keys)
+	c.JSON(200, gin.H{"data": keys})
+}
+
+func adminRevokeAPIKey(c *gin.Context) {
+	revokeAPIKey(c, DB)
+}
+
+func getServiceAccounts(c *gin.Context) {
+	var users []models.User
+	DB.Where("is_service_account = ?", true).Order("username").Find(&users)
+	c.JSON(200, gin.H{"data": users})
+}
+
+// createServiceAccount creates a user for an integration. It has no usable
+// password and can only call the API with keys issued by an administrator.
+func createServiceAccount(c *gin.Context) {
+	var req CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !roleExists(models.UserRole(req.Role)) {
+		c.JSON(400, gin.H{"error": "Role does not exist"})
+		return
+	}
+	if !canAssignRole(c, models.UserRole(req.Role)) {
+		c.JSON(403, gin.H{"error": "You do not have permission to assign this role"})
+		return
+	}
+
+	if msg := userConflict(0, req.Username, req.Email, req.StudentID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create service account"})
+		return
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create service account"})
+		return
+	}
+	user := models.User{
+		Username:         req.Username,
+		StudentID:        studentIDColumn(req.StudentID),
+		Email:            req.Email,
+		Password:         hashedPassword,
+		Name:             req.Name,
+		Role:             models.UserRole(req.Role),
+		IsServiceAccount: true,
+	}
+	if err := DB.Create(&user).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create service account"})
+		return
+	}
+	c.JSON(201, gin.H{"data": user})
+}
+
+func createServiceAccountAPIKey(c *gin.Context) {
+	var user models.User
+	if err := DB.Where("is_service_account = ?", true).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Service account not found"})
+		return
+	}
+	// A key acts with the account's role, so it needs the same permission as
+	// assigning that role
+	if !canAssignRole(c, user.Role) {
+		c.JSON(403, gin.H{"error": "You do not have permission to create keys for this role"})
+		return
+	}
+	createAPIKey(c, user.ID)
+}
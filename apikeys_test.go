@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createTestAPIKey stores a read and write key for the user and returns it.
+func createTestAPIKey(t *testing.T, user models.User) string {
+	t.Helper()
+	key := apiKeyPrefix + "test-" + user.Username
+	apiKey := models.APIKey{
+		UserID:      user.ID,
+		Name:        "test",
+		Prefix:      key[:len(apiKeyPrefix)+8],
+		KeyHash:     hashToken(key),
+		Scopes:      models.APIKeyScopeWrite,
+		CreatedByID: user.ID,
+	}
+	if err := DB.Create(&apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func apiKeyStatus(key string) int {
+	req := httptest.NewRequest("GET", "/api/complaints", nil)
+	req.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	gatedRouter().ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAPIKeyRevokedWithUserTokens(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "integration", models.RoleHandler)
+	key := createTestAPIKey(t, user)
+
+	if status := apiKeyStatus(key); status != 200 {
+		t.Fatalf("new key = %d, want 200", status)
+	}
+	if err := revokeUserTokens(DB, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status := apiKeyStatus(key); status != 401 {
+		t.Errorf("key after revokeUserTokens = %d, want 401", status)
+	}
+}
+
+func TestAPIKeyLoginGates(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.RequireAdminTOTP = true
+
+	student := createTestUser(t, "mustchange", models.RoleStudent)
+	DB.Model(&student).Update("must_change_password", true)
+	if status := apiKeyStatus(createTestAPIKey(t, student)); status != 403 {
+		t.Errorf("key of user who must change password = %d, want 403", status)
+	}
+
+	admin := createTestUser(t, "no2fa", models.RoleAdmin)
+	if status := apiKeyStatus(createTestAPIKey(t, admin)); status != 403 {
+		t.Errorf("key of admin without 2FA = %d, want 403", status)
+	}
+}
+
+func TestCreateServiceAccountRejectsTakenStudentID(t *testing.T) {
+	setupTestDB(t)
+	existing := createTestUser(t, "student", models.RoleStudent)
+
+	r := gin.New()
+	r.POST("/api/service-accounts", func(c *gin.Context) {
+		c.Set("user_id", uint(0))
+		c.Set("user_role", string(models.RoleAdmin))
+		createServiceAccount(c)
+	})
+	body := `{"username":"bot","email":"bot@example.com","name":"Bot","role":"viewer","student_id":"` + studentIDOf(existing) + `"}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/service-accounts", strings.NewReader(body)))
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "Student ID already exists") {
+		t.Errorf("duplicate student ID = %d %s, want 400", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateAccountsRequireRolesManageForStaffRoles(t *testing.T) {
+	setupTestDB(t)
+	// A user manager without roles.manage
+	createTestRole(t, "usermanager", models.PermUsersManage)
+
+	tests := []struct {
+		name, path, callerRole, body string
+		want                         int
+	}{
+		{"user as manager", "/api/users", "usermanager", `{"username":"a1","email":"a1@example.com","password":"Str0ng-passw0rd","name":"A","role":"admin"}`, 403},
+		{"student as manager", "/api/users", "usermanager", `{"username":"s1","student_id":"S1","email":"s1@example.com","password":"Str0ng-passw0rd","name":"S","role":"student"}`, 201},
+		{"user as admin", "/api/users", "admin", `{"username":"a2","student_id":"A2","email":"a2@example.com","password":"Str0ng-passw0rd","name":"A","role":"admin"}`, 201},
+		{"service account as manager", "/api/service-accounts", "usermanager", `{"username":"bot1","email":"bot1@example.com","name":"Bot","role":"handler"}`, 403},
+		{"service account as admin", "/api/service-accounts", "admin", `{"username":"bot2","student_id":"B2","email":"bot2@example.com","name":"Bot","role":"handler"}`, 201},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			handler := createUser
+			if tt.path == "/api/service-accounts" {
+				handler = createServiceAccount
+			}
+			r.POST(tt.path, func(c *gin.Context) {
+				c.Set("user_id", uint(0))
+				c.Set("user_role", tt.callerRole)
+				handler(c)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServiceAccountAPIKeyRequiresRolesManageForStaffRoles(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "usermanager", models.PermUsersManage)
+	manager := createTestUser(t, "manager", "usermanager")
+	adminBot := createTestUser(t, "adminbot", models.RoleAdmin)
+	studentBot := createTestUser(t, "studentbot", models.RoleStudent)
+	DB.Model(&models.User{}).Where("id IN ?", []uint{adminBot.ID, studentBot.ID}).Update("is_service_account", true)
+
+	body := `{"name":"ci","scopes":["read"]}`
+	route := "/api/service-accounts/:id/api-keys"
+	tests := []struct {
+		name       string
+		callerRole string
+		account    models.User
+		want       int
+	}{
+		{"admin account as manager", "usermanager", adminBot, 403},
+		{"student account as manager", "usermanager", studentBot, 201},
+		{"admin account as admin", "admin", adminBot, 201},
+	}
+	for _, tt := range tests {
+		path := fmt.Sprintf("/api/service-accounts/%d/api-keys", tt.account.ID)
+		if w := callAs(createServiceAccountAPIKey, manager.ID, tt.callerRole, "POST", route, path, body); w.Code != tt.want {
+			t.Errorf("%s = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+
+	var count int64
+	DB.Model(&models.APIKey{}).Where("user_id = ?", adminBot.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("admin service account has %d keys, want 1", count)
+	}
+}
+
+func TestCreateServiceAccountsWithoutStudentID(t *testing.T) {
+	setupTestDB(t)
+	for _, username := range []string{"bot1", "bot2"} {
+		body := `{"username":"` + username + `","email":"` + username + `@example.com","name":"Bot","role":"student"}`
+		if w := callAs(createServiceAccount, 0, "admin", "POST", "/api/service-accounts", "/api/service-accounts", body); w.Code != 201 {
+			t.Errorf("%s = %d: %s", username, w.Code, w.Body.String())
+		}
+	}
+}
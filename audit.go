@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxAuditResponseSize is how much of a response is kept to find the ID of a
+// created record.
+const maxAuditResponseSize = 64 << 10
+
+// auditTargets maps the first segment of a route to the model it changes, so
+// the record can be captured before and after the call.
+var auditTargets = map[string]func() interface{}{
+	"users":                  func() interface{} { return &models.User{} },
+	"profile":                func() interface{} { return &models.User{} },
+	"service-accounts":       func() interface{} { return &models.User{} },
+	"registrations":          func() interface{} { return &models.User{} },
+	"complaints":             func() interface{} { return &models.Complaint{} },
+	"categories":             func() interface{} { return &models.Category{} },
+	"announcements":          func() interface{} { return &models.Announcement{} },
+	"notifications":          func() interface{} { return &models.Notification{} },
+	"canned-responses":       func() interface{} { return &models.CannedResponse{} },
+	"triage-rules":           func() interface{} { return &models.TriageRule{} },
+	"roles":                  func() interface{} { return &models.Role{} },
+	"org-units":              func() interface{} { return &models.OrgUnit{} },
+	"api-keys":               func() interface{} { return &models.APIKey{} },
+	"impersonation-sessions": func() interface{} { return &models.ImpersonationSession{} },
+	"login-throttles":        func() interface{} { return &models.LoginThrottle{} },
+	"report-subscriptions":   func() interface{} { return &models.ReportSubscription{} },
+}
+
+// auditIgnoredFields change on every write and would only add noise.
+var auditIgnoredFields = map[string]bool{"updated_at": true}
+
+// auditPersonalData lists the fields of each model that hold personal data.
+// The hash chain makes entries impossible to change, so these values could
+// never be erased again; an entry only records that such a field changed.
+var auditPersonalData = map[reflect.Type][]string{
+	reflect.TypeOf(models.User{}):                 {"username", "student_id", "email", "name", "phone", "avatar_url"},
+	reflect.TypeOf(models.Complaint{}):            {"title", "description", "admin_response", "evidence_path"},
+	reflect.TypeOf(models.Notification{}):         {"title", "message"},
+	reflect.TypeOf(models.ImpersonationSession{}): {"reason", "ip_address", "user_agent"},
+	reflect.TypeOf(models.LoginThrottle{}):        {"key"},
+	reflect.TypeOf(models.ReportSubscription{}):   {"recipients"},
+}
+
+// auditPersonalValue replaces personal data in the changes of an entry
+const auditPersonalValue = "[personal data]"
+
+// auditMu serializes appends within the process; the row lock on the last
+// entry does the same across instances.
+var auditMu sync.Mutex
+
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.body.Len() < maxAuditResponseSize {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// auditRoute splits a route such as /api/users/:id/deactivate into the
+// target type and an action name: users and users.deactivate. Routes that
+// end in a parameter or name only a resource get the verb of the method,
+// e.g. users.create or complaints.watchers.delete.
+func auditRoute(method, route string) (string, string) {
+	var static []string
+	endsWithParam := false
+	for _, segment := range strings.Split(strings.TrimPrefix(route, "/api/"), "/") {
+		if segment == "" {
+			continue
+		}
+		endsWithParam = strings.HasPrefix(segment, ":")
+		if !endsWithParam {
+			static = append(static, segment)
+		}
+	}
+	if len(static) == 0 {
+		return "", strings.ToLower(method)
+	}
+	action := strings.Join(static, ".")
+	if _, ok := auditTargets[static[0]]; endsWithParam || (len(static) == 1 && ok) {
+		switch method {
+		case "POST":
+			action += ".create"
+		case "PUT", "PATCH":
+			action += ".update"
+		case "DELETE":
+			action += ".delete"
+		}
+	}
+	return static[0], action
+}
+
+// auditSnapshot loads the target record as its API representation, or nil
+// when there is none. Associations are left out since they are not loaded,
+// except the permissions of a role, which are what matters when it changes.
+func auditSnapshot(targetType, targetID string) map[string]interface{} {
+	newModel, ok := auditTargets[targetType]
+	if !ok || targetID == "" {
+		return nil
+	}
+	record := newModel()
+	if err := DB.Where("id = ?", targetID).Take(record).Error; err != nil {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil
+	}
+	var snapshot map[string]interface{}
+	json.Unmarshal(data, &snapshot)
+	for field, value := range snapshot {
+		if _, ok := value.(map[string]interface{}); ok {
+			delete(snapshot, field)
+		}
+	}
+	if role, ok := record.(*models.Role); ok {
+		permissions := []string{}
+		DB.Model(&models.RolePermission{}).Where("role_id = ?", role.ID).Order("permission").Pluck("permission", &permissions)
+		snapshot["permissions"] = permissions
+	}
+	return snapshot
+}
+
+// auditPersonalFields returns the personal data fields of a target type.
+func auditPersonalFields(targetType string) map[string]bool {
+	newModel, ok := auditTargets[targetType]
+	if !ok {
+		return nil
+	}
+	fields := map[string]bool{}
+	for _, field := range auditPersonalData[reflect.TypeOf(newModel()).Elem()] {
+		fields[field] = true
+	}
+	return fields
+}
+
+// auditChanges returns the fields that differ between the two snapshots as
+// {"field": {"before": ..., "after": ...}}. A created record has no before
+// and a deleted one no after. Values of personal fields are replaced.
+func auditChanges(before, after map[string]interface{}, personal map[string]bool) json.RawMessage {
+	value := func(field string, v interface{}) interface{} {
+		if personal[field] && v != nil {
+			return auditPersonalValue
+		}
+		return v
+	}
+	changes := map[string]gin.H{}
+	for field, v := range before {
+		if !auditIgnoredFields[field] && !reflect.DeepEqual(v, after[field]) {
+			changes[field] = gin.H{"before": value(field, v), "after": value(field, after[field])}
+		}
+	}
+	for field, v := range after {
+		if _, ok := before[field]; !ok && !auditIgnoredFields[field] {
+			changes[field] = gin.H{"before": nil, "after": value(field, v)}
+		}
+	}
+	data, _ := json.Marshal(changes)
+	return data
+}
+
+// createdRecordID finds the ID in a response body of a create call, either
+// the record itself or {"data": record}.
+func createdRecordID(body []byte) string {
+	var response struct {
+		ID   json.Number `json:"id"`
+		Data struct {
+			ID json.Number `json:"id"`
+		} `json:"data"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if decoder.Decode(&response) != nil {
+		return ""
+	}
+	if response.ID != "" {
+		return response.ID.String()
+	}
+	return response.Data.ID.String()
+}
+
+// auditHash covers every stored field of the entry except its ID and Hash.
+// CreatedAt is stored with second precision so it survives the round trip
+// through the database unchanged.
+func auditHash(entry models.AuditLog) string {
+	data, _ := json.Marshal([]interface{}{
+		entry.PrevHash, entry.ActorID, entry.ImpersonatorID, entry.Action, entry.Method, entry.Path,
+		entry.TargetType, entry.TargetID, entry.Status, string(entry.Changes), entry.IPAddress,
+		entry.UserAgent, entry.CreatedAt.UTC().Format(time.RFC3339),
+	})
+	return hashToken(string(data))
+}
+
+// appendAuditLog chains the entry to the last one and stores it.
+func appendAuditLog(entry models.AuditLog) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	entry.CreatedAt = time.Now().Truncate(time.Second)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var last []models.AuditLog
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		if len(last) > 0 {
+			entry.PrevHash = last[0].Hash
+		}
+		entry.Hash = auditHash(entry)
+		return tx.Create(&entry).Error
+	})
+}
+
+func truncate(value string, size int) string {
+	if len(value) > size {
+		return value[:size]
+	}
+	return value
+}
+
+// auditMiddleware records every mutating call of an authenticated user,
+// including refused ones, with the changes it made to the target record.
+func auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if method == "GET" || method == "HEAD" || method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		actorID := getUserID(c)
+		targetType, action := auditRoute(method, c.FullPath())
+		targetID := c.Param("id")
+		if targetType == "profile" {
+			targetID = strconv.FormatUint(uint64(actorID), 10)
+		}
+		before := auditSnapshot(targetType, targetID)
+
+		writer := &auditResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if targetID == "" && c.Writer.Status() < 300 {
+			targetID = createdRecordID(writer.body.Bytes())
+		}
+		// Erased personal data must not live on in the audit log
+		if c.GetBool("audit_redact_before") {
+			for field := range before {
+				before[field] = "[redacted]"
+			}
+		}
+		entry := models.AuditLog{
+			ActorID:    &actorID,
+			Action:     action,
+			Method:     method,
+			Path:       truncate(c.Request.URL.Path, 255),
+			TargetType: targetType,
+			TargetID:   truncate(targetID, 50),
+			Status:     c.Writer.Status(),
+			Changes:    auditChanges(before, auditSnapshot(targetType, targetID), auditPersonalFields(targetType)),
+			IPAddress:  c.ClientIP(),
+			UserAgent:  truncate(c.Request.UserAgent(), 255),
+		}
+		if impersonatorID, ok := c.Get("impersonator_id"); ok {
+			id := impersonatorID.(uint)
+			entry.ImpersonatorID = &id
+		}
+		if err := appendAuditLog(entry); err != nil {
+			log.Printf("Error writing audit log: %v", err)
+		}
+	}
+}
+
+// auditLogQuery applies the filters shared by the list and export endpoints.
+func auditLogQuery(c *gin.Context) *gorm.DB {
+	query := DB.Model(&models.AuditLog{})
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action LIKE ?", action+"%")
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+	if targetID := c.Query("target_id"); targetID != "" {
+		query = query.Where("target_id = ?", targetID)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	switch c.Query("result") {
+	case "success":
+		query = query.Where("status < ?", 400)
+	case "failure":
+		query = query.Where("status >= ?", 400)
+	}
+	if from, err := time.ParseInLocation("2006-01-02", c.Query("from"), time.Local); err == nil {
+		query = query.Where("created_at >= ?", from)
+	}
+	if to, err := time.ParseInLocation("2006-01-02", c.Query("to"), time.Local); err == nil {
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return query
+}
+
+func getAuditLogs(c *gin.Context) {
+	query := auditLogQuery(c)
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	var total int64
+	query.Count(&total)
+	var entries []models.AuditLog
+	query.Preload("Actor").Order("id DESC").Offset(offset).Limit(limit).Find(&entries)
+
+	c.JSON(200, gin.H{"data": entries, "total": total, "page": page, "limit": limit, "total_pages": (int(total) + limit - 1) / limit})
+}
+
+// exportAuditLogs streams the filtered entries as CSV, oldest first.
+func exportAuditLogs(c *gin.Context) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-log-%s.csv"`, time.Now().Format("20060102")))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(200)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"id", "created_at", "actor_id", "actor_username", "impersonator_id", "action", "method", "path",
+		"target_type", "target_id", "status", "changes", "ip_address", "user_agent", "prev_hash", "hash"})
+	var entries []models.AuditLog
+	auditLogQuery(c).Preload("Actor").Order("id ASC").FindInBatches(&entries, 500, func(tx *gorm.DB, batch int) error {
+		for _, entry := range entries {
+			actorID, actorUsername, impersonatorID := "", "", ""
+			if entry.ActorID != nil {
+				actorID = strconv.FormatUint(uint64(*entry.ActorID), 10)
+			}
+			if entry.Actor != nil {
+				actorUsername = entry.Actor.Username
+			}
+			if entry.ImpersonatorID != nil {
+				impersonatorID = strconv.FormatUint(uint64(*entry.ImpersonatorID), 10)
+			}
+			writer.Write(csvCells([]string{
+				strconv.FormatUint(uint64(entry.ID), 10), entry.CreatedAt.Format(time.RFC3339), actorID, actorUsername,
+				impersonatorID, entry.Action, entry.Method, entry.Path, entry.TargetType, entry.TargetID,
+				strconv.Itoa(entry.Status), string(entry.Changes), entry.IPAddress, entry.UserAgent, entry.PrevHash, entry.Hash,
+			}))
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	writer.Flush()
+}
+
+// auditChain checks entries in ID order, possibly split into batches, and
+// remembers the first entry that was changed or follows a removed entry.
+type auditChain struct {
+	previousHash string
+	checked      int64
+	brokenAt     *uint
+	reason       string
+}
+
+// verify checks the next entries and reports whether the chain is intact.
+func (chain *auditChain) verify(entries []models.AuditLog) bool {
+	for _, entry := range entries {
+		if entry.PrevHash != chain.previousHash {
+			chain.reason = "Entry does not follow the previous entry, an entry was removed or changed"
+		} else if entry.Hash != auditHash(entry) {
+			chain.reason = "Entry was changed after it was written"
+		}
+		if chain.reason != "" {
+			id := entry.ID
+			chain.brokenAt = &id
+			return false
+		}
+		chain.previousHash = entry.Hash
+		chain.checked++
+	}
+	return true
+}
+
+// verifyAuditLog walks the whole chain and reports the first entry that was
+// changed, or that follows a removed entry.
+func verifyAuditLog(c *gin.Context) {
+	var entries []models.AuditLog
+	var chain auditChain
+	DB.Order("id ASC").FindInBatches(&entries, 1000, func(tx *gorm.DB, batch int) error {
+		if !chain.verify(entries) {
+			return gorm.ErrInvalidData
+		}
+		return nil
+	})
+
+	if chain.brokenAt != nil {
+		c.JSON(200, gin.H{"valid": false, "checked": chain.checked, "broken_at": chain.brokenAt, "reason": chain.reason})
+		return
+	}
+	c.JSON(200, gin.H{"valid": true, "checked": chain.checked})
+}
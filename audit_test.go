@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuditRoute(t *testing.T) {
+	tests := []struct {
+		method, route, wantTarget, wantAction string
+	}{
+		{"POST", "/api/users", "users", "users.create"},
+		{"PUT", "/api/users/:id", "users", "users.update"},
+		{"DELETE", "/api/users/:id", "users", "users.delete"},
+		{"POST", "/api/users/:id/deactivate", "users", "users.deactivate"},
+		{"POST", "/api/complaints/:id/watchers", "complaints", "complaints.watchers"},
+		{"DELETE", "/api/complaints/:id/watchers/:userId", "complaints", "complaints.watchers.delete"},
+		{"PUT", "/api/profile", "profile", "profile.update"},
+		{"PUT", "/api/profile/password", "profile", "profile.password"},
+		{"POST", "/api/logout-all", "logout-all", "logout-all"},
+		{"POST", "", "", "post"},
+	}
+	for _, tt := range tests {
+		target, action := auditRoute(tt.method, tt.route)
+		if target != tt.wantTarget || action != tt.wantAction {
+			t.Errorf("auditRoute(%s, %q) = %q, %q, want %q, %q", tt.method, tt.route, target, action, tt.wantTarget, tt.wantAction)
+		}
+	}
+}
+
+func writeTestAuditLog(t *testing.T, actions ...string) []models.AuditLog {
+	t.Helper()
+	for _, action := range actions {
+		if err := appendAuditLog(models.AuditLog{Action: action, Method: "POST", Path: "/api/" + action, Status: 200, Changes: json.RawMessage("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var entries []models.AuditLog
+	DB.Order("id ASC").Find(&entries)
+	return entries
+}
+
+func TestAuditChainVerify(t *testing.T) {
+	setupTestDB(t)
+	entries := writeTestAuditLog(t, "users.create", "users.update", "roles.create", "users.delete")
+
+	var chain auditChain
+	if !chain.verify(entries[:2]) || !chain.verify(entries[2:]) || chain.checked != 4 {
+		t.Fatalf("intact chain: checked %d, broken at %v: %s", chain.checked, chain.brokenAt, chain.reason)
+	}
+
+	changed := append([]models.AuditLog(nil), entries...)
+	changed[1].Action = "users.read"
+	chain = auditChain{}
+	if chain.verify(changed) || chain.brokenAt == nil || *chain.brokenAt != entries[1].ID {
+		t.Errorf("changed entry: broken at %v, want %d", chain.brokenAt, entries[1].ID)
+	}
+
+	removed := append([]models.AuditLog{entries[0]}, entries[2:]...)
+	chain = auditChain{}
+	if chain.verify(removed) || chain.brokenAt == nil || *chain.brokenAt != entries[2].ID {
+		t.Errorf("removed entry: broken at %v, want %d", chain.brokenAt, entries[2].ID)
+	}
+}
+
+func TestAuditMiddlewareLeavesOutPersonalData(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	student := createTestUser(t, "student", models.RoleStudent)
+
+	r := gin.New()
+	r.PUT("/api/users/:id", func(c *gin.Context) {
+		c.Set("user_id", admin.ID)
+		c.Set("user_role", string(models.RoleAdmin))
+	}, auditMiddleware(), func(c *gin.Context) {
+		DB.Model(&models.User{}).Where("id = ?", c.Param("id")).
+			Updates(map[string]interface{}{"email": "private@example.com", "phone": "0812345678", "role": models.RoleHandler})
+		c.JSON(200, gin.H{})
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/api/users/"+strconv.Itoa(int(student.ID)), nil))
+
+	var entry models.AuditLog
+	if err := DB.Last(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"private@example.com", "student@example.com", "0812345678"} {
+		if strings.Contains(string(entry.Changes), value) {
+			t.Errorf("audit changes contain %q: %s", value, entry.Changes)
+		}
+	}
+	var changes map[string]map[string]interface{}
+	json.Unmarshal(entry.Changes, &changes)
+	if changes["email"]["after"] != auditPersonalValue {
+		t.Errorf("email change = %v, want it recorded as %q", changes["email"], auditPersonalValue)
+	}
+	if changes["role"]["before"] != "student" || changes["role"]["after"] != "handler" {
+		t.Errorf("role change = %v, want student to handler", changes["role"])
+	}
+}
+
+func TestAuditMiddlewareRecordsRolePermissions(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	createTestRole(t, "helpdesk", models.PermComplaintsReadAll)
+	var role models.Role
+	DB.Where("name = ?", "helpdesk").First(&role)
+
+	r := gin.New()
+	r.PUT("/api/roles/:id", func(c *gin.Context) {
+		c.Set("user_id", admin.ID)
+		c.Set("user_role", string(models.RoleAdmin))
+	}, auditMiddleware(), updateRole)
+	body := `{"display_name":"helpdesk","permissions":["complaints.read_all","users.manage"]}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("PUT", "/api/roles/"+strconv.Itoa(int(role.ID)), strings.NewReader(body)))
+	if w.Code != 200 {
+		t.Fatalf("update role = %d: %s", w.Code, w.Body.String())
+	}
+
+	var entry models.AuditLog
+	if err := DB.Last(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	var changes map[string]map[string][]string
+	json.Unmarshal(entry.Changes, &changes)
+	permissions := changes["permissions"]
+	if strings.Join(permissions["before"], ",") != "complaints.read_all" || strings.Join(permissions["after"], ",") != "complaints.read_all,users.manage" {
+		t.Errorf("permission change = %v, want users.manage granted: %s", permissions, entry.Changes)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+var (
+	// errUnknownUser means the authenticator does not know the user, so the
+	// next one in the chain is tried.
+	errUnknownUser = errors.New("unknown user")
+	// errInvalidCredentials means the authenticator owns the user but the
+	// password is wrong. No other authenticator is tried.
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator checks a username and password against one account source.
+type Authenticator interface {
+	Name() string
+	Authenticate(identifier, password string) (models.User, error)
+}
+
+// authenticators returns the configured account sources in the order they are
+// tried. The directory comes first so staff accounts always use it; local
+// bcrypt accounts are the fallback.
+func authenticators() []Authenticator {
+	var chain []Authenticator
+	if config.AppConfig.LDAPURL != "" {
+		chain = append(chain, ldapAuthenticator{})
+	}
+	return append(chain, localAuthenticator{})
+}
+
+// authenticateUser runs the authenticator chain. An authenticator that fails
+// for another reason (e.g. the directory is down) is logged and skipped.
+func authenticateUser(identifier, password string) (models.User, error) {
+	for _, authenticator := range authenticators() {
+		user, err := authenticator.Authenticate(identifier, password)
+		if err == nil {
+			return user, nil
+		}
+		if errors.Is(err, errInvalidCredentials) {
+			return models.User{}, err
+		}
+		if !errors.Is(err, errUnknownUser) {
+			log.Printf("Error authenticating with %s: %v", authenticator.Name(), err)
+		}
+	}
+	return models.User{}, errInvalidCredentials
+}
+
+// localAuthenticator checks the bcrypt password stored in the users table.
+type localAuthenticator struct{}
+
+func (localAuthenticator) Name() string { return "local" }
+
+func (localAuthenticator) Authenticate(identifier, password string) (models.User, error) {
+	var user models.User
+	if err := DB.Where("username = ? OR student_id = ?", identifier, identifier).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, errUnknownUser
+		}
+		return user, err
+	}
+	// Directory, SSO and service accounts only have a random placeholder password
+	if user.IsServiceAccount || (user.AuthProvider != "" && user.AuthProvider != models.AuthProviderLocal) {
+		return user, errInvalidCredentials
+	}
+	if !checkPasswordHash(password, user.Password) {
+		return user, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// ldapAuthenticator binds to the directory as the user. Users are looked up
+// with the service account first so the filter can match any attribute.
+type ldapAuthenticator struct{}
+
+func (ldapAuthenticator) Name() string { return "ldap" }
+
+func (ldapAuthenticator) Authenticate(identifier, password string) (models.User, error) {
+	// An empty password would be an unauthenticated bind, which always succeeds
+	if password == "" {
+		return models.User{}, errInvalidCredentials
+	}
+
+	conn, err := dialLDAP()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer conn.Close()
+
+	if config.AppConfig.LDAPBindDN != "" {
+		if err := conn.Bind(config.AppConfig.LDAPBindDN, config.AppConfig.LDAPBindPassword); err != nil {
+			return models.User{}, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+
+	attributes := []string{"dn", config.AppConfig.LDAPEmailAttribute, config.AppConfig.LDAPNameAttribute, config.AppConfig.LDAPGroupAttribute}
+	if config.AppConfig.LDAPStudentIDAttribute != "" {
+		attributes = append(attributes, config.AppConfig.LDAPStudentIDAttribute)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		config.AppConfig.LDAPBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(config.AppConfig.LDAPUserFilter, ldap.EscapeFilter(identifier)),
+		attributes,
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return models.User{}, errUnknownUser
+		}
+		return models.User{}, fmt.Errorf("user search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return models.User{}, errUnknownUser
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return models.User{}, errInvalidCredentials
+		}
+		return models.User{}, fmt.Errorf("user bind: %w", err)
+	}
+
+	return syncLDAPUser(identifier, entry)
+}
+
+func dialLDAP() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.AppConfig.LDAPInsecureSkipVerify}
+	if u, err := url.Parse(config.AppConfig.LDAPURL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	conn, err := ldap.DialURL(config.AppConfig.LDAPURL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	if config.AppConfig.LDAPStartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// ldapRole maps the user's directory groups to a role using LDAP_GROUP_ROLES,
+// a list of "group DN=role" pairs separated by semicolons. The first matching
+// pair wins; users in none of the groups get the default role.
+func ldapRole(groups []string) models.UserRole {
+	member := map[string]bool{}
+	for _, group := range groups {
+		member[strings.ToLower(strings.TrimSpace(group))] = true
+	}
+	for _, mapping := range strings.Split(config.AppConfig.LDAPGroupRoles, ";") {
+		i := strings.LastIndex(mapping, "=")
+		if i <= 0 {
+			continue
+		}
+		group := strings.ToLower(strings.TrimSpace(mapping[:i]))
+		role := models.UserRole(strings.TrimSpace(mapping[i+1:]))
+		if member[group] && roleExists(role) {
+			return role
+		}
+	}
+	return models.UserRole(config.AppConfig.LDAPDefaultRole)
+}
+
+// syncLDAPUser creates or updates the local record of a directory user. The
+// directory is authoritative for email, name and role; a role change signs
+// the user out of existing sessions. Accounts that do not already belong to
+// the directory are never converted, so a directory entry with the same
+// username cannot take over a local or administrative account; the chain
+// falls back to their local password instead.
+func syncLDAPUser(identifier string, entry *ldap.Entry) (models.User, error) {
+	email := entry.GetAttributeValue(config.AppConfig.LDAPEmailAttribute)
+	name := entry.GetAttributeValue(config.AppConfig.LDAPNameAttribute)
+	role := ldapRole(entry.GetAttributeValues(config.AppConfig.LDAPGroupAttribute))
+	var studentID string
+	if config.AppConfig.LDAPStudentIDAttribute != "" {
+		studentID = entry.GetAttributeValue(config.AppConfig.LDAPStudentIDAttribute)
+	}
+
+	var user models.User
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("username = ?", identifier).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Directory users never log in with a local password
+			password, err := randomToken(32)
+			if err != nil {
+				return err
+			}
+			hashedPassword, err := hashPassword(password)
+			if err != nil {
+				return err
+			}
+			user = models.User{
+				Username:     identifier,
+				StudentID:    studentIDColumn(studentID),
+				Email:        email,
+				Password:     hashedPassword,
+				Name:         name,
+				Role:         role,
+				AuthProvider: models.AuthProviderLDAP,
+			}
+			return tx.Create(&user).Error
+		}
+		if err != nil {
+			return err
+		}
+		if user.IsServiceAccount || user.AuthProvider != models.AuthProviderLDAP {
+			log.Printf("Warning: Directory user %q matches a %s account, which is not linked", identifier, user.AuthProvider)
+			return errUnknownUser
+		}
+
+		roleChanged := user.Role != role
+		updates := map[string]interface{}{
+			"role":                 role,
+			"must_change_password": false,
+		}
+		if email != "" {
+			updates["email"] = email
+		}
+		if name != "" {
+			updates["name"] = name
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if roleChanged {
+			if err := revokeUserTokens(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return tx.First(&user, user.ID).Error
+	})
+	return user, err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"testing"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// directoryEntry is a person in the stand-in directory.
+type directoryEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeDirectory is an OpenLDAP-style server answering simple binds and
+// subtree searches filtered by uid, which is all ldapAuthenticator uses.
+type fakeDirectory struct {
+	bindDN, bindPassword string
+	people               map[string]directoryEntry
+}
+
+var uidFilter = regexp.MustCompile(`\(uid=([^)]*)\)`)
+
+// start serves the directory on a local port and points the LDAP settings at it.
+func (d *fakeDirectory) start(t *testing.T) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	config.AppConfig.LDAPURL = "ldap://" + listener.Addr().String()
+	config.AppConfig.LDAPBindDN = d.bindDN
+	config.AppConfig.LDAPBindPassword = d.bindPassword
+	config.AppConfig.LDAPBaseDN = "ou=people,dc=kampus,dc=ac,dc=id"
+	config.AppConfig.LDAPStudentIDAttribute = "employeeNumber"
+	config.AppConfig.LDAPGroupRoles = "cn=staff,ou=groups,dc=kampus,dc=ac,dc=id=admin"
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if dn == d.bindDN && password == d.bindPassword {
+				code = ldap.LDAPResultSuccess
+			}
+			for _, person := range d.people {
+				if dn == person.dn && password == person.password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			conn.Write(ldapMessage(id, ldapResult(ldap.ApplicationBindResponse, code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			if match := uidFilter.FindStringSubmatch(filter); match != nil {
+				if person, ok := d.people[match[1]]; ok {
+					conn.Write(ldapMessage(id, searchEntry(person)).Bytes())
+				}
+			}
+			conn.Write(ldapMessage(id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return op
+}
+
+func searchEntry(person directoryEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, person.dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range person.attrs {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+func person(uid, password, mail string, groups ...string) directoryEntry {
+	return directoryEntry{
+		dn:       "uid=" + uid + ",ou=people,dc=kampus,dc=ac,dc=id",
+		password: password,
+		attrs: map[string][]string{
+			"mail":           {mail},
+			"cn":             {uid + " from LDAP"},
+			"employeeNumber": {"E-" + uid},
+			"memberOf":       groups,
+		},
+	}
+}
+
+func TestLDAPAuthenticatorSync(t *testing.T) {
+	setupTestDB(t)
+	staff := "cn=staff,ou=groups,dc=kampus,dc=ac,dc=id"
+	directory := &fakeDirectory{
+		bindDN:       "cn=admin,dc=kampus,dc=ac,dc=id",
+		bindPassword: "secret",
+		people: map[string]directoryEntry{
+			"dosen":      person("dosen", "dir-pass", "dosen@kampus.ac.id", staff),
+			"mahasiswa":  person("mahasiswa", "dir-pass", "mahasiswa@kampus.ac.id"),
+			"localadmin": person("localadmin", "dir-pass", "attacker@kampus.ac.id", staff),
+			"localuser":  person("localuser", "dir-pass", "attacker2@kampus.ac.id", staff),
+		},
+	}
+	directory.start(t)
+	localAdmin := createTestUser(t, "localadmin", models.RoleAdmin)
+	localUser := createTestUser(t, "localuser", models.RoleStudent)
+
+	t.Run("new directory users are created", func(t *testing.T) {
+		user, err := authenticateUser("dosen", "dir-pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.AuthProvider != models.AuthProviderLDAP || user.Role != models.RoleAdmin || user.Email != "dosen@kampus.ac.id" {
+			t.Errorf("created %+v", user)
+		}
+		user, err = authenticateUser("mahasiswa", "dir-pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != models.RoleStudent {
+			t.Errorf("role = %s, want the default role", user.Role)
+		}
+	})
+
+	t.Run("directory users are synced", func(t *testing.T) {
+		directory.people["mahasiswa"] = person("mahasiswa", "dir-pass", "baru@kampus.ac.id", staff)
+		user, err := authenticateUser("mahasiswa", "dir-pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "baru@kampus.ac.id" || user.Role != models.RoleAdmin {
+			t.Errorf("synced %+v", user)
+		}
+	})
+
+	t.Run("wrong directory password", func(t *testing.T) {
+		if _, err := authenticateUser("dosen", "wrong"); !errors.Is(err, errInvalidCredentials) {
+			t.Errorf("err = %v, want invalid credentials", err)
+		}
+	})
+
+	t.Run("local accounts are not converted", func(t *testing.T) {
+		for _, local := range []models.User{localAdmin, localUser} {
+			if _, err := authenticateUser(local.Username, "dir-pass"); !errors.Is(err, errInvalidCredentials) {
+				t.Errorf("%s: err = %v, want invalid credentials", local.Username, err)
+			}
+			var stored models.User
+			DB.First(&stored, local.ID)
+			if stored.AuthProvider != models.AuthProviderLocal || stored.Role != local.Role || stored.Email != local.Email {
+				t.Errorf("%s was changed to %+v", local.Username, stored)
+			}
+		}
+	})
+}
+
+func TestLDAPUsersWithoutStudentID(t *testing.T) {
+	setupTestDB(t)
+	directory := &fakeDirectory{
+		bindDN:       "cn=admin,dc=kampus,dc=ac,dc=id",
+		bindPassword: "secret",
+		people:       map[string]directoryEntry{},
+	}
+	for _, uid := range []string{"staf1", "staf2"} {
+		entry := person(uid, "dir-pass", uid+"@kampus.ac.id")
+		delete(entry.attrs, "employeeNumber")
+		directory.people[uid] = entry
+	}
+	directory.start(t)
+	// The default: no student ID attribute is read
+	config.AppConfig.LDAPStudentIDAttribute = ""
+
+	for _, uid := range []string{"staf1", "staf2"} {
+		user, err := authenticateUser(uid, "dir-pass")
+		if err != nil {
+			t.Fatalf("%s: %v", uid, err)
+		}
+		if user.StudentID != nil {
+			t.Errorf("%s: student ID = %q, want none", uid, *user.StudentID)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strings"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Placeholders that can be used in a canned response body
+var cannedResponsePlaceholders = []string{
+	"{{student_name}}",
+	"{{ticket_id}}",
+	"{{category}}",
+	"{{complaint_title}}",
+	"{{status}}",
+}
+
+type CannedResponseRequest struct {
+	Title      string `json:"title" binding:"required"`
+	Body       string `json:"body" binding:"required"`
+	CategoryID *uint  `json:"category_id"`
+}
+
+type ApplyCannedResponseRequest struct {
+	CannedResponseID uint   `json:"canned_response_id" binding:"required"`
+	Status           string `json:"status" binding:"omitempty,oneof=pending in_process completed rejected"`
+}
+
+// renderCannedResponse fills in the placeholders of a canned response body for
+// the given complaint. The complaint must have its User and Category loaded.
+func renderCannedResponse(body string, complaint models.Complaint) string {
+	studentName := complaint.User.Name
+	if studentName == "" {
+		studentName = complaint.User.Username
+	}
+	replacer := strings.NewReplacer(
+		"{{student_name}}", studentName,
+		"{{ticket_id}}", complaint.TicketID,
+		"{{category}}", complaint.Category.Name,
+		"{{complaint_title}}", complaint.Title,
+		"{{status}}", getStatusTextForNotification(complaint.Status),
+	)
+	return replacer.Replace(body)
+}
+
+func getCannedResponses(c *gin.Context) {
+	var responses []models.CannedResponse
+	query := DB.Preload("Category").Preload("CreatedBy")
+
+	// Filter by category, including responses that apply to every category
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("category_id = ? OR category_id IS NULL", categoryID)
+	}
+	if search := c.Query("search"); search != "" {
+		query = query.Where("title LIKE ? OR body LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+
+	if err := query.Order("title ASC").Find(&responses).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch canned responses"})
+		return
+	}
+
+	// Group by category name for the response picker
+	groups := map[string][]models.CannedResponse{}
+	for _, response := range responses {
+		group := "General"
+		if response.Category != nil {
+			group = response.Category.Name
+		}
+		groups[group] = append(groups[group], response)
+	}
+
+	c.JSON(200, gin.H{
+		"data":         responses,
+		"groups":       groups,
+		"placeholders": cannedResponsePlaceholders,
+	})
+}
+
+func getCannedResponse(c *gin.Context) {
+	var response models.CannedResponse
+	if err := DB.Preload("Category").Preload("CreatedBy").First(&response, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Canned response not found"})
+		return
+	}
+	c.JSON(200, response)
+}
+
+func createCannedResponse(c *gin.Context) {
+	var req CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.CategoryID != nil {
+		var category models.Category
+		if err := DB.First(&category, *req.CategoryID).Error; err != nil {
+			c.JSON(400, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	response := models.CannedResponse{
+		Title:       req.Title,
+		Body:        req.Body,
+		CategoryID:  req.CategoryID,
+		CreatedByID: getUserID(c),
+	}
+	if err := DB.Create(&response).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create canned response"})
+		return
+	}
+
+	DB.Preload("Category").Preload("CreatedBy").First(&response, response.ID)
+	c.JSON(201, response)
+}
+
+func updateCannedResponse(c *gin.Context) {
+	var response models.CannedResponse
+	if err := DB.First(&response, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Canned response not found"})
+		return
+	}
+
+	var req CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.CategoryID != nil {
+		var category models.Category
+		if err := DB.First(&category, *req.CategoryID).Error; err != nil {
+			c.JSON(400, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	response.Title = req.Title
+	response.Body = req.Body
+	response.CategoryID = req.CategoryID
+	if err := DB.Save(&response).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update canned response"})
+		return
+	}
+
+	DB.Preload("Category").Preload("CreatedBy").First(&response, response.ID)
+	c.JSON(200, response)
+}
+
+func deleteCannedResponse(c *gin.Context) {
+	result := DB.Delete(&models.CannedResponse{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete canned response"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Canned response not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Canned response deleted successfully"})
+}
+
+// applyCannedResponse renders a canned response for a complaint and saves it
+// as the complaint's admin response, notifying the reporter as a normal update.
+func applyCannedResponse(c *gin.Context) {
+	var req ApplyCannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var complaint models.Complaint
+	if err := DB.Preload("User").Preload("Category").First(&complaint, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+	if complaint.Status == models.StatusMerged {
+		c.JSON(400, gin.H{"error": errMergedComplaint})
+		return
+	}
+
+	var response models.CannedResponse
+	if err := DB.First(&response, req.CannedResponseID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Canned response not found"})
+		return
+	}
+
+	rendered := renderCannedResponse(response.Body, complaint)
+	if c.Query("preview") == "true" {
+		c.JSON(200, gin.H{"admin_response": rendered})
+		return
+	}
+
+	// Drop the preloaded associations so Save only touches the complaint row
+	complaint.User = models.User{}
+	complaint.Category = models.Category{}
+	if err := applyComplaintUpdate(&complaint, UpdateComplaintRequest{Status: req.Status, AdminResponse: rendered}, getUserID(c)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update complaint"})
+		return
+	}
+
+	c.JSON(200, complaint)
+}
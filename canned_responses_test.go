@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"simplee-k/models"
+)
+
+func TestDeleteCannedResponse(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	response := models.CannedResponse{Title: "Diterima", Body: "Terima kasih, {{name}}.", CreatedByID: admin.ID}
+	DB.Create(&response)
+
+	route := "/api/canned-responses/:id"
+	path := fmt.Sprintf("/api/canned-responses/%d", response.ID)
+	if w := callAs(deleteCannedResponse, admin.ID, "admin", "DELETE", route, "/api/canned-responses/999", ""); w.Code != 404 {
+		t.Errorf("missing canned response = %d, want 404", w.Code)
+	}
+	if w := callAs(deleteCannedResponse, admin.ID, "admin", "DELETE", route, path, ""); w.Code != 200 {
+		t.Fatalf("delete = %d: %s", w.Code, w.Body.String())
+	}
+	if w := callAs(deleteCannedResponse, admin.ID, "admin", "DELETE", route, path, ""); w.Code != 404 {
+		t.Errorf("second delete = %d, want 404", w.Code)
+	}
+}
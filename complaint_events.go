@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// durationStats summarizes the time from submitting complaints to an event,
+// in seconds. Median and P90 use the nearest-rank method.
+type durationStats struct {
+	GroupID *uint
+	Count   int64
+	Mean    float64
+	Median  float64
+	P90     float64
+}
+
+// recordComplaintEvent stores a status change or response on a complaint.
+func recordComplaintEvent(tx *gorm.DB, complaintID uint, eventType models.ComplaintEventType, from, to models.ComplaintStatus, actorID *uint) error {
+	return tx.Create(&models.ComplaintEvent{
+		ComplaintID: complaintID,
+		Type:        eventType,
+		FromStatus:  from,
+		ToStatus:    to,
+		ActorID:     actorID,
+	}).Error
+}
+
+// backfillComplaintEvents gives complaints that were handled before events
+// were recorded one event at their last update, the best estimate there is.
+func backfillComplaintEvents() {
+	if err := DB.Exec(`INSERT INTO complaint_events (complaint_id, type, from_status, to_status, created_at)
+		SELECT id, CASE WHEN status <> ? THEN ? ELSE ? END, '', status, updated_at FROM complaints
+		WHERE (status <> ? OR admin_response <> '')
+		AND NOT EXISTS (SELECT 1 FROM complaint_events WHERE complaint_events.complaint_id = complaints.id)`,
+		models.StatusPending, models.ComplaintEventStatus, models.ComplaintEventResponse, models.StatusPending).Error; err != nil {
+		log.Printf("Error backfilling complaint events: %v", err)
+	}
+}
+
+// complaintDurationStats aggregates the time from submitting each complaint
+// created in the period to its resolution or first response, grouped by the
+// given column ("0" for no grouping). Resolution is the last time a complaint
+// was completed, so a reopened complaint counts until it was completed again,
+// and only complaints that are still completed count. The database only
+// selects the complaints and events; the durations are computed here, so
+// they do not depend on date and window functions of a particular database.
+func complaintDurationStats(c *gin.Context, start, end time.Time, resolution bool, groupBy string) ([]durationStats, error) {
+	inPeriod := func() *gorm.DB {
+		query := DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).
+			Where("complaints.created_at >= ? AND complaints.created_at < ?", start, end)
+		if resolution {
+			query = query.Where("complaints.status = ?", models.StatusCompleted)
+		}
+		return query
+	}
+
+	var complaints []struct {
+		ID        uint
+		GroupID   *uint
+		CreatedAt time.Time
+	}
+	if err := inPeriod().
+		Select(fmt.Sprintf("complaints.id AS id, %s AS group_id, complaints.created_at AS created_at", groupBy)).
+		Scan(&complaints).Error; err != nil {
+		return nil, err
+	}
+
+	events := DB.Model(&models.ComplaintEvent{}).Select("complaint_id, created_at").
+		Where("complaint_id IN (?)", inPeriod().Select("complaints.id"))
+	if resolution {
+		events = events.Where("type = ? AND to_status = ?", models.ComplaintEventStatus, models.StatusCompleted)
+	}
+	var eventTimes []struct {
+		ComplaintID uint
+		CreatedAt   time.Time
+	}
+	if err := events.Scan(&eventTimes).Error; err != nil {
+		return nil, err
+	}
+	eventAt := map[uint]time.Time{}
+	for _, event := range eventTimes {
+		at, seen := eventAt[event.ComplaintID]
+		if !seen || (resolution && event.CreatedAt.After(at)) || (!resolution && event.CreatedAt.Before(at)) {
+			eventAt[event.ComplaintID] = event.CreatedAt
+		}
+	}
+
+	// Complaints without a category or assignee share the nil group
+	type groupKey struct {
+		valid bool
+		id    uint
+	}
+	seconds := map[groupKey][]float64{}
+	for _, complaint := range complaints {
+		at, ok := eventAt[complaint.ID]
+		if !ok {
+			continue
+		}
+		key := groupKey{}
+		if complaint.GroupID != nil {
+			key = groupKey{valid: true, id: *complaint.GroupID}
+		}
+		elapsed := int64(at.Sub(complaint.CreatedAt) / time.Second)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		seconds[key] = append(seconds[key], float64(elapsed))
+	}
+
+	keys := make([]groupKey, 0, len(seconds))
+	for key := range seconds {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].valid != keys[j].valid {
+			return !keys[i].valid
+		}
+		return keys[i].id < keys[j].id
+	})
+	stats := make([]durationStats, len(keys))
+	for i, key := range keys {
+		values := seconds[key]
+		sort.Float64s(values)
+		total := 0.0
+		for _, value := range values {
+			total += value
+		}
+		stats[i] = durationStats{
+			Count:  int64(len(values)),
+			Mean:   total / float64(len(values)),
+			Median: nearestRank(values, 50),
+			P90:    nearestRank(values, 90),
+		}
+		if key.valid {
+			id := key.id
+			stats[i].GroupID = &id
+		}
+	}
+	return stats, nil
+}
+
+// nearestRank returns the given percentile of sorted values: the smallest
+// value that at least that percentage of the values does not exceed.
+func nearestRank(sorted []float64, percent int) float64 {
+	rank := (len(sorted)*percent + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func overallDuration(stats []durationStats) durationStats {
+	if len(stats) == 0 {
+		return durationStats{}
+	}
+	return stats[0]
+}
+
+func hours(seconds float64) float64 {
+	return math.Round(seconds/36) / 100
+}
+
+func durationResponse(stats durationStats) gin.H {
+	return gin.H{
+		"count":        stats.Count,
+		"mean_hours":   hours(stats.Mean),
+		"median_hours": hours(stats.Median),
+		"p90_hours":    hours(stats.P90),
+	}
+}
+
+// durationBreakdown returns the overall stats and those per category and
+// assignee, for resolution or first response.
+func durationBreakdown(c *gin.Context, start, end time.Time, resolution bool) (gin.H, error) {
+	var categories []models.Category
+	if err := DB.Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	categoryNames := map[uint]string{}
+	for _, category := range categories {
+		categoryNames[category.ID] = category.Name
+	}
+
+	categoryStats, err := complaintDurationStats(c, start, end, resolution, "complaints.category_id")
+	if err != nil {
+		return nil, err
+	}
+	byCategory := []gin.H{}
+	for _, stats := range categoryStats {
+		row := durationResponse(stats)
+		row["category_id"] = stats.GroupID
+		if stats.GroupID != nil {
+			row["category_name"] = categoryNames[*stats.GroupID]
+		}
+		byCategory = append(byCategory, row)
+	}
+
+	assigneeStats, err := complaintDurationStats(c, start, end, resolution, "complaints.assigned_to_id")
+	if err != nil {
+		return nil, err
+	}
+	var assigneeIDs []uint
+	for _, stats := range assigneeStats {
+		if stats.GroupID != nil {
+			assigneeIDs = append(assigneeIDs, *stats.GroupID)
+		}
+	}
+	assigneeNames := map[uint]string{}
+	if len(assigneeIDs) > 0 {
+		var assignees []models.User
+		if err := DB.Unscoped().Where("id IN ?", assigneeIDs).Find(&assignees).Error; err != nil {
+			return nil, err
+		}
+		for _, assignee := range assignees {
+			assigneeNames[assignee.ID] = assignee.Name
+		}
+	}
+	byAssignee := []gin.H{}
+	for _, stats := range assigneeStats {
+		row := durationResponse(stats)
+		// A nil assignee groups the unassigned complaints
+		row["assignee_id"] = stats.GroupID
+		if stats.GroupID != nil {
+			row["assignee_name"] = assigneeNames[*stats.GroupID]
+		}
+		byAssignee = append(byAssignee, row)
+	}
+
+	overall, err := complaintDurationStats(c, start, end, resolution, "0")
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"overall":     durationResponse(overallDuration(overall)),
+		"by_category": byCategory,
+		"by_assignee": byAssignee,
+	}, nil
+}
+
+// getResolutionTimes reports how long complaints submitted between
+// start_date and end_date took to be resolved and to get a first response.
+func getResolutionTimes(c *gin.Context) {
+	start, end, ok := reportDateRange(c)
+	if !ok {
+		return
+	}
+	resolution, err := durationBreakdown(c, start, end, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to compute resolution times"})
+		return
+	}
+	firstResponse, err := durationBreakdown(c, start, end, false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to compute resolution times"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"resolution":     resolution,
+		"first_response": firstResponse,
+		"start_date":     start.Format("2006-01-02"),
+		"end_date":       end.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"simplee-k/models"
+)
+
+// createTimedComplaint stores a complaint submitted at the given time with
+// status events at the given offsets, in hours, to the given statuses.
+func createTimedComplaint(t *testing.T, ticketID string, userID, categoryID uint, status models.ComplaintStatus, submitted time.Time, events map[float64]models.ComplaintStatus) models.Complaint {
+	t.Helper()
+	complaint := models.Complaint{
+		TicketID:    ticketID,
+		UserID:      userID,
+		CategoryID:  categoryID,
+		Title:       ticketID,
+		Description: ticketID,
+		Status:      status,
+		CreatedAt:   submitted,
+		UpdatedAt:   submitted,
+	}
+	if err := DB.Create(&complaint).Error; err != nil {
+		t.Fatal(err)
+	}
+	for offset, to := range events {
+		event := models.ComplaintEvent{
+			ComplaintID: complaint.ID,
+			Type:        models.ComplaintEventStatus,
+			ToStatus:    to,
+			CreatedAt:   submitted.Add(time.Duration(offset * float64(time.Hour))),
+		}
+		if err := DB.Create(&event).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return complaint
+}
+
+func TestGetResolutionTimes(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	student := createTestUser(t, "student", models.RoleStudent)
+	facilities := models.Category{Name: "Fasilitas", Slug: "fasilitas"}
+	DB.Create(&facilities)
+	academic := models.Category{Name: "Akademik", Slug: "akademik"}
+	DB.Create(&academic)
+	submitted := localTime("2026-03-02 08:00")
+
+	// Reopened, so it counts until it was completed again
+	reopened := createTimedComplaint(t, "FAS-0001", student.ID, facilities.ID, models.StatusCompleted, submitted, map[float64]models.ComplaintStatus{
+		4: models.StatusCompleted, 5: models.StatusInProcess, 10: models.StatusCompleted,
+	})
+	DB.Create(&models.ComplaintEvent{ComplaintID: reopened.ID, Type: models.ComplaintEventResponse, CreatedAt: submitted.Add(time.Hour)})
+	createTimedComplaint(t, "FAS-0002", student.ID, facilities.ID, models.StatusCompleted, submitted, map[float64]models.ComplaintStatus{
+		2: models.StatusCompleted,
+	})
+	createTimedComplaint(t, "FAS-0003", student.ID, facilities.ID, models.StatusCompleted, submitted, map[float64]models.ComplaintStatus{
+		6: models.StatusCompleted,
+	})
+	// Not completed, so only its first response counts
+	createTimedComplaint(t, "AKA-0001", student.ID, academic.ID, models.StatusInProcess, submitted, map[float64]models.ComplaintStatus{
+		3: models.StatusInProcess,
+	})
+	// Submitted before the period
+	createTimedComplaint(t, "AKA-0002", student.ID, academic.ID, models.StatusCompleted, submitted.AddDate(0, 0, -5), map[float64]models.ComplaintStatus{
+		100: models.StatusCompleted,
+	})
+
+	w := callAs(getResolutionTimes, admin.ID, string(models.RoleAdmin), "GET", "/reports/resolution-times",
+		"/reports/resolution-times?start_date=2026-03-01&end_date=2026-03-31", "")
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	type durations struct {
+		CategoryID  *uint   `json:"category_id"`
+		AssigneeID  *uint   `json:"assignee_id"`
+		Count       int64   `json:"count"`
+		MeanHours   float64 `json:"mean_hours"`
+		MedianHours float64 `json:"median_hours"`
+		P90Hours    float64 `json:"p90_hours"`
+	}
+	var body struct {
+		Resolution struct {
+			Overall    durations   `json:"overall"`
+			ByCategory []durations `json:"by_category"`
+			ByAssignee []durations `json:"by_assignee"`
+		} `json:"resolution"`
+		FirstResponse struct {
+			Overall    durations   `json:"overall"`
+			ByCategory []durations `json:"by_category"`
+		} `json:"first_response"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resolution hours 2, 6 and 10
+	if got, want := body.Resolution.Overall, (durations{Count: 3, MeanHours: 6, MedianHours: 6, P90Hours: 10}); got != want {
+		t.Errorf("resolution = %+v, want %+v", got, want)
+	}
+	if len(body.Resolution.ByCategory) != 1 || *body.Resolution.ByCategory[0].CategoryID != facilities.ID {
+		t.Errorf("resolution by category = %+v", body.Resolution.ByCategory)
+	}
+	if len(body.Resolution.ByAssignee) != 1 || body.Resolution.ByAssignee[0].AssigneeID != nil || body.Resolution.ByAssignee[0].Count != 3 {
+		t.Errorf("resolution by assignee = %+v", body.Resolution.ByAssignee)
+	}
+	// First response hours 1, 2, 3 and 6
+	if got, want := body.FirstResponse.Overall, (durations{Count: 4, MeanHours: 3, MedianHours: 2, P90Hours: 6}); got != want {
+		t.Errorf("first response = %+v, want %+v", got, want)
+	}
+	if len(body.FirstResponse.ByCategory) != 2 {
+		t.Fatalf("first response by category = %+v", body.FirstResponse.ByCategory)
+	}
+	for _, stats := range body.FirstResponse.ByCategory {
+		if *stats.CategoryID == academic.ID && (stats.Count != 1 || stats.MedianHours != 3) {
+			t.Errorf("first response of %s = %+v", academic.Name, stats)
+		}
+	}
+}
+
+func TestNearestRank(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	if got := nearestRank(values, 50); got != 10 {
+		t.Errorf("median = %v, want 10", got)
+	}
+	if got := nearestRank(values, 90); got != 18 {
+		t.Errorf("p90 = %v, want 18", got)
+	}
+	if got := nearestRank([]float64{7}, 90); got != 7 {
+		t.Errorf("p90 of one value = %v, want 7", got)
+	}
+}
+
+func TestGetResolutionTimesReportsQueryErrors(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	if err := DB.Migrator().DropTable(&models.ComplaintEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := callAs(getResolutionTimes, admin.ID, string(models.RoleAdmin), "GET", "/reports/resolution-times", "/reports/resolution-times", "")
+	if w.Code != 500 {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	w = callAs(getReportStats, admin.ID, string(models.RoleAdmin), "GET", "/reports/stats", "/reports/stats", "")
+	if w.Code != 500 {
+		t.Errorf("stats status = %d, want 500", w.Code)
+	}
+}
+
+func TestGetComplaintTrendsCountsCompletionEvents(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	student := createTestUser(t, "student", models.RoleStudent)
+	category := models.Category{Name: "Fasilitas", Slug: "fasilitas"}
+	DB.Create(&category)
+
+	complaint := createTimedComplaint(t, "FAS-0001", student.ID, category.ID, models.StatusCompleted, localTime("2026-03-02 12:00"), map[float64]models.ComplaintStatus{
+		24: models.StatusCompleted,
+	})
+	// A later edit must not move the resolution to that day
+	DB.Model(&complaint).UpdateColumn("updated_at", localTime("2026-03-05 12:00"))
+
+	w := callAs(getComplaintTrends, admin.ID, string(models.RoleAdmin), "GET", "/reports/trends",
+		"/reports/trends?start_date=2026-03-01&end_date=2026-03-31", "")
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Submissions []dailyCount `json:"submissions"`
+		Resolved    []dailyCount `json:"resolved"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Submissions) != 1 || body.Submissions[0] != (dailyCount{Date: "2026-03-02", Count: 1}) {
+		t.Errorf("submissions = %+v", body.Submissions)
+	}
+	if len(body.Resolved) != 1 || body.Resolved[0] != (dailyCount{Date: "2026-03-03", Count: 1}) {
+		t.Errorf("resolved = %+v", body.Resolved)
+	}
+}
@@ -15,11 +15,60 @@ type Config struct {
 	DBPassword        string
 	DBName            string
 	JWTSecret         string
-	JWTExpirationHours int
+	AccessTokenMinutes int
+	RefreshTokenDays   int
+	ImpersonationMinutes int
 	ServerPort        string
 	ServerHost        string
 	UploadDir         string
 	MaxUploadSize     int64
+	TicketPrefix            string
+	TicketPrefixPerCategory bool
+	TicketSequenceDigits    int
+	AppBaseURL              string
+	PasswordMinLength       int
+	PasswordDenyListFile    string
+	PasswordResetMinutes    int
+	InvitationDays          int
+	EmailVerificationHours  int
+	RegistrationEnabled     bool
+	RegistrationEmailDomains string
+	RegistrationRequireApproval bool
+	SMTPHost                string
+	SMTPPort                string
+	SMTPUsername            string
+	SMTPPassword            string
+	SMTPFrom                string
+	ReportScheduleHour      int
+	ReportRecipientDomains  string
+	LoginMaxFailures        int
+	LoginIPMaxFailures      int
+	LoginLockoutMinutes     int
+	LoginBackoffMaxSeconds  int
+	RequireAdminTOTP        bool
+	TOTPIssuer              string
+	OIDCIssuer              string
+	OIDCClientID            string
+	OIDCClientSecret        string
+	OIDCRedirectURL         string
+	OIDCScopes              string
+	OIDCUsernameClaim       string
+	OIDCEmailClaim          string
+	OIDCNameClaim           string
+	OIDCStudentIDClaim      string
+	LDAPURL                 string
+	LDAPStartTLS            bool
+	LDAPInsecureSkipVerify  bool
+	LDAPBindDN              string
+	LDAPBindPassword        string
+	LDAPBaseDN              string
+	LDAPUserFilter          string
+	LDAPEmailAttribute      string
+	LDAPNameAttribute       string
+	LDAPStudentIDAttribute  string
+	LDAPGroupAttribute      string
+	LDAPGroupRoles          string
+	LDAPDefaultRole         string
 }
 
 var AppConfig *Config
@@ -37,11 +86,60 @@ func LoadConfig() {
 		DBPassword:        getEnv("DB_PASSWORD", ""),
 		DBName:            getEnv("DB_NAME", "simplee_k"),
 		JWTSecret:         getEnv("JWT_SECRET", "your-secret-key-change-this-in-production"),
-		JWTExpirationHours: getEnvAsInt("JWT_EXPIRATION_HOURS", 24),
+		AccessTokenMinutes: getEnvAsInt("ACCESS_TOKEN_MINUTES", 15),
+		RefreshTokenDays:   getEnvAsInt("REFRESH_TOKEN_DAYS", 30),
+		ImpersonationMinutes: getEnvAsInt("IMPERSONATION_MINUTES", 30),
 		ServerPort:        getEnv("SERVER_PORT", "8080"),
 		ServerHost:        getEnv("SERVER_HOST", "localhost"),
 		UploadDir:         getEnv("UPLOAD_DIR", "uploads"),
 		MaxUploadSize:     int64(getEnvAsInt("MAX_UPLOAD_SIZE", 5242880)),
+		TicketPrefix:            getEnv("TICKET_PREFIX", "TKT"),
+		TicketPrefixPerCategory: getEnvAsBool("TICKET_PREFIX_PER_CATEGORY", true),
+		TicketSequenceDigits:    getEnvAsInt("TICKET_SEQUENCE_DIGITS", 6),
+		AppBaseURL:              getEnv("APP_BASE_URL", "http://localhost:8080"),
+		PasswordMinLength:       getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordDenyListFile:    getEnv("PASSWORD_DENY_LIST_FILE", ""),
+		PasswordResetMinutes:    getEnvAsInt("PASSWORD_RESET_MINUTES", 60),
+		InvitationDays:          getEnvAsInt("INVITATION_DAYS", 7),
+		EmailVerificationHours:  getEnvAsInt("EMAIL_VERIFICATION_HOURS", 24),
+		RegistrationEnabled:     getEnvAsBool("REGISTRATION_ENABLED", false),
+		RegistrationEmailDomains: getEnv("REGISTRATION_EMAIL_DOMAINS", ""),
+		RegistrationRequireApproval: getEnvAsBool("REGISTRATION_REQUIRE_APPROVAL", false),
+		SMTPHost:                getEnv("SMTP_HOST", ""),
+		SMTPPort:                getEnv("SMTP_PORT", "25"),
+		SMTPUsername:            getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:                getEnv("SMTP_FROM", "noreply@simplee-k.local"),
+		ReportScheduleHour:      getEnvAsInt("REPORT_SCHEDULE_HOUR", 7),
+		ReportRecipientDomains:  getEnv("REPORT_RECIPIENT_DOMAINS", ""),
+		LoginMaxFailures:        getEnvAsInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:      getEnvAsInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginLockoutMinutes:     getEnvAsInt("LOGIN_LOCKOUT_MINUTES", 15),
+		LoginBackoffMaxSeconds:  getEnvAsInt("LOGIN_BACKOFF_MAX_SECONDS", 60),
+		RequireAdminTOTP:        getEnvAsBool("REQUIRE_ADMIN_TOTP", false),
+		TOTPIssuer:              getEnv("TOTP_ISSUER", "SIMPEL-K"),
+		OIDCIssuer:              getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:            getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:        getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:         getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback"),
+		OIDCScopes:              getEnv("OIDC_SCOPES", "openid email profile"),
+		OIDCUsernameClaim:       getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		OIDCEmailClaim:          getEnv("OIDC_EMAIL_CLAIM", "email"),
+		OIDCNameClaim:           getEnv("OIDC_NAME_CLAIM", "name"),
+		OIDCStudentIDClaim:      getEnv("OIDC_STUDENT_ID_CLAIM", "student_number"),
+		LDAPURL:                 getEnv("LDAP_URL", ""),
+		LDAPStartTLS:            getEnvAsBool("LDAP_START_TLS", false),
+		LDAPInsecureSkipVerify:  getEnvAsBool("LDAP_INSECURE_SKIP_VERIFY", false),
+		LDAPBindDN:              getEnv("LDAP_BIND_DN", ""),
+		LDAPBindPassword:        getEnv("LDAP_BIND_PASSWORD", ""),
+		LDAPBaseDN:              getEnv("LDAP_BASE_DN", ""),
+		LDAPUserFilter:          getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(uid=%s))"),
+		LDAPEmailAttribute:      getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPNameAttribute:       getEnv("LDAP_NAME_ATTRIBUTE", "cn"),
+		LDAPStudentIDAttribute:  getEnv("LDAP_STUDENT_ID_ATTRIBUTE", ""),
+		LDAPGroupAttribute:      getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		LDAPGroupRoles:          getEnv("LDAP_GROUP_ROLES", ""),
+		LDAPDefaultRole:         getEnv("LDAP_DEFAULT_ROLE", "student"),
 	}
 
 	// Create upload directory if it doesn't exist
@@ -65,3 +163,10 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
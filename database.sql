@@ -16,14 +16,34 @@ CREATE TABLE IF NOT EXISTS users (
     email VARCHAR(255) UNIQUE,
     password VARCHAR(255) NOT NULL,
     name VARCHAR(255),
-    role ENUM('admin', 'student') DEFAULT 'student',
+    role VARCHAR(50) NOT NULL DEFAULT 'student',
     phone VARCHAR(255),
+    token_version BIGINT NOT NULL DEFAULT 0,
+    must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_secret VARCHAR(64),
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_counter BIGINT NOT NULL DEFAULT 0,
+    auth_provider VARCHAR(20) NOT NULL DEFAULT 'local',
+    oidc_subject VARCHAR(255) UNIQUE,
+    is_service_account BOOLEAN NOT NULL DEFAULT FALSE,
+    org_unit_id BIGINT UNSIGNED NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    deactivated_at TIMESTAMP NULL,
+    avatar_url VARCHAR(255),
+    email_verified_at TIMESTAMP NULL,
+    notify_complaint_updates BOOLEAN NOT NULL DEFAULT TRUE,
+    notify_new_complaints BOOLEAN NOT NULL DEFAULT TRUE,
+    notify_announcements BOOLEAN NOT NULL DEFAULT TRUE,
+    registration_status VARCHAR(20) NOT NULL DEFAULT '',
+    erased_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP NULL,
     INDEX idx_username (username),
     INDEX idx_student_id (student_id),
     INDEX idx_email (email),
+    INDEX idx_is_active (is_active),
+    INDEX idx_registration_status (registration_status),
     INDEX idx_deleted_at (deleted_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
@@ -32,6 +52,8 @@ CREATE TABLE IF NOT EXISTS categories (
     id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL UNIQUE,
     slug VARCHAR(255) NOT NULL UNIQUE,
+    ticket_prefix VARCHAR(10),
+    org_unit_id BIGINT UNSIGNED NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX idx_slug (slug)
@@ -45,9 +67,14 @@ CREATE TABLE IF NOT EXISTS complaints (
     category_id BIGINT UNSIGNED NOT NULL,
     title VARCHAR(255) NOT NULL,
     description TEXT NOT NULL,
-    status ENUM('pending', 'in_process', 'completed', 'rejected') DEFAULT 'pending',
+    status ENUM('pending', 'in_process', 'completed', 'rejected', 'merged') DEFAULT 'pending',
     admin_response TEXT,
     evidence_path VARCHAR(500),
+    priority ENUM('low', 'normal', 'high', 'urgent') DEFAULT 'normal',
+    tags VARCHAR(255),
+    assigned_to_id BIGINT UNSIGNED NULL,
+    merged_into_id BIGINT UNSIGNED NULL,
+    triage_rules TEXT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP NULL,
@@ -55,9 +82,13 @@ CREATE TABLE IF NOT EXISTS complaints (
     INDEX idx_category_id (category_id),
     INDEX idx_ticket_id (ticket_id),
     INDEX idx_status (status),
+    INDEX idx_assigned_to_id (assigned_to_id),
+    INDEX idx_merged_into_id (merged_into_id),
     INDEX idx_deleted_at (deleted_at),
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE RESTRICT
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE RESTRICT,
+    FOREIGN KEY (assigned_to_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (merged_into_id) REFERENCES complaints(id) ON DELETE SET NULL
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- 5. Tabel Announcements
@@ -73,7 +104,7 @@ CREATE TABLE IF NOT EXISTS announcements (
     INDEX idx_author_id (author_id),
     INDEX idx_status (status),
     INDEX idx_deleted_at (deleted_at),
-    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE
+    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE RESTRICT
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- 6. Tabel Notifications
@@ -93,16 +124,370 @@ CREATE TABLE IF NOT EXISTS notifications (
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
--- 7. Insert Data Categories
-INSERT INTO categories (name, slug) VALUES
-('Facilities', 'facilities'),
-('Academics', 'academics'),
-('IT Support', 'it_support'),
-('Security', 'security'),
-('Services', 'services'),
-('Cleanliness', 'cleanliness'),
-('Network', 'network'),
-('General', 'general'),
-('Other', 'other')
+-- 7. Tabel Canned Responses
+CREATE TABLE IF NOT EXISTS canned_responses (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    category_id BIGINT UNSIGNED NULL,
+    created_by_id BIGINT UNSIGNED NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    INDEX idx_category_id (category_id),
+    INDEX idx_created_by_id (created_by_id),
+    INDEX idx_deleted_at (deleted_at),
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE SET NULL,
+    FOREIGN KEY (created_by_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 8. Tabel Triage Rules
+CREATE TABLE IF NOT EXISTS triage_rules (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    position BIGINT NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    field ENUM('title', 'description', 'any') DEFAULT 'any',
+    match_type ENUM('keyword', 'regex') DEFAULT 'keyword',
+    pattern TEXT NOT NULL,
+    category_id BIGINT UNSIGNED NULL,
+    set_category_id BIGINT UNSIGNED NULL,
+    set_priority VARCHAR(255),
+    add_tags VARCHAR(255),
+    assign_to_id BIGINT UNSIGNED NULL,
+    stop_processing BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_position (position)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 9. Tabel Ticket Sequences
+CREATE TABLE IF NOT EXISTS ticket_sequences (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    prefix VARCHAR(10) NOT NULL,
+    year BIGINT NOT NULL,
+    last_value BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE INDEX idx_ticket_sequence (prefix, year)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 10. Tabel Complaint Watchers
+CREATE TABLE IF NOT EXISTS complaint_watchers (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    complaint_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    added_by_id BIGINT UNSIGNED,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX idx_complaint_watcher (complaint_id, user_id),
+    INDEX idx_user_id (user_id),
+    FOREIGN KEY (complaint_id) REFERENCES complaints(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 11. Tabel Refresh Tokens
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    session_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL,
+    replaced_by_id BIGINT UNSIGNED NULL,
+    ip_address VARCHAR(255),
+    user_agent VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_id (user_id),
+    INDEX idx_session_id (session_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 12. Tabel Password Reset Tokens
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 13. Tabel Login Attempts
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    identifier VARCHAR(255),
+    user_id BIGINT UNSIGNED NULL,
+    ip_address VARCHAR(64),
+    user_agent VARCHAR(255),
+    success BOOLEAN,
+    reason VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_identifier (identifier),
+    INDEX idx_user_id (user_id),
+    INDEX idx_ip_address (ip_address),
+    INDEX idx_success (success),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 14. Tabel Login Throttles
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    kind ENUM('account', 'ip') NOT NULL,
+    throttle_key VARCHAR(255) NOT NULL,
+    failures BIGINT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NULL,
+    locked_until TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE INDEX idx_login_throttle (kind, throttle_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 15. Tabel Recovery Codes
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_id (user_id),
+    INDEX idx_code_hash (code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 16. Tabel API Keys
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_by_id BIGINT UNSIGNED NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 17. Tabel Roles
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    display_name VARCHAR(100) NOT NULL,
+    description VARCHAR(255),
+    is_system BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 18. Tabel Role Permissions
+CREATE TABLE IF NOT EXISTS role_permissions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    role_id BIGINT UNSIGNED NOT NULL,
+    permission VARCHAR(50) NOT NULL,
+    UNIQUE KEY idx_role_permission (role_id, permission),
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 19. Insert Data Roles
+-- Role bawaan juga dibuat otomatis saat aplikasi start. User lama tetap memakai
+-- nama role yang sama: 'admin' menjadi Super Admin dan 'student' tetap Student.
+INSERT INTO roles (name, display_name, description, is_system) VALUES
+('admin', 'Super Admin', 'Full access to everything', TRUE),
+('handler', 'Department Handler', 'Handles and responds to complaints', TRUE),
+('viewer', 'Viewer', 'Read-only access to complaints and reports', TRUE),
+('student', 'Student', 'Submits and follows own complaints', TRUE)
+ON DUPLICATE KEY UPDATE name=name;
+
+INSERT IGNORE INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission FROM roles r JOIN (
+    SELECT 'admin' AS role, 'account.self' AS permission UNION ALL
+    SELECT 'admin', 'categories.read' UNION ALL
+    SELECT 'admin', 'complaints.create' UNION ALL
+    SELECT 'admin', 'complaints.read_own' UNION ALL
+    SELECT 'admin', 'complaints.read_all' UNION ALL
+    SELECT 'admin', 'complaints.update' UNION ALL
+    SELECT 'admin', 'complaints.delete' UNION ALL
+    SELECT 'admin', 'canned_responses.manage' UNION ALL
+    SELECT 'admin', 'triage_rules.manage' UNION ALL
+    SELECT 'admin', 'reports.read' UNION ALL
+    SELECT 'admin', 'announcements.manage' UNION ALL
+    SELECT 'admin', 'users.read' UNION ALL
+    SELECT 'admin', 'users.manage' UNION ALL
+    SELECT 'admin', 'roles.manage' UNION ALL
+    SELECT 'admin', 'security.manage' UNION ALL
+    SELECT 'admin', 'complaints.all_units' UNION ALL
+    SELECT 'admin', 'org_units.manage' UNION ALL
+    SELECT 'admin', 'users.impersonate' UNION ALL
+    SELECT 'admin', 'audit.read' UNION ALL
+    SELECT 'admin', 'reports.schedule' UNION ALL
+    SELECT 'handler', 'account.self' UNION ALL
+    SELECT 'handler', 'categories.read' UNION ALL
+    SELECT 'handler', 'complaints.read_all' UNION ALL
+    SELECT 'handler', 'complaints.update' UNION ALL
+    SELECT 'handler', 'reports.read' UNION ALL
+    SELECT 'handler', 'users.read' UNION ALL
+    SELECT 'viewer', 'account.self' UNION ALL
+    SELECT 'viewer', 'categories.read' UNION ALL
+    SELECT 'viewer', 'complaints.read_all' UNION ALL
+    SELECT 'viewer', 'reports.read' UNION ALL
+    SELECT 'student', 'account.self' UNION ALL
+    SELECT 'student', 'categories.read' UNION ALL
+    SELECT 'student', 'complaints.create' UNION ALL
+    SELECT 'student', 'complaints.read_own'
+) p ON p.role = r.name;
+
+-- 20. Tabel Organizational Units
+CREATE TABLE IF NOT EXISTS org_units (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    code VARCHAR(20) NOT NULL UNIQUE,
+    type VARCHAR(20) NOT NULL,
+    parent_id BIGINT UNSIGNED NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_parent_id (parent_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 21. Tabel Impersonation Sessions
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    impersonator_id BIGINT UNSIGNED NOT NULL,
+    target_user_id BIGINT UNSIGNED NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45),
+    user_agent VARCHAR(255),
+    expires_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP NULL,
+    ended_by_id BIGINT UNSIGNED NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_impersonator_id (impersonator_id),
+    INDEX idx_target_user_id (target_user_id),
+    FOREIGN KEY (impersonator_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 22. Tabel Impersonation Actions
+CREATE TABLE IF NOT EXISTS impersonation_actions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    impersonation_session_id BIGINT UNSIGNED NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path VARCHAR(255) NOT NULL,
+    route VARCHAR(255),
+    status INT NOT NULL,
+    ip_address VARCHAR(45),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_impersonation_session_id (impersonation_session_id),
+    FOREIGN KEY (impersonation_session_id) REFERENCES impersonation_sessions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 23. Tabel Email Verification Tokens
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 24. Tabel Audit Logs
+-- Append-only: jangan ubah atau hapus baris, setiap hash mencakup hash sebelumnya
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    actor_id BIGINT UNSIGNED NULL,
+    impersonator_id BIGINT UNSIGNED NULL,
+    action VARCHAR(100) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path VARCHAR(255) NOT NULL,
+    target_type VARCHAR(50),
+    target_id VARCHAR(50),
+    status BIGINT NOT NULL,
+    changes LONGTEXT NOT NULL,
+    ip_address VARCHAR(45),
+    user_agent VARCHAR(255),
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NULL,
+    INDEX idx_actor_id (actor_id),
+    INDEX idx_impersonator_id (impersonator_id),
+    INDEX idx_action (action),
+    INDEX idx_audit_target (target_type, target_id),
+    INDEX idx_status (status),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 25. Tabel Complaint Events
+CREATE TABLE IF NOT EXISTS complaint_events (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    complaint_id BIGINT UNSIGNED NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20),
+    actor_id BIGINT UNSIGNED NULL,
+    created_at TIMESTAMP NULL,
+    INDEX idx_complaint_event (complaint_id, type),
+    INDEX idx_to_status (to_status),
+    INDEX idx_created_at (created_at),
+    FOREIGN KEY (complaint_id) REFERENCES complaints(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 26. Tabel Report Subscriptions
+-- Laporan dibuat dengan hak akses owner (user yang terakhir menyimpan langganan)
+CREATE TABLE IF NOT EXISTS report_subscriptions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    report_type VARCHAR(20) NOT NULL,
+    status VARCHAR(20),
+    search VARCHAR(255),
+    cadence VARCHAR(20) NOT NULL,
+    recipients TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    owner_id BIGINT UNSIGNED NOT NULL,
+    next_run_at TIMESTAMP NULL,
+    last_run_at TIMESTAMP NULL,
+    created_at TIMESTAMP NULL,
+    updated_at TIMESTAMP NULL,
+    INDEX idx_owner_id (owner_id),
+    INDEX idx_next_run_at (next_run_at),
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 27. Tabel Report Deliveries
+CREATE TABLE IF NOT EXISTS report_deliveries (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    subscription_id BIGINT UNSIGNED NOT NULL,
+    period_start TIMESTAMP NULL,
+    period_end TIMESTAMP NULL,
+    recipients TEXT NOT NULL,
+    filename VARCHAR(255),
+    size BIGINT,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    manual BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NULL,
+    INDEX idx_subscription_id (subscription_id),
+    INDEX idx_status (status),
+    INDEX idx_created_at (created_at),
+    FOREIGN KEY (subscription_id) REFERENCES report_subscriptions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 28. Insert Data Categories
+INSERT INTO categories (name, slug, ticket_prefix) VALUES
+('Facilities', 'facilities', 'FAC'),
+('Academics', 'academics', 'ACD'),
+('IT Support', 'it_support', 'IT'),
+('Security', 'security', 'SEC'),
+('Services', 'services', 'SVC'),
+('Cleanliness', 'cleanliness', 'CLN'),
+('Network', 'network', 'NET'),
+('General', 'general', 'GEN'),
+('Other', 'other', 'OTH')
 ON DUPLICATE KEY UPDATE name=name;
 
@@ -42,7 +42,33 @@ func connectDB() {
 }
 
 func migrateDB() {
-	err := DB.AutoMigrate(&models.User{}, &models.Category{}, &models.Complaint{}, &models.Announcement{}, &models.Notification{})
+	err := DB.AutoMigrate(
+		&models.User{},
+		&models.Category{},
+		&models.Complaint{},
+		&models.Announcement{},
+		&models.Notification{},
+		&models.CannedResponse{},
+		&models.TriageRule{},
+		&models.TicketSequence{},
+		&models.ComplaintWatcher{},
+		&models.RefreshToken{},
+		&models.PasswordResetToken{},
+		&models.LoginAttempt{},
+		&models.LoginThrottle{},
+		&models.RecoveryCode{},
+		&models.APIKey{},
+		&models.Role{},
+		&models.RolePermission{},
+		&models.OrgUnit{},
+		&models.ImpersonationSession{},
+		&models.ImpersonationAction{},
+		&models.EmailVerificationToken{},
+		&models.AuditLog{},
+		&models.ComplaintEvent{},
+		&models.ReportSubscription{},
+		&models.ReportDelivery{},
+	)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
@@ -50,20 +76,22 @@ func migrateDB() {
 }
 
 func seedDB() {
+	seedRoles()
+
 	// Seed categories
 	var categoryCount int64
 	DB.Model(&models.Category{}).Count(&categoryCount)
 	if categoryCount == 0 {
 		categories := []models.Category{
-			{Name: "Facilities", Slug: "facilities"},
-			{Name: "Academics", Slug: "academics"},
-			{Name: "IT Support", Slug: "it_support"},
-			{Name: "Security", Slug: "security"},
-			{Name: "Services", Slug: "services"},
-			{Name: "Cleanliness", Slug: "cleanliness"},
-			{Name: "Network", Slug: "network"},
-			{Name: "General", Slug: "general"},
-			{Name: "Other", Slug: "other"},
+			{Name: "Facilities", Slug: "facilities", TicketPrefix: "FAC"},
+			{Name: "Academics", Slug: "academics", TicketPrefix: "ACD"},
+			{Name: "IT Support", Slug: "it_support", TicketPrefix: "IT"},
+			{Name: "Security", Slug: "security", TicketPrefix: "SEC"},
+			{Name: "Services", Slug: "services", TicketPrefix: "SVC"},
+			{Name: "Cleanliness", Slug: "cleanliness", TicketPrefix: "CLN"},
+			{Name: "Network", Slug: "network", TicketPrefix: "NET"},
+			{Name: "General", Slug: "general", TicketPrefix: "GEN"},
+			{Name: "Other", Slug: "other", TicketPrefix: "OTH"},
 		}
 
 		for _, category := range categories {
@@ -72,7 +100,30 @@ func seedDB() {
 		log.Println("Categories seeded")
 	}
 
-	// Seed admin user (always ensure admin exists with correct password)
+	// Backfill ticket prefixes for categories created before prefixes existed
+	defaultPrefixes := map[string]string{
+		"facilities":  "FAC",
+		"academics":   "ACD",
+		"it_support":  "IT",
+		"security":    "SEC",
+		"services":    "SVC",
+		"cleanliness": "CLN",
+		"network":     "NET",
+		"general":     "GEN",
+		"other":       "OTH",
+	}
+	for slug, prefix := range defaultPrefixes {
+		DB.Model(&models.Category{}).Where("slug = ? AND (ticket_prefix IS NULL OR ticket_prefix = '')", slug).Update("ticket_prefix", prefix)
+	}
+
+	// Backfill status events for complaints handled before they were recorded
+	backfillComplaintEvents()
+
+	// Users without a student ID used to store an empty string, which the
+	// unique index allows only once
+	DB.Unscoped().Model(&models.User{}).Where("student_id = ?", "").Update("student_id", nil)
+
+	// Seed admin user if it does not exist yet
 	var adminUser models.User
 	result := DB.Where("username = ?", "admin").First(&adminUser)
 
@@ -90,7 +141,9 @@ func seedDB() {
 			Password:  hashedPassword,
 			Name:      "Admin User",
 			Role:      models.RoleAdmin,
-			StudentID: "ADMIN001",
+			StudentID: studentIDColumn("ADMIN001"),
+			// The default password is public, so it must be changed on first login
+			MustChangePassword: true,
 		}
 
 		if err := DB.Create(&admin).Error; err != nil {
@@ -98,14 +151,6 @@ func seedDB() {
 		} else {
 			log.Println("Admin user created successfully")
 		}
-	} else {
-		// Update admin password to ensure it's correct
-		hashedPassword, err := hashPassword("admin123")
-		if err == nil {
-			adminUser.Password = hashedPassword
-			DB.Save(&adminUser)
-			log.Println("Admin user password updated")
-		}
 	}
 
 	// Seed a single sample student user (if none exist)
@@ -115,12 +160,13 @@ func seedDB() {
 		hashedPassword, _ := hashPassword("student123")
 		student := models.User{
 			Username:  "student001",
-			StudentID: "2024001",
+			StudentID: studentIDColumn("2024001"),
 			Email:     "student001@university.edu",
 			Password:  hashedPassword,
 			Name:      "Student User",
 			Role:      models.RoleStudent,
 			Phone:     "+6281234567890",
+			MustChangePassword: true,
 		}
 
 		if err := DB.Create(&student).Error; err != nil {
@@ -146,18 +192,32 @@ func checkPasswordHash(password, hash string) bool {
 
 // JWT functions
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID       uint   `json:"user_id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TokenVersion int    `json:"ver"`
+	SessionID    string `json:"sid"`
+	// MustChangePassword limits the token to the password change endpoint
+	MustChangePassword bool `json:"pwd,omitempty"`
+	// TwoFactorSetupRequired limits the token to the 2FA enrollment endpoints
+	TwoFactorSetupRequired bool `json:"mfa_setup,omitempty"`
+	// ImpersonationID is set when an administrator acts as this user
+	ImpersonationID uint `json:"imp,omitempty"`
+	ImpersonatorID  uint `json:"imp_by,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func generateToken(userID uint, username, role string) (string, error) {
-	expirationTime := time.Now().Add(time.Duration(config.AppConfig.JWTExpirationHours) * time.Hour)
+// generateToken issues a short-lived access token for a login session.
+func generateToken(user models.User, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(time.Duration(config.AppConfig.AccessTokenMinutes) * time.Minute)
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:       user.ID,
+		Username:     user.Username,
+		Role:         string(user.Role),
+		TokenVersion: user.TokenVersion,
+		SessionID:    sessionID,
+		MustChangePassword: user.MustChangePassword,
+		TwoFactorSetupRequired: twoFactorSetupRequired(user),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -189,6 +249,10 @@ func validateToken(tokenString string) (*Claims, error) {
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if key := apiKeyFromRequest(c); key != "" {
+			authenticateAPIKey(c, key)
+			return
+		}
 		if authHeader == "" {
 			c.JSON(401, gin.H{"error": "Authorization header required"})
 			c.Abort()
@@ -206,19 +270,27 @@ func authMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if err := checkTokenRevocation(claims); err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if claims.MustChangePassword && !passwordChangeAllowedRoutes[c.FullPath()] {
+			c.JSON(403, gin.H{"error": "Password change required", "code": "password_change_required"})
+			c.Abort()
+			return
+		}
+		if claims.TwoFactorSetupRequired && !twoFactorSetupAllowedRoutes[c.FullPath()] {
+			c.JSON(403, gin.H{"error": "Two-factor authentication setup required", "code": "two_factor_setup_required"})
+			c.Abort()
+			return
+		}
 		c.Set("user_id", claims.UserID)
 		c.Set("user_role", claims.Role)
 		c.Set("username", claims.Username)
-		c.Next()
-	}
-}
-
-func adminOnly() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role, exists := c.Get("user_role")
-		if !exists || role != "admin" {
-			c.JSON(403, gin.H{"error": "Admin access required"})
-			c.Abort()
+		c.Set("session_id", claims.SessionID)
+		if claims.ImpersonationID != 0 {
+			impersonatedRequest(c, claims)
 			return
 		}
 		c.Next()
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gatedRouter serves the routes the password and 2FA gates care about behind
+// authMiddleware.
+func gatedRouter() *gin.Engine {
+	r := gin.New()
+	api := r.Group("/api", authMiddleware())
+	ok := func(c *gin.Context) { c.JSON(200, gin.H{}) }
+	api.GET("/profile", ok)
+	api.PUT("/profile/password", ok)
+	api.POST("/profile/2fa/setup", ok)
+	api.POST("/profile/2fa/enable", ok)
+	api.GET("/complaints", ok)
+	return r
+}
+
+func TestAuthMiddlewareGates(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.RequireAdminTOTP = true
+
+	tests := []struct {
+		name               string
+		role               models.UserRole
+		mustChangePassword bool
+		want               map[string]int
+	}{
+		{
+			name: "no gate",
+			role: models.RoleStudent,
+			want: map[string]int{
+				"PUT /api/profile/password": 200, "POST /api/profile/2fa/setup": 200, "GET /api/complaints": 200,
+			},
+		},
+		{
+			name:               "password change only",
+			role:               models.RoleStudent,
+			mustChangePassword: true,
+			want: map[string]int{
+				"PUT /api/profile/password": 200, "POST /api/profile/2fa/setup": 200, "GET /api/complaints": 403,
+			},
+		},
+		{
+			name: "2FA setup only",
+			role: models.RoleAdmin,
+			want: map[string]int{
+				"PUT /api/profile/password": 200, "POST /api/profile/2fa/enable": 200, "GET /api/complaints": 403,
+			},
+		},
+		{
+			name:               "both gates",
+			role:               models.RoleAdmin,
+			mustChangePassword: true,
+			want: map[string]int{
+				"GET /api/profile": 200, "PUT /api/profile/password": 200,
+				"POST /api/profile/2fa/setup": 200, "POST /api/profile/2fa/enable": 200,
+				"GET /api/complaints": 403,
+			},
+		},
+	}
+
+	r := gatedRouter()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, "gate"+string(rune('a'+i)), tt.role)
+			user.MustChangePassword = tt.mustChangePassword
+			DB.Save(&user)
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/api/login", nil)
+			token, _, _, err := issueTokenPair(c, user, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for route, want := range tt.want {
+				method, path, _ := strings.Cut(route, " ")
+				req := httptest.NewRequest(method, path, nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != want {
+					t.Errorf("%s = %d, want %d: %s", route, w.Code, want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsRevokedToken(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "revoked", models.RoleStudent)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/login", nil)
+	token, _, _, err := issueTokenPair(c, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := revokeUserTokens(DB, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/complaints", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	gatedRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token = %d, want 401", w.Code)
+	}
+}
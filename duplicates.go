@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Duplicate detection tuning. Candidates are limited to the most recent open
+// complaints in the same category so the comparison stays cheap.
+const (
+	similarCandidateLimit = 200
+	similarResultLimit    = 5
+	similarMinScore       = 0.3
+)
+
+var similarStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "this": true, "that": true,
+	"from": true, "are": true, "was": true, "not": true, "can": true, "have": true,
+	"has": true, "but": true, "our": true, "your": true, "yang": true, "dan": true,
+	"tidak": true, "di": true, "ke": true, "dari": true, "ini": true, "itu": true,
+}
+
+// tokenize splits text into a set of lowercase words, ignoring short words
+// and common stop words.
+func tokenize(text string) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make(map[string]bool, len(words))
+	for _, word := range words {
+		if len(word) < 3 || similarStopWords[word] {
+			continue
+		}
+		tokens[word] = true
+	}
+	return tokens
+}
+
+// jaccard returns the Jaccard similarity of two token sets.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	intersection := 0
+	for token := range a {
+		if b[token] {
+			intersection++
+		}
+	}
+	union := len(a) + len(b) - intersection
+	return float64(intersection) / float64(union)
+}
+
+// findSimilarComplaints returns open complaints in the given category whose
+// title and description resemble the given text, best match first. Only
+// complaints the user may see are suggested.
+func findSimilarComplaints(c *gin.Context, categoryID uint, title, description string, excludeID uint) []models.SimilarComplaint {
+	var candidates []models.Complaint
+	DB.Scopes(visibleComplaints(c)).Where("category_id = ? AND id <> ? AND merged_into_id IS NULL AND status IN ?",
+		categoryID, excludeID, []models.ComplaintStatus{models.StatusPending, models.StatusInProcess}).
+		Order("created_at DESC").
+		Limit(similarCandidateLimit).
+		Find(&candidates)
+
+	titleTokens := tokenize(title)
+	textTokens := tokenize(title + " " + description)
+
+	similar := []models.SimilarComplaint{}
+	for _, candidate := range candidates {
+		// Titles carry most of the signal, descriptions break ties
+		score := 0.6*jaccard(titleTokens, tokenize(candidate.Title)) +
+			0.4*jaccard(textTokens, tokenize(candidate.Title+" "+candidate.Description))
+		if score < similarMinScore {
+			continue
+		}
+		similar = append(similar, models.SimilarComplaint{
+			ID:        candidate.ID,
+			TicketID:  candidate.TicketID,
+			Title:     candidate.Title,
+			Status:    candidate.Status,
+			Score:     score,
+			CreatedAt: candidate.CreatedAt,
+		})
+	}
+
+	sort.Slice(similar, func(i, j int) bool { return similar[i].Score > similar[j].Score })
+	if len(similar) > similarResultLimit {
+		similar = similar[:similarResultLimit]
+	}
+	return similar
+}
+
+func getSimilarComplaints(c *gin.Context) {
+	var complaint models.Complaint
+	if err := DB.First(&complaint, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": findSimilarComplaints(c, complaint.CategoryID, complaint.Title, complaint.Description, complaint.ID)})
+}
+
+// errMergedComplaint is returned when changing a merged duplicate, whose
+// updates belong on the primary complaint.
+const errMergedComplaint = "Merged complaints cannot be changed, update the complaint they were merged into"
+
+type MergeComplaintsRequest struct {
+	DuplicateIDs []uint `json:"duplicate_ids" binding:"required,min=1"`
+	// Force allows merging complaints of different categories
+	Force bool `json:"force"`
+}
+
+// mergeComplaints links duplicate complaints to a primary complaint, closes
+// them and copies their watchers onto the primary. Later updates to the
+// primary are forwarded to the reporters of every merged duplicate.
+func mergeComplaints(c *gin.Context) {
+	var req MergeComplaintsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var primary models.Complaint
+	if err := DB.First(&primary, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if !canViewComplaint(c, primary) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+	if primary.MergedIntoID != nil {
+		c.JSON(400, gin.H{"error": "Cannot merge into a complaint that is itself merged"})
+		return
+	}
+
+	var duplicates []models.Complaint
+	if err := DB.Where("id IN ?", req.DuplicateIDs).Find(&duplicates).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if len(duplicates) != len(req.DuplicateIDs) {
+		c.JSON(404, gin.H{"error": "One or more duplicate complaints not found"})
+		return
+	}
+	for _, duplicate := range duplicates {
+		if duplicate.ID == primary.ID {
+			c.JSON(400, gin.H{"error": "A complaint cannot be merged into itself"})
+			return
+		}
+		if !canViewComplaint(c, duplicate) {
+			c.JSON(403, gin.H{"error": "Access denied"})
+			return
+		}
+		if duplicate.MergedIntoID != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Complaint %s is already merged", duplicate.TicketID)})
+			return
+		}
+		if duplicate.CategoryID != primary.CategoryID && !req.Force {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Complaint %s is in a different category, set force to merge it anyway", duplicate.TicketID)})
+			return
+		}
+	}
+
+	response := fmt.Sprintf("This complaint has been merged into ticket %s. You will receive updates from that ticket.", primary.TicketID)
+	actorID := getUserID(c)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, duplicate := range duplicates {
+			previousStatus := duplicate.Status
+			if err := tx.Model(&duplicate).Updates(map[string]interface{}{
+				"merged_into_id": primary.ID,
+				"status":         models.StatusMerged,
+				"admin_response": response,
+			}).Error; err != nil {
+				return err
+			}
+			if err := recordComplaintEvent(tx, duplicate.ID, models.ComplaintEventStatus, previousStatus, models.StatusMerged, &actorID); err != nil {
+				return err
+			}
+			// Keep merges one level deep so updates fan out from a single primary
+			if err := tx.Model(&models.Complaint{}).Where("merged_into_id = ?", duplicate.ID).
+				Update("merged_into_id", primary.ID).Error; err != nil {
+				return err
+			}
+			if err := copyWatchers(tx, duplicate.ID, primary.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to merge complaints"})
+		return
+	}
+
+	for _, duplicate := range duplicates {
+		createComplaintUpdateNotification(duplicate.ID, duplicate.UserID, duplicate.Title, models.StatusMerged, response, true, true)
+	}
+
+	DB.Preload("User").Preload("Category").First(&primary, primary.ID)
+	c.JSON(200, gin.H{"message": "Complaints merged successfully", "primary": primary, "merged_count": len(duplicates)})
+}
+
+// notifyMergedReporters forwards an update on a primary complaint to the
+// reporters of every complaint merged into it.
+func notifyMergedReporters(primary models.Complaint, statusChanged, responseAdded bool) {
+	var duplicates []models.Complaint
+	DB.Where("merged_into_id = ?", primary.ID).Find(&duplicates)
+	for _, duplicate := range duplicates {
+		if duplicate.UserID == primary.UserID {
+			continue
+		}
+		createComplaintUpdateNotification(duplicate.ID, duplicate.UserID, duplicate.Title, primary.Status, primary.AdminResponse, statusChanged, responseAdded)
+	}
+}
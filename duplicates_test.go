@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFindSimilarComplaintsOnlyVisible(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice", models.RoleStudent)
+	bob := createTestUser(t, "bob", models.RoleStudent)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	category := models.Category{Name: "Fasilitas", Slug: "fasilitas"}
+	DB.Create(&category)
+	for i, owner := range []models.User{alice, bob} {
+		DB.Create(&models.Complaint{
+			TicketID:    "FAS-000" + string(rune('1'+i)),
+			UserID:      owner.ID,
+			CategoryID:  category.ID,
+			Title:       "AC ruang kelas rusak",
+			Description: "AC di ruang kelas lantai dua rusak dan panas",
+		})
+	}
+
+	similar := func(user models.User) []models.SimilarComplaint {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("user_id", user.ID)
+		c.Set("user_role", string(user.Role))
+		return findSimilarComplaints(c, category.ID, "AC ruang kelas rusak", "AC lantai dua rusak", 0)
+	}
+
+	if got := similar(alice); len(got) != 1 || got[0].TicketID != "FAS-0001" {
+		t.Errorf("student sees %+v, want only their own complaint", got)
+	}
+	if got := similar(admin); len(got) != 2 {
+		t.Errorf("admin sees %d complaints, want 2", len(got))
+	}
+}
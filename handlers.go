@@ -3,10 +3,13 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"simplee-k/config"
 	"simplee-k/models"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -26,34 +29,105 @@ func login(c *gin.Context) {
 		return
 	}
 
+	// Slow down and lock out repeated failures per IP address and per account.
+	// Every attempt counts as a failure until the password is confirmed.
+	ipKey := c.ClientIP()
+	if rejectThrottledLogin(c, reserveLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)) {
+		return
+	}
+
 	var user models.User
 	result := DB.Where("username = ? OR student_id = ?", req.Username, req.Username).First(&user)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			c.JSON(401, gin.H{"error": "Invalid username or password"})
-			return
-		}
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		releaseLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)
 		c.JSON(500, gin.H{"error": "Database error"})
 		return
 	}
+	found := result.Error == nil
 
-	if !checkPasswordHash(req.Password, user.Password) {
+	var accountKey string
+	if found {
+		accountKey = accountThrottleKey(req.Username, &user)
+	} else {
+		accountKey = accountThrottleKey(req.Username, nil)
+	}
+	if rejectThrottledLogin(c, reserveLoginAttempt(models.ThrottleAccount, accountKey, config.AppConfig.LoginMaxFailures)) {
+		releaseLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)
+		return
+	}
+
+	authenticated, err := authenticateUser(req.Username, req.Password)
+	if err != nil {
+		if found {
+			recordLoginAttempt(c, req.Username, &user, false, "invalid_password")
+		} else {
+			recordLoginAttempt(c, req.Username, nil, false, "unknown_user")
+		}
 		c.JSON(401, gin.H{"error": "Invalid username or password"})
 		return
 	}
+	releaseLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)
+	releaseLoginAttempt(models.ThrottleAccount, accountKey, config.AppConfig.LoginMaxFailures)
+	user = authenticated
+	if !user.IsActive {
+		recordLoginAttempt(c, req.Username, &user, false, "deactivated")
+		c.JSON(403, gin.H{"error": "Your account has been deactivated", "code": "account_deactivated"})
+		return
+	}
+	if code, msg := registrationError(user); code != "" {
+		recordLoginAttempt(c, req.Username, &user, false, code)
+		c.JSON(403, gin.H{"error": msg, "code": code})
+		return
+	}
+
+	// Accounts with 2FA get a short-lived token that only works for the second step
+	if user.TOTPEnabled {
+		mfaToken, err := generateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"two_factor_required": true,
+			"mfa_token":           mfaToken,
+			"message":             "Enter the code from your authenticator app",
+		})
+		return
+	}
 
-	token, err := generateToken(user.ID, user.Username, string(user.Role))
+	completeLogin(c, user)
+}
+
+// completeLogin resets the user's failed login counter, records the successful
+// attempt and responds with a new token pair.
+func completeLogin(c *gin.Context, user models.User) {
+	response, err := startSession(c, user)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	c.JSON(200, response)
+}
 
-	c.JSON(200, gin.H{
-		"token":   token,
-		"user":    gin.H{"id": user.ID, "username": user.Username, "student_id": user.StudentID, "email": user.Email, "name": user.Name, "role": user.Role},
-		"role":    string(user.Role),
-		"message": "Login successful",
-	})
+// startSession records a successful login and issues the token pair. It is
+// shared by every way of logging in.
+func startSession(c *gin.Context, user models.User) (gin.H, error) {
+	clearLoginThrottle(models.ThrottleAccount, accountThrottleKey(user.Username, &user))
+	recordLoginAttempt(c, user.Username, &user, true, "")
+
+	token, refreshToken, _, err := issueTokenPair(c, user, "")
+	if err != nil {
+		return nil, err
+	}
+
+	response := tokenResponse(token, refreshToken)
+	response["user"] = gin.H{"id": user.ID, "username": user.Username, "student_id": user.StudentID, "email": user.Email, "name": user.Name, "role": user.Role}
+	response["role"] = string(user.Role)
+	response["permissions"] = rolePermissionList(user.Role)
+	response["must_change_password"] = user.MustChangePassword
+	response["two_factor_setup_required"] = twoFactorSetupRequired(user)
+	response["message"] = "Login successful"
+	return response, nil
 }
 
 func getProfile(c *gin.Context) {
@@ -63,7 +137,7 @@ func getProfile(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "User not found"})
 		return
 	}
-	c.JSON(200, gin.H{"id": user.ID, "username": user.Username, "student_id": user.StudentID, "email": user.Email, "name": user.Name, "role": user.Role, "phone": user.Phone})
+	c.JSON(200, profileResponse(c, user))
 }
 
 // Category handlers
@@ -84,8 +158,10 @@ type CreateComplaintRequest struct {
 }
 
 type UpdateComplaintRequest struct {
-	Status        string `json:"status"`
+	Status        string `json:"status" binding:"omitempty,oneof=pending in_process completed rejected"`
 	AdminResponse string `json:"admin_response"`
+	Priority      string `json:"priority" binding:"omitempty,oneof=low normal high urgent"`
+	AssignedToID  *uint  `json:"assigned_to_id"`
 }
 
 func createComplaint(c *gin.Context) {
@@ -114,47 +190,30 @@ func createComplaint(c *gin.Context) {
 		evidencePath = filepath.ToSlash(uploadPath)
 	}
 
-	// Generate unique ticket ID using timestamp + user ID + nanosecond
-	// Format: TKT-YYYY-MMDD-HHMMSS-USERID-NANO
-	now := time.Now()
-	ticketID := fmt.Sprintf("TKT-%d-%02d%02d-%02d%02d%02d-%d-%d",
-		now.Year(),
-		now.Month(),
-		now.Day(),
-		now.Hour(),
-		now.Minute(),
-		now.Second(),
-		userID,
-		now.UnixNano()%10000,
-	)
-	
-	// Double check uniqueness (should be very rare collision)
-	var existingComplaint models.Complaint
-	if DB.Where("ticket_id = ?", ticketID).First(&existingComplaint).Error == nil {
-		// If exists, add more randomness
-		ticketID = fmt.Sprintf("TKT-%d-%02d%02d-%02d%02d%02d-%d-%d",
-			now.Year(),
-			now.Month(),
-			now.Day(),
-			now.Hour(),
-			now.Minute(),
-			now.Second(),
-			userID,
-			time.Now().UnixNano()%100000,
-		)
-	}
-
 	complaint := models.Complaint{
-		TicketID:     ticketID,
 		UserID:       userID,
 		CategoryID:   req.CategoryID,
 		Title:        req.Title,
 		Description:  req.Description,
 		Status:       models.StatusPending,
+		Priority:     models.PriorityNormal,
 		EvidencePath: evidencePath,
 	}
 
-	if err := DB.Create(&complaint).Error; err != nil {
+	// Let triage rules re-categorize, prioritize, tag or assign the complaint
+	complaint.TriageRules = strings.Join(evaluateTriageRules(&complaint), ", ")
+
+	// Issue the ticket number and create the complaint in one transaction so
+	// concurrent submissions never receive the same number
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		ticketID, err := nextTicketID(tx, complaint.CategoryID, time.Now())
+		if err != nil {
+			return err
+		}
+		complaint.TicketID = ticketID
+		return tx.Create(&complaint).Error
+	})
+	if err != nil {
 		if evidencePath != "" {
 			os.Remove(evidencePath)
 		}
@@ -167,16 +226,19 @@ func createComplaint(c *gin.Context) {
 	DB.Preload("User").Preload("Category").First(&complaint, complaint.ID)
 	
 	// Create notifications for all admins when new complaint is created
-	createNewComplaintNotifications(complaint.ID, complaint.TicketID, complaint.Title, complaint.UserID)
+	createNewComplaintNotifications(complaint.ID, complaint.CategoryID, complaint.TicketID, complaint.Title, complaint.UserID)
+	
+	// Suggest open complaints that look like the same issue, among those the
+	// submitter may see
+	complaint.SimilarComplaints = findSimilarComplaints(c, complaint.CategoryID, complaint.Title, complaint.Description, complaint.ID)
 	
 	c.JSON(201, complaint)
 }
 
 // Helper function to create notifications for all admins when new complaint is created
-func createNewComplaintNotifications(complaintID uint, ticketID, complaintTitle string, studentUserID uint) {
-	// Get all admins
-	var admins []models.User
-	DB.Where("role = ?", "admin").Find(&admins)
+func createNewComplaintNotifications(complaintID, categoryID uint, ticketID, complaintTitle string, studentUserID uint) {
+	// Get everyone who handles complaints of this category
+	admins := complaintHandlers(categoryID)
 	
 	// Get student info for notification message
 	var student models.User
@@ -195,6 +257,9 @@ func createNewComplaintNotifications(complaintID uint, ticketID, complaintTitle
 	// Create notification for each admin
 	complaintIDPtr := &complaintID
 	for _, admin := range admins {
+		if !admin.NotifyNewComplaints {
+			continue
+		}
 		notification := models.Notification{
 			UserID:    admin.ID,
 			Title:     "New Complaint Received",
@@ -207,20 +272,22 @@ func createNewComplaintNotifications(complaintID uint, ticketID, complaintTitle
 	}
 }
 
-func getComplaints(c *gin.Context) {
-	userID := getUserID(c)
-	role := getUserRole(c)
-	query := DB.Preload("User").Preload("Category")
-
-	if role == "student" {
-		query = query.Where("user_id = ?", userID)
-	}
-	if status := c.Query("status"); status != "" {
-		query = query.Where("status = ?", status)
-	}
-	if search := c.Query("search"); search != "" {
-		query = query.Where("title LIKE ? OR description LIKE ? OR ticket_id LIKE ?", "%"+search+"%", "%"+search+"%", "%"+search+"%")
+// complaintListFilters applies the status and search filters of the
+// complaint list, which the exports share.
+func complaintListFilters(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if status := c.Query("status"); status != "" {
+			db = db.Where("status = ?", status)
+		}
+		if search := c.Query("search"); search != "" {
+			db = db.Where("title LIKE ? OR description LIKE ? OR ticket_id LIKE ?", "%"+search+"%", "%"+search+"%", "%"+search+"%")
+		}
+		return db
 	}
+}
+
+func getComplaints(c *gin.Context) {
+	query := DB.Preload("User").Preload("Category").Scopes(visibleComplaints(c), complaintListFilters(c))
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
@@ -235,8 +302,6 @@ func getComplaints(c *gin.Context) {
 }
 
 func getComplaint(c *gin.Context) {
-	userID := getUserID(c)
-	role := getUserRole(c)
 	complaintID := c.Param("id")
 
 	var complaint models.Complaint
@@ -249,7 +314,7 @@ func getComplaint(c *gin.Context) {
 		return
 	}
 
-	if role == "student" && complaint.UserID != userID {
+	if !canViewComplaint(c, complaint) {
 		c.JSON(403, gin.H{"error": "Access denied"})
 		return
 	}
@@ -274,7 +339,27 @@ func updateComplaint(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Database error"})
 		return
 	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+	if complaint.Status == models.StatusMerged {
+		c.JSON(400, gin.H{"error": errMergedComplaint})
+		return
+	}
+
+	if err := applyComplaintUpdate(&complaint, req, getUserID(c)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update complaint"})
+		return
+	}
 
+	c.JSON(200, complaint)
+}
+
+// applyComplaintUpdate saves a status change and/or admin response on a
+// complaint, reloads it with its relations and notifies the affected users.
+// actorID is the user making the change; they are not notified of their own update.
+func applyComplaintUpdate(complaint *models.Complaint, req UpdateComplaintRequest, actorID uint) error {
 	oldStatus := complaint.Status
 	oldAdminResponse := complaint.AdminResponse
 	statusChanged := false
@@ -295,20 +380,40 @@ func updateComplaint(c *gin.Context) {
 			responseChanged = true
 		}
 	}
+	if req.Priority != "" {
+		complaint.Priority = models.ComplaintPriority(req.Priority)
+	}
+	if req.AssignedToID != nil {
+		complaint.AssignedToID = req.AssignedToID
+	}
 
-	if err := DB.Save(&complaint).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update complaint"})
-		return
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(complaint).Error; err != nil {
+			return err
+		}
+		if statusChanged {
+			if err := recordComplaintEvent(tx, complaint.ID, models.ComplaintEventStatus, oldStatus, complaint.Status, &actorID); err != nil {
+				return err
+			}
+		}
+		if responseAdded || responseChanged {
+			return recordComplaintEvent(tx, complaint.ID, models.ComplaintEventResponse, "", "", &actorID)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	DB.Preload("User").Preload("Category").First(&complaint, complaint.ID)
+	DB.Preload("User").Preload("Category").First(complaint, complaint.ID)
 	
 	// Create notification for the complaint owner if status changed or response added/changed
 	if statusChanged || responseAdded || responseChanged {
 		createComplaintUpdateNotification(complaint.ID, complaint.UserID, complaint.Title, complaint.Status, complaint.AdminResponse, statusChanged, responseAdded || responseChanged)
+		notifyMergedReporters(*complaint, statusChanged, responseAdded || responseChanged)
+		notifyWatchersOfUpdate(*complaint, statusChanged, responseAdded || responseChanged, actorID)
 	}
 	
-	c.JSON(200, complaint)
+	return nil
 }
 
 // Helper function to create notification when complaint status is updated or admin responds
@@ -339,6 +444,9 @@ func createComplaintUpdateNotification(complaintID, userID uint, complaintTitle
 		// No change that requires notification
 		return
 	}
+	if !notificationsEnabled(userID, "notify_complaint_updates") {
+		return
+	}
 	
 	complaintIDPtr := &complaintID
 	notification := models.Notification{
@@ -359,6 +467,7 @@ func getStatusTextForNotification(status models.ComplaintStatus) string {
 		models.StatusInProcess: "In Process",
 		models.StatusCompleted: "Completed",
 		models.StatusRejected:  "Rejected",
+		models.StatusMerged:    "Merged",
 	}
 	if text, ok := statusMap[status]; ok {
 		return text
@@ -377,6 +486,10 @@ func deleteComplaint(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Database error"})
 		return
 	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
 
 	if complaint.EvidencePath != "" {
 		os.Remove(complaint.EvidencePath)
@@ -391,13 +504,7 @@ func deleteComplaint(c *gin.Context) {
 }
 
 func getComplaintStats(c *gin.Context) {
-	userID := getUserID(c)
-	role := getUserRole(c)
-	baseQuery := DB.Model(&models.Complaint{})
-
-	if role == "student" {
-		baseQuery = baseQuery.Where("user_id = ?", userID)
-	}
+	baseQuery := DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c))
 
 	var total, pending, inProcess, completed, rejected int64
 	
@@ -405,17 +512,10 @@ func getComplaintStats(c *gin.Context) {
 	baseQuery.Count(&total)
 	
 	// Count by status - need to create new query for each status
-	if role == "student" {
-		DB.Model(&models.Complaint{}).Where("user_id = ? AND status = ?", userID, models.StatusPending).Count(&pending)
-		DB.Model(&models.Complaint{}).Where("user_id = ? AND status = ?", userID, models.StatusInProcess).Count(&inProcess)
-		DB.Model(&models.Complaint{}).Where("user_id = ? AND status = ?", userID, models.StatusCompleted).Count(&completed)
-		DB.Model(&models.Complaint{}).Where("user_id = ? AND status = ?", userID, models.StatusRejected).Count(&rejected)
-	} else {
-		DB.Model(&models.Complaint{}).Where("status = ?", models.StatusPending).Count(&pending)
-		DB.Model(&models.Complaint{}).Where("status = ?", models.StatusInProcess).Count(&inProcess)
-		DB.Model(&models.Complaint{}).Where("status = ?", models.StatusCompleted).Count(&completed)
-		DB.Model(&models.Complaint{}).Where("status = ?", models.StatusRejected).Count(&rejected)
-	}
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).Where("status = ?", models.StatusPending).Count(&pending)
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).Where("status = ?", models.StatusInProcess).Count(&inProcess)
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).Where("status = ?", models.StatusCompleted).Count(&completed)
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).Where("status = ?", models.StatusRejected).Count(&rejected)
 
 	c.JSON(200, gin.H{
 		"total": total, 
@@ -442,6 +542,16 @@ func getAllUsers(c *gin.Context) {
 		query = query.Where("role = ?", role)
 	}
 
+	// Filter by status, deleted users are only listed on request
+	switch c.Query("status") {
+	case "active":
+		query = query.Where("is_active = ?", true)
+	case "deactivated":
+		query = query.Where("is_active = ?", false)
+	case "deleted":
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
 	// Pagination
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
@@ -454,16 +564,7 @@ func getAllUsers(c *gin.Context) {
 	// Remove password from response
 	userList := make([]gin.H, len(users))
 	for i, user := range users {
-		userList[i] = gin.H{
-			"id":         user.ID,
-			"username":   user.Username,
-			"student_id": user.StudentID,
-			"email":      user.Email,
-			"name":       user.Name,
-			"role":       user.Role,
-			"phone":      user.Phone,
-			"created_at": user.CreatedAt,
-		}
+		userList[i] = userSummary(user)
 	}
 
 	c.JSON(200, gin.H{
@@ -480,11 +581,27 @@ func getUserStats(c *gin.Context) {
 	DB.Model(&models.User{}).Count(&total)
 	DB.Model(&models.User{}).Where("role = ?", "admin").Count(&adminCount)
 	DB.Model(&models.User{}).Where("role = ?", "student").Count(&studentCount)
+	var deactivatedCount, deletedCount int64
+	DB.Model(&models.User{}).Where("is_active = ?", false).Count(&deactivatedCount)
+	DB.Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL").Count(&deletedCount)
+
+	var roleCounts []struct {
+		Role  string
+		Count int64
+	}
+	DB.Model(&models.User{}).Select("role, COUNT(*) as count").Group("role").Scan(&roleCounts)
+	byRole := gin.H{}
+	for _, rc := range roleCounts {
+		byRole[rc.Role] = rc.Count
+	}
 
 	c.JSON(200, gin.H{
 		"total":   total,
 		"admin":   adminCount,
 		"student": studentCount,
+		"by_role": byRole,
+		"deactivated": deactivatedCount,
+		"deleted":     deletedCount,
 	})
 }
 
@@ -493,9 +610,9 @@ type CreateUserRequest struct {
 	Username  string `json:"username" binding:"required"`
 	StudentID string `json:"student_id"`
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=6"`
+	Password  string `json:"password" binding:"required"`
 	Name      string `json:"name" binding:"required"`
-	Role      string `json:"role" binding:"required,oneof=admin student"`
+	Role      string `json:"role" binding:"required"`
 	Phone     string `json:"phone"`
 }
 
@@ -506,25 +623,24 @@ func createUser(c *gin.Context) {
 		return
 	}
 
-	// Check if username already exists
-	var existingUser models.User
-	if err := DB.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
-		c.JSON(400, gin.H{"error": "Username already exists"})
+	if !roleExists(models.UserRole(req.Role)) {
+		c.JSON(400, gin.H{"error": "Role does not exist"})
+		return
+	}
+	if !canAssignRole(c, models.UserRole(req.Role)) {
+		c.JSON(403, gin.H{"error": "You do not have permission to assign this role"})
 		return
 	}
 
-	// Check if email already exists
-	if err := DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
-		c.JSON(400, gin.H{"error": "Email already exists"})
+	// Check if username, email or student_id already exist
+	if msg := userConflict(0, req.Username, req.Email, req.StudentID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
 		return
 	}
 
-	// Check if student_id already exists (if provided)
-	if req.StudentID != "" {
-		if err := DB.Where("student_id = ?", req.StudentID).First(&existingUser).Error; err == nil {
-			c.JSON(400, gin.H{"error": "Student ID already exists"})
-			return
-		}
+	if err := validatePassword(req.Password, models.User{Username: req.Username}); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Hash password
@@ -537,12 +653,14 @@ func createUser(c *gin.Context) {
 	// Create user
 	user := models.User{
 		Username:  req.Username,
-		StudentID: req.StudentID,
+		StudentID: studentIDColumn(req.StudentID),
 		Email:     req.Email,
 		Password:  hashedPassword,
 		Name:      req.Name,
 		Role:      models.UserRole(req.Role),
 		Phone:     req.Phone,
+		// The admin chose this password, so the user must replace it on first login
+		MustChangePassword: true,
 	}
 
 	if err := DB.Create(&user).Error; err != nil {
@@ -564,84 +682,136 @@ func createUser(c *gin.Context) {
 }
 
 // Report handlers
-func getReportStats(c *gin.Context) {
-	// Get date range from query params (optional)
+
+// reportPeriod holds the report stats of complaints submitted in one period.
+type reportPeriod struct {
+	Total              int64
+	Pending            int64
+	Resolved           int64
+	AvgResolutionHours float64
+}
+
+// reportPeriodStats computes the stats of complaints created from start up to,
+// but not including, end.
+func reportPeriodStats(c *gin.Context, start, end time.Time) (reportPeriod, error) {
+	var stats reportPeriod
+	inPeriod := func() *gorm.DB {
+		return DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).
+			Where("created_at >= ? AND created_at < ?", start, end)
+	}
+	if err := inPeriod().Count(&stats.Total).Error; err != nil {
+		return stats, err
+	}
+	if err := inPeriod().Where("status = ?", models.StatusPending).Count(&stats.Pending).Error; err != nil {
+		return stats, err
+	}
+	if err := inPeriod().Where("status = ?", models.StatusCompleted).Count(&stats.Resolved).Error; err != nil {
+		return stats, err
+	}
+
+	durations, err := complaintDurationStats(c, start, end, true, "0")
+	if err != nil {
+		return stats, err
+	}
+	stats.AvgResolutionHours = overallDuration(durations).Mean / 3600
+	return stats, nil
+}
+
+// percentChange returns the change from previous to current in percent,
+// rounded to one decimal, or nil when there is nothing to compare against.
+func percentChange(current, previous float64) interface{} {
+	if previous == 0 {
+		if current == 0 {
+			return 0.0
+		}
+		return nil
+	}
+	return math.Round((current-previous)/previous*1000) / 10
+}
+
+// reportDateRange parses start_date and end_date (inclusive, default the
+// last 30 days) into the period from start up to, but not including, end.
+func reportDateRange(c *gin.Context) (time.Time, time.Time, bool) {
 	startDate := c.Query("start_date")
 	endDate := c.Query("end_date")
-	
-	var total, pending, resolved int64
-	var avgResolutionHours float64
-	
-	// Base query for date filtering
-	baseQuery := DB.Model(&models.Complaint{})
-	if startDate != "" && endDate != "" {
-		baseQuery = baseQuery.Where("created_at BETWEEN ? AND ?", startDate, endDate)
+	if startDate == "" || endDate == "" {
+		endDate = time.Now().Format("2006-01-02")
+		startDate = time.Now().AddDate(0, 0, -29).Format("2006-01-02")
 	}
-	
-	// Count total (all complaints in date range)
-	baseQuery.Count(&total)
-	
-	// Count pending (separate query)
-	pendingQuery := DB.Model(&models.Complaint{}).Where("status = ?", models.StatusPending)
-	if startDate != "" && endDate != "" {
-		pendingQuery = pendingQuery.Where("created_at BETWEEN ? AND ?", startDate, endDate)
+	start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid start_date, use YYYY-MM-DD"})
+		return start, start, false
 	}
-	pendingQuery.Count(&pending)
-	
-	// Count resolved (completed) - separate query
-	resolvedQuery := DB.Model(&models.Complaint{}).Where("status = ?", models.StatusCompleted)
-	if startDate != "" && endDate != "" {
-		resolvedQuery = resolvedQuery.Where("created_at BETWEEN ? AND ?", startDate, endDate)
+	end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid end_date, use YYYY-MM-DD"})
+		return start, end, false
 	}
-	resolvedQuery.Count(&resolved)
-	
-	// Calculate average resolution time (in days) - only for completed complaints
-	var completedComplaints []models.Complaint
-	completedQuery := DB.Model(&models.Complaint{}).Where("status = ?", models.StatusCompleted)
-	if startDate != "" && endDate != "" {
-		completedQuery = completedQuery.Where("created_at BETWEEN ? AND ?", startDate, endDate)
+	if end.Before(start) {
+		c.JSON(400, gin.H{"error": "end_date must not be before start_date"})
+		return start, end, false
 	}
-	completedQuery.Find(&completedComplaints)
-	
-	var totalHours float64
-	var count float64
-	for _, complaint := range completedComplaints {
-		if !complaint.UpdatedAt.IsZero() && !complaint.CreatedAt.IsZero() {
-			duration := complaint.UpdatedAt.Sub(complaint.CreatedAt)
-			totalHours += duration.Hours()
-			count++
-		}
+	return start, end.AddDate(0, 0, 1), true
+}
+
+// previousPeriodStart returns the start of the period with the same number of
+// days that ends where start begins. Days are counted by calendar date, so a
+// period across a daylight saving change still compares whole days.
+func previousPeriodStart(start, end time.Time) time.Time {
+	days := int(end.Sub(start).Hours()/24 + 0.5)
+	return start.AddDate(0, 0, -days)
+}
+
+// getReportStats compares the complaints submitted between start_date and
+// end_date (inclusive, default the last 30 days) with the preceding period
+// of the same length.
+func getReportStats(c *gin.Context) {
+	start, end, ok := reportDateRange(c)
+	if !ok {
+		return
 	}
-	
-	if count > 0 {
-		avgResolutionHours = totalHours / count
+
+	previousStart := previousPeriodStart(start, end)
+	current, err := reportPeriodStats(c, start, end)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to compute report stats"})
+		return
 	}
-	
-	avgResolutionDays := avgResolutionHours / 24
-	
-	// Calculate percentage changes (simplified - compare with previous period)
-	// For now, return 0% change. In production, you'd compare with previous period
-	totalChange := 5.2
-	pendingChange := 12.5
-	resolvedChange := 8.1
-	resolutionTimeChange := -2.4
-	
+	previous, err := reportPeriodStats(c, previousStart, start)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to compute report stats"})
+		return
+	}
+
 	c.JSON(200, gin.H{
 		"total": gin.H{
-			"value": total,
-			"change": totalChange,
+			"value":    current.Total,
+			"previous": previous.Total,
+			"change":   percentChange(float64(current.Total), float64(previous.Total)),
 		},
 		"pending": gin.H{
-			"value": pending,
-			"change": pendingChange,
+			"value":    current.Pending,
+			"previous": previous.Pending,
+			"change":   percentChange(float64(current.Pending), float64(previous.Pending)),
 		},
 		"resolved": gin.H{
-			"value": resolved,
-			"change": resolvedChange,
+			"value":    current.Resolved,
+			"previous": previous.Resolved,
+			"change":   percentChange(float64(current.Resolved), float64(previous.Resolved)),
 		},
 		"avg_resolution_time": gin.H{
-			"days": avgResolutionDays,
-			"change": resolutionTimeChange,
+			"days":          current.AvgResolutionHours / 24,
+			"previous_days": previous.AvgResolutionHours / 24,
+			"change":        percentChange(current.AvgResolutionHours, previous.AvgResolutionHours),
+		},
+		"period": gin.H{
+			"start_date": start.Format("2006-01-02"),
+			"end_date":   end.AddDate(0, 0, -1).Format("2006-01-02"),
+		},
+		"previous_period": gin.H{
+			"start_date": previousStart.Format("2006-01-02"),
+			"end_date":   start.AddDate(0, 0, -1).Format("2006-01-02"),
 		},
 	})
 }
@@ -653,14 +823,14 @@ func getCategoryStats(c *gin.Context) {
 		Count        int64
 	}
 	
-	DB.Model(&models.Complaint{}).
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).
 		Select("category_id, categories.name as category_name, COUNT(*) as count").
 		Joins("LEFT JOIN categories ON complaints.category_id = categories.id").
 		Group("category_id, categories.name").
 		Scan(&results)
 	
 	var total int64
-	DB.Model(&models.Complaint{}).Count(&total)
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).Count(&total)
 	
 	categoryStats := make([]gin.H, len(results))
 	for i, result := range results {
@@ -682,46 +852,20 @@ func getCategoryStats(c *gin.Context) {
 	})
 }
 
+// getComplaintTrends returns the complaints submitted and resolved per day
+// between start_date and end_date (inclusive, default the last 30 days).
+// Resolved complaints are counted on the day they were completed.
 func getComplaintTrends(c *gin.Context) {
-	// Get date range from query params
-	startDate := c.Query("start_date")
-	endDate := c.Query("end_date")
-	
-	// Default to last 30 days if not provided
-	if startDate == "" || endDate == "" {
-		endDate = time.Now().Format("2006-01-02")
-		startDate = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
-	}
-	
-	// Ensure endDate includes the full day (23:59:59)
-	endDateWithTime := endDate + " 23:59:59"
-	
-	// Get daily submission counts
-	var submissionData []struct {
-		Date  string `gorm:"column:date"`
-		Count int64  `gorm:"column:count"`
+	start, end, ok := reportDateRange(c)
+	if !ok {
+		return
 	}
-	
-	DB.Model(&models.Complaint{}).
-		Select("DATE(created_at) as date, COUNT(*) as count").
-		Where("created_at BETWEEN ? AND ?", startDate, endDateWithTime).
-		Group("DATE(created_at)").
-		Order("date ASC").
-		Scan(&submissionData)
-	
-	// Get daily resolved counts
-	var resolvedData []struct {
-		Date  string `gorm:"column:date"`
-		Count int64  `gorm:"column:count"`
+	submissionData, resolvedData, err := summaryDailyCounts(c, start, end)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch complaint trends"})
+		return
 	}
-	
-	DB.Model(&models.Complaint{}).
-		Select("DATE(updated_at) as date, COUNT(*) as count").
-		Where("status = ? AND updated_at BETWEEN ? AND ?", models.StatusCompleted, startDate, endDateWithTime).
-		Group("DATE(updated_at)").
-		Order("date ASC").
-		Scan(&resolvedData)
-	
+
 	// Format response with proper date format
 	formattedSubmissions := make([]gin.H, len(submissionData))
 	for i, item := range submissionData {
@@ -730,7 +874,7 @@ func getComplaintTrends(c *gin.Context) {
 			"count": item.Count,
 		}
 	}
-	
+
 	formattedResolved := make([]gin.H, len(resolvedData))
 	for i, item := range resolvedData {
 		formattedResolved[i] = gin.H{
@@ -738,12 +882,12 @@ func getComplaintTrends(c *gin.Context) {
 			"count": item.Count,
 		}
 	}
-	
+
 	c.JSON(200, gin.H{
 		"submissions": formattedSubmissions,
 		"resolved":    formattedResolved,
-		"start_date":  startDate,
-		"end_date":    endDate,
+		"start_date":  start.Format("2006-01-02"),
+		"end_date":    end.AddDate(0, 0, -1).Format("2006-01-02"),
 	})
 }
 
@@ -895,7 +1039,7 @@ func deleteAnnouncement(c *gin.Context) {
 func createAnnouncementNotifications(announcementID uint, title, content string) {
 	// Get all students
 	var students []models.User
-	DB.Where("role = ?", "student").Find(&students)
+	DB.Where("role = ? AND notify_announcements = ?", "student", true).Find(&students)
 	
 	// Truncate content for notification message (max 200 chars)
 	message := content
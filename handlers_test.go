@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPercentChange(t *testing.T) {
+	tests := []struct {
+		current, previous float64
+		want              interface{}
+	}{
+		{0, 0, 0.0},
+		{5, 0, nil},
+		{10, 10, 0.0},
+		{15, 10, 50.0},
+		{5, 10, -50.0},
+		{1, 3, -66.7},
+		{2, 3, -33.3},
+	}
+	for _, tt := range tests {
+		if got := percentChange(tt.current, tt.previous); got != tt.want {
+			t.Errorf("percentChange(%v, %v) = %v, want %v", tt.current, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func reportRangeFor(query string) (time.Time, time.Time, int) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/reports/stats?"+query, nil)
+	start, end, _ := reportDateRange(c)
+	return start, end, w.Code
+}
+
+func TestReportPeriods(t *testing.T) {
+	date := func(value string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02", value, time.Local)
+		return d
+	}
+
+	tests := []struct {
+		query, wantStart, wantEnd, wantPreviousStart string
+	}{
+		{"start_date=2026-03-08&end_date=2026-03-14", "2026-03-08", "2026-03-15", "2026-03-01"},
+		{"start_date=2026-03-01&end_date=2026-03-01", "2026-03-01", "2026-03-02", "2026-02-28"},
+		{"start_date=2026-03-01&end_date=2026-03-31", "2026-03-01", "2026-04-01", "2026-01-29"},
+	}
+	for _, tt := range tests {
+		start, end, code := reportRangeFor(tt.query)
+		if code != 200 {
+			t.Fatalf("%s: status %d", tt.query, code)
+		}
+		if !start.Equal(date(tt.wantStart)) || !end.Equal(date(tt.wantEnd)) {
+			t.Errorf("%s: period %s to %s, want %s to %s", tt.query, start, end, tt.wantStart, tt.wantEnd)
+		}
+		if got := previousPeriodStart(start, end); !got.Equal(date(tt.wantPreviousStart)) {
+			t.Errorf("%s: previous period starts %s, want %s", tt.query, got.Format("2006-01-02"), tt.wantPreviousStart)
+		}
+	}
+
+	// The default is the last 30 days including today
+	start, end, _ := reportRangeFor("")
+	if days := int(end.Sub(start).Hours()/24 + 0.5); days != 30 {
+		t.Errorf("default period has %d days, want 30", days)
+	}
+	today := date(time.Now().Format("2006-01-02"))
+	if !end.Equal(today.AddDate(0, 0, 1)) {
+		t.Errorf("default period ends %s, want tomorrow", end)
+	}
+
+	for _, query := range []string{"start_date=2026-03-10&end_date=2026-03-01", "start_date=yesterday&end_date=2026-03-01"} {
+		if _, _, code := reportRangeFor(query); code != 400 {
+			t.Errorf("%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestPreviousPeriodStartAcrossDaylightSaving(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+	// The week of 29 March 2026 has a 23 hour day
+	start := time.Date(2026, 3, 29, 0, 0, 0, 0, location)
+	end := time.Date(2026, 4, 5, 0, 0, 0, 0, location)
+	want := time.Date(2026, 3, 22, 0, 0, 0, 0, location)
+	if got := previousPeriodStart(start, end); !got.Equal(want) {
+		t.Errorf("previousPeriodStart = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// testDialector is SQLite with the MySQL enum columns stored as text, so the
+// models can be migrated without a MySQL server.
+type testDialector struct {
+	sqlite.Dialector
+}
+
+func (d testDialector) DataTypeOf(field *schema.Field) string {
+	if strings.HasPrefix(string(field.DataType), "enum(") {
+		return "text"
+	}
+	return d.Dialector.DataTypeOf(field)
+}
+
+func (d testDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	m := d.Dialector.Migrator(db).(sqlite.Migrator)
+	m.Dialector = d
+	return m
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:              "test-secret",
+		AccessTokenMinutes:     15,
+		RefreshTokenDays:       30,
+		ImpersonationMinutes:   30,
+		UploadDir:              "uploads",
+		TicketPrefix:           "TKT",
+		TicketSequenceDigits:   6,
+		AppBaseURL:             "http://localhost:8080",
+		PasswordMinLength:      8,
+		PasswordResetMinutes:   60,
+		InvitationDays:         7,
+		EmailVerificationHours: 24,
+		SMTPPort:               "25",
+		SMTPFrom:               "noreply@simplee-k.local",
+		ReportScheduleHour:     7,
+		LoginMaxFailures:       5,
+		LoginIPMaxFailures:     20,
+		LoginLockoutMinutes:    15,
+		LoginBackoffMaxSeconds: 60,
+		TOTPIssuer:             "SIMPEL-K",
+		LDAPUserFilter:         "(&(objectClass=person)(uid=%s))",
+		LDAPEmailAttribute:     "mail",
+		LDAPNameAttribute:      "cn",
+		LDAPGroupAttribute:     "memberOf",
+		LDAPDefaultRole:        "student",
+		OIDCUsernameClaim:      "preferred_username",
+		OIDCEmailClaim:         "email",
+		OIDCNameClaim:          "name",
+		OIDCStudentIDClaim:     "student_number",
+	}
+}
+
+// setupTestDB points DB at a fresh SQLite database with every table and the
+// default roles, and resets the configuration to the defaults.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	config.AppConfig = testConfig()
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(testDialector{sqlite.Dialector{DSN: dsn}}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB = db
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	migrateDB()
+	seedRoles()
+	invalidateRoleCache()
+}
+
+// createTestUser stores an active local user with the password "password123".
+func createTestUser(t *testing.T, username string, role models.UserRole) models.User {
+	t.Helper()
+	hashed, err := hashPassword("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{
+		Username:     username,
+		StudentID:    studentIDColumn("S-" + username),
+		Email:        username + "@example.com",
+		Password:     hashed,
+		Name:         username,
+		Role:         role,
+		AuthProvider: models.AuthProviderLocal,
+		IsActive:     true,
+	}
+	if err := DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// createTestRole stores a custom role with the given permissions.
+func createTestRole(t *testing.T, name string, permissions ...models.Permission) {
+	t.Helper()
+	role := models.Role{Name: name, DisplayName: name}
+	if err := DB.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, permission := range permissions {
+		if err := DB.Create(&models.RolePermission{RoleID: role.ID, Permission: permission}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	invalidateRoleCache()
+}
+
+// callAs serves a single request to handler as a user with the given role.
+func callAs(handler gin.HandlerFunc, userID uint, role, method, route, path, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("user_role", role)
+		handler(c)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Routes that manage credentials or sessions cannot be reached while
+// impersonating, so an administrator cannot change the user's password or
+// 2FA, create keys in their name or start a nested impersonation.
+var impersonationDeniedRoutePrefixes = []string{
+	"/api/profile/",
+	"/api/logout",
+	"/api/api-keys",
+	"/api/service-accounts",
+	"/api/users/:id/impersonate",
+	"/api/impersonation-sessions",
+}
+
+// Users with any of these permissions count as administrators and cannot be
+// impersonated.
+var administrativePermissions = []models.Permission{
+	models.PermUsersImpersonate,
+	models.PermUsersManage,
+	models.PermRolesManage,
+	models.PermSecurityManage,
+}
+
+type StartImpersonationRequest struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+func isAdministrativeRole(role models.UserRole) bool {
+	for _, permission := range administrativePermissions {
+		if roleHasPermission(role, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// administrativeRoles returns the names of the roles that count as
+// administrators.
+func administrativeRoles() []string {
+	var roles []string
+	for role := range loadRolePermissions() {
+		if isAdministrativeRole(role) {
+			roles = append(roles, string(role))
+		}
+	}
+	return roles
+}
+
+// generateImpersonationToken issues an access token acting as the target
+// user. It cannot be refreshed and expires with the impersonation session.
+func generateImpersonationToken(user models.User, session models.ImpersonationSession) (string, error) {
+	claims := &Claims{
+		UserID:          user.ID,
+		Username:        user.Username,
+		Role:            string(user.Role),
+		TokenVersion:    user.TokenVersion,
+		ImpersonationID: session.ID,
+		ImpersonatorID:  session.ImpersonatorID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "simplee-k",
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+}
+
+// checkImpersonationSession rejects impersonation tokens whose session was
+// ended or expired, or whose administrator was deactivated or lost the
+// permission.
+func checkImpersonationSession(claims *Claims) error {
+	var session models.ImpersonationSession
+	if err := DB.Preload("Impersonator").First(&session, claims.ImpersonationID).Error; err != nil {
+		return errors.New("Impersonation session not found")
+	}
+	if session.TargetUserID != claims.UserID || session.ImpersonatorID != claims.ImpersonatorID {
+		return errors.New("Invalid impersonation token")
+	}
+	if session.EndedAt != nil {
+		return errors.New("Impersonation session has ended")
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return errors.New("Impersonation session has expired")
+	}
+	if session.Impersonator.ID == 0 || !session.Impersonator.IsActive ||
+		!roleHasPermission(session.Impersonator.Role, models.PermUsersImpersonate) {
+		return errors.New("Impersonation is no longer allowed")
+	}
+	return nil
+}
+
+// impersonatedRequest is the impersonation branch of authMiddleware. Every
+// request is marked with the administrator's ID and recorded, including the
+// ones that are refused.
+func impersonatedRequest(c *gin.Context, claims *Claims) {
+	c.Set("impersonation_id", claims.ImpersonationID)
+	c.Set("impersonator_id", claims.ImpersonatorID)
+	c.Header("X-Impersonated-By", strconv.FormatUint(uint64(claims.ImpersonatorID), 10))
+
+	denied := false
+	for _, prefix := range impersonationDeniedRoutePrefixes {
+		if strings.HasPrefix(c.FullPath(), prefix) {
+			denied = true
+			break
+		}
+	}
+	if denied {
+		c.JSON(403, gin.H{"error": "This endpoint cannot be used while impersonating", "code": "impersonation_denied"})
+		c.Abort()
+	} else {
+		c.Next()
+	}
+
+	DB.Create(&models.ImpersonationAction{
+		ImpersonationSessionID: claims.ImpersonationID,
+		Method:                 c.Request.Method,
+		Path:                   c.Request.URL.Path,
+		Route:                  c.FullPath(),
+		Status:                 c.Writer.Status(),
+		IPAddress:              c.ClientIP(),
+	})
+}
+
+// impersonationInfo returns the banner details for a request made while
+// impersonating, or nil.
+func impersonationInfo(c *gin.Context) gin.H {
+	id, ok := c.Get("impersonation_id")
+	if !ok {
+		return nil
+	}
+	var session models.ImpersonationSession
+	if err := DB.Preload("Impersonator").First(&session, id).Error; err != nil {
+		return nil
+	}
+	return gin.H{
+		"session_id":            session.ID,
+		"impersonator_id":       session.ImpersonatorID,
+		"impersonator_username": session.Impersonator.Username,
+		"impersonator_name":     session.Impersonator.Name,
+		"reason":                session.Reason,
+		"expires_at":            session.ExpiresAt,
+	}
+}
+
+// startImpersonation lets an administrator view the app as another user. The
+// returned token acts as that user until it expires or is stopped; the
+// administrator's own session is not affected.
+func startImpersonation(c *gin.Context) {
+	var req StartImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var target models.User
+	if err := DB.First(&target, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if target.ID == getUserID(c) {
+		c.JSON(400, gin.H{"error": "You cannot impersonate yourself"})
+		return
+	}
+	if !target.IsActive {
+		c.JSON(400, gin.H{"error": "Deactivated users cannot be impersonated"})
+		return
+	}
+	if target.IsServiceAccount {
+		c.JSON(400, gin.H{"error": "Service accounts cannot be impersonated"})
+		return
+	}
+	if isAdministrativeRole(target.Role) {
+		c.JSON(403, gin.H{"error": "Administrators cannot be impersonated"})
+		return
+	}
+
+	minutes := config.AppConfig.ImpersonationMinutes
+	session := models.ImpersonationSession{
+		ImpersonatorID: getUserID(c),
+		TargetUserID:   target.ID,
+		Reason:         req.Reason,
+		IPAddress:      c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		ExpiresAt:      time.Now().Add(time.Duration(minutes) * time.Minute),
+	}
+	if err := DB.Create(&session).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start impersonation"})
+		return
+	}
+
+	token, err := generateImpersonationToken(target, session)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"token":         token,
+		"expires_in":    minutes * 60,
+		"impersonation": session,
+		"user": gin.H{
+			"id":       target.ID,
+			"username": target.Username,
+			"name":     target.Name,
+			"role":     target.Role,
+		},
+		"message": "Impersonation started, every request will be recorded",
+	})
+}
+
+func endImpersonation(sessionID, endedByID uint) error {
+	return DB.Model(&models.ImpersonationSession{}).
+		Where("id = ? AND ended_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"ended_at": time.Now(), "ended_by_id": endedByID}).Error
+}
+
+// stopImpersonation ends the impersonation the current token belongs to.
+func stopImpersonation(c *gin.Context) {
+	sessionID, ok := c.Get("impersonation_id")
+	if !ok {
+		c.JSON(400, gin.H{"error": "Not impersonating"})
+		return
+	}
+	if err := endImpersonation(sessionID.(uint), c.MustGet("impersonator_id").(uint)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to stop impersonation"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Impersonation stopped"})
+}
+
+func getImpersonationSessions(c *gin.Context) {
+	query := DB.Model(&models.ImpersonationSession{})
+	if impersonatorID := c.Query("impersonator_id"); impersonatorID != "" {
+		query = query.Where("impersonator_id = ?", impersonatorID)
+	}
+	if targetUserID := c.Query("target_user_id"); targetUserID != "" {
+		query = query.Where("target_user_id = ?", targetUserID)
+	}
+	if c.Query("active") == "true" {
+		query = query.Where("ended_at IS NULL AND expires_at > ?", time.Now())
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	var total int64
+	query.Count(&total)
+	var sessions []models.ImpersonationSession
+	query.Preload("Impersonator").Preload("TargetUser").Order("created_at DESC").Offset(offset).Limit(limit).Find(&sessions)
+
+	c.JSON(200, gin.H{"data": sessions, "total": total, "page": page, "limit": limit, "total_pages": (int(total) + limit - 1) / limit})
+}
+
+// getImpersonationSession returns a session with every request made in it.
+func getImpersonationSession(c *gin.Context) {
+	var session models.ImpersonationSession
+	if err := DB.Preload("Impersonator").Preload("TargetUser").First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Impersonation session not found"})
+		return
+	}
+	var actions []models.ImpersonationAction
+	DB.Where("impersonation_session_id = ?", session.ID).Order("created_at ASC").Find(&actions)
+	c.JSON(200, gin.H{"data": session, "actions": actions})
+}
+
+// adminEndImpersonation ends any impersonation session before it expires.
+func adminEndImpersonation(c *gin.Context) {
+	var session models.ImpersonationSession
+	if err := DB.First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Impersonation session not found"})
+		return
+	}
+	if err := endImpersonation(session.ID, getUserID(c)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to end impersonation"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Impersonation ended"})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"simplee-k/models"
+)
+
+func startTestImpersonation(t *testing.T, admin, target models.User) (models.ImpersonationSession, *Claims) {
+	t.Helper()
+	session := models.ImpersonationSession{
+		ImpersonatorID: admin.ID,
+		TargetUserID:   target.ID,
+		Reason:         "support",
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+	if err := DB.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	return session, &Claims{UserID: target.ID, ImpersonationID: session.ID, ImpersonatorID: admin.ID}
+}
+
+func TestCheckImpersonationSessionInactiveImpersonator(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	target := createTestUser(t, "student", models.RoleStudent)
+	_, claims := startTestImpersonation(t, admin, target)
+
+	if err := checkImpersonationSession(claims); err != nil {
+		t.Fatalf("active session rejected: %v", err)
+	}
+	DB.Model(&admin).Update("is_active", false)
+	if err := checkImpersonationSession(claims); err == nil {
+		t.Error("session of a deactivated administrator was accepted")
+	}
+}
+
+func TestRevokeUserTokensEndsImpersonation(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	target := createTestUser(t, "student", models.RoleStudent)
+	other := createTestUser(t, "other", models.RoleStudent)
+
+	for _, revoked := range []models.User{admin, target} {
+		session, claims := startTestImpersonation(t, admin, target)
+		untouched, _ := startTestImpersonation(t, admin, other)
+		if revoked.ID == target.ID {
+			if err := revokeUserTokens(DB, target.ID); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := revokeUserTokens(DB, admin.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checkImpersonationSession(claims); err == nil {
+			t.Errorf("session still valid after revoking the tokens of %s", revoked.Username)
+		}
+		DB.First(&session, session.ID)
+		if session.EndedAt == nil {
+			t.Errorf("session not ended after revoking the tokens of %s", revoked.Username)
+		}
+		var other models.ImpersonationSession
+		DB.First(&other, untouched.ID)
+		if revoked.ID == target.ID && other.EndedAt != nil {
+			t.Error("revoking the target's tokens ended another target's session")
+		}
+	}
+}
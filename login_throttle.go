@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Failures below this count are not delayed at all
+const loginBackoffFreeFailures = 2
+
+// accountThrottleKey identifies the account being attacked. Known users are
+// keyed by ID so switching between username and student ID does not help;
+// unknown names are keyed by the lowercased name.
+func accountThrottleKey(identifier string, user *models.User) string {
+	if user != nil {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// throttleWait returns how long the caller has to wait before the account or
+// IP of the throttle may try to log in again, or zero if it may try now.
+func throttleWait(throttle models.LoginThrottle, now time.Time) time.Duration {
+	if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+		return throttle.LockedUntil.Sub(now)
+	}
+
+	// Exponential backoff: 1s, 2s, 4s, ... after the first free failures
+	if throttle.Failures > loginBackoffFreeFailures {
+		exponent := float64(throttle.Failures - loginBackoffFreeFailures - 1)
+		delay := time.Duration(math.Min(math.Pow(2, exponent), float64(config.AppConfig.LoginBackoffMaxSeconds))) * time.Second
+		if allowedAt := throttle.LastFailureAt.Add(delay); now.Before(allowedAt) {
+			return allowedAt.Sub(now)
+		}
+	}
+	return 0
+}
+
+// reserveLoginAttempt counts an attempt as a failure before the credentials
+// are checked, and locks the key once it reaches maxFailures. The check and
+// the count happen under a row lock, so parallel requests cannot all pass
+// the throttle before any failure is recorded. It returns how long to wait
+// when the attempt is not allowed, in which case nothing is counted.
+// Failures older than the lockout window are forgotten. A successful attempt
+// gives its reservation back with releaseLoginAttempt.
+func reserveLoginAttempt(kind models.ThrottleKind, key string, maxFailures int) time.Duration {
+	now := time.Now()
+	lockout := time.Duration(config.AppConfig.LoginLockoutMinutes) * time.Minute
+
+	// Make sure the row exists so it can be locked
+	DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginThrottle{Kind: kind, Key: key, LastFailureAt: now})
+
+	var wait time.Duration
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var throttle models.LoginThrottle
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND throttle_key = ?", kind, key).First(&throttle).Error
+		if err == gorm.ErrRecordNotFound {
+			// Cleared by a successful login in the meantime
+			return tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "kind"}, {Name: "throttle_key"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"failures": gorm.Expr("failures + 1"), "last_failure_at": now}),
+			}).Create(&models.LoginThrottle{Kind: kind, Key: key, Failures: 1, LastFailureAt: now}).Error
+		}
+		if err != nil {
+			return err
+		}
+		if wait = throttleWait(throttle, now); wait > 0 {
+			return nil
+		}
+
+		failures := throttle.Failures + 1
+		if throttle.LastFailureAt.Before(now.Add(-lockout)) {
+			failures = 1
+		}
+		updates := map[string]interface{}{"failures": failures, "last_failure_at": now}
+		if failures >= maxFailures {
+			updates["locked_until"] = now.Add(lockout)
+		}
+		return tx.Model(&throttle).Updates(updates).Error
+	})
+	if err != nil {
+		log.Printf("Error reserving login attempt: %v", err)
+	}
+	return wait
+}
+
+// releaseLoginAttempt takes back the failure counted by reserveLoginAttempt
+// for an attempt that succeeded, including a lock it triggered.
+func releaseLoginAttempt(kind models.ThrottleKind, key string, maxFailures int) {
+	DB.Model(&models.LoginThrottle{}).Where("kind = ? AND throttle_key = ? AND failures > 0", kind, key).
+		Update("failures", gorm.Expr("failures - 1"))
+	DB.Model(&models.LoginThrottle{}).Where("kind = ? AND throttle_key = ? AND failures < ?", kind, key, maxFailures).
+		Update("locked_until", nil)
+}
+
+// clearLoginThrottle forgets the failures of an account or IP address.
+func clearLoginThrottle(kind models.ThrottleKind, key string) error {
+	return DB.Where("kind = ? AND throttle_key = ?", kind, key).Delete(&models.LoginThrottle{}).Error
+}
+
+// recordLoginAttempt writes the audit record of a login attempt.
+func recordLoginAttempt(c *gin.Context, identifier string, user *models.User, success bool, reason string) {
+	attempt := models.LoginAttempt{
+		Identifier: identifier,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		Success:    success,
+		Reason:     reason,
+	}
+	if user != nil {
+		userID := user.ID
+		attempt.UserID = &userID
+	}
+	DB.Create(&attempt)
+}
+
+// rejectThrottledLogin answers 429 with a Retry-After header when the wait is
+// not over yet. It reports whether the request was rejected.
+func rejectThrottledLogin(c *gin.Context, wait time.Duration) bool {
+	if wait <= 0 {
+		return false
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(429, gin.H{
+		"error":       fmt.Sprintf("Too many failed login attempts, try again in %d seconds", seconds),
+		"retry_after": seconds,
+	})
+	return true
+}
+
+func getLoginAttempts(c *gin.Context) {
+	query := DB.Model(&models.LoginAttempt{})
+	if identifier := c.Query("identifier"); identifier != "" {
+		query = query.Where("identifier = ?", identifier)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", success == "true")
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	var total int64
+	query.Count(&total)
+	var attempts []models.LoginAttempt
+	query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&attempts)
+
+	c.JSON(200, gin.H{"data": attempts, "total": total, "page": page, "limit": limit, "total_pages": (int(total) + limit - 1) / limit})
+}
+
+func getLoginThrottles(c *gin.Context) {
+	var throttles []models.LoginThrottle
+	DB.Where("locked_until > ?", time.Now()).Order("locked_until DESC").Find(&throttles)
+	c.JSON(200, gin.H{"data": throttles})
+}
+
+// unlockUser clears the lockout of a user account.
+func unlockUser(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if err := clearLoginThrottle(models.ThrottleAccount, accountThrottleKey("", &user)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User unlocked successfully"})
+}
+
+// deleteLoginThrottle clears a single account or IP lockout by its ID.
+func deleteLoginThrottle(c *gin.Context) {
+	if err := DB.Delete(&models.LoginThrottle{}, c.Param("id")).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to remove lockout"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Lockout removed successfully"})
+}
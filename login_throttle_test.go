@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"simplee-k/models"
+)
+
+func TestThrottleWait(t *testing.T) {
+	setupTestDB(t)
+	now := time.Now()
+	locked := now.Add(time.Minute)
+
+	tests := []struct {
+		name     string
+		throttle models.LoginThrottle
+		want     time.Duration
+	}{
+		{"no failures", models.LoginThrottle{}, 0},
+		{"free failures", models.LoginThrottle{Failures: 2, LastFailureAt: now}, 0},
+		{"first backoff", models.LoginThrottle{Failures: 3, LastFailureAt: now}, time.Second},
+		{"backoff doubles", models.LoginThrottle{Failures: 5, LastFailureAt: now}, 4 * time.Second},
+		{"backoff capped", models.LoginThrottle{Failures: 30, LastFailureAt: now}, 60 * time.Second},
+		{"backoff over", models.LoginThrottle{Failures: 3, LastFailureAt: now.Add(-2 * time.Second)}, 0},
+		{"locked", models.LoginThrottle{Failures: 1, LockedUntil: &locked}, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := throttleWait(tt.throttle, now); got != tt.want {
+			t.Errorf("%s: throttleWait = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReserveLoginAttemptLocksAfterMaxFailures(t *testing.T) {
+	setupTestDB(t)
+	// Backoff would reject the quick retries below, so only test the lock
+	const maxFailures = 3
+	for i := 0; i < maxFailures; i++ {
+		DB.Model(&models.LoginThrottle{}).Where("throttle_key = ?", "k").Update("last_failure_at", time.Now().Add(-time.Minute))
+		if wait := reserveLoginAttempt(models.ThrottleAccount, "k", maxFailures); wait != 0 {
+			t.Fatalf("attempt %d rejected with wait %v", i+1, wait)
+		}
+	}
+	if wait := reserveLoginAttempt(models.ThrottleAccount, "k", maxFailures); wait <= 0 {
+		t.Fatal("attempt after max failures was allowed")
+	}
+
+	var throttle models.LoginThrottle
+	DB.Where("throttle_key = ?", "k").First(&throttle)
+	if throttle.Failures != maxFailures {
+		t.Errorf("failures = %d, want %d; rejected attempts must not be counted", throttle.Failures, maxFailures)
+	}
+}
+
+func TestReserveLoginAttemptIsAtomic(t *testing.T) {
+	setupTestDB(t)
+	const attempts = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reserveLoginAttempt(models.ThrottleIP, "1.2.3.4", 20) == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Two failures are free, the third starts the backoff
+	if allowed > 3 {
+		t.Errorf("%d parallel attempts passed the throttle, want at most 3", allowed)
+	}
+	var throttle models.LoginThrottle
+	DB.Where("throttle_key = ?", "1.2.3.4").First(&throttle)
+	if throttle.Failures != allowed {
+		t.Errorf("failures = %d, want %d", throttle.Failures, allowed)
+	}
+}
+
+func TestReleaseLoginAttempt(t *testing.T) {
+	setupTestDB(t)
+	reserveLoginAttempt(models.ThrottleAccount, "k", 1)
+	var throttle models.LoginThrottle
+	DB.Where("throttle_key = ?", "k").First(&throttle)
+	if throttle.LockedUntil == nil {
+		t.Fatal("reaching max failures did not lock")
+	}
+
+	releaseLoginAttempt(models.ThrottleAccount, "k", 1)
+	var released models.LoginThrottle
+	DB.Where("throttle_key = ?", "k").First(&released)
+	if released.Failures != 0 || released.LockedUntil != nil {
+		t.Errorf("after release failures = %d, locked_until = %v; want 0 and nil", released.Failures, released.LockedUntil)
+	}
+}
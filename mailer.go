@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+)
+
+// emailAttachment is a file sent along with an email.
+type emailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// sendEmail sends a plain text email through the configured SMTP server. When
+// no SMTP host is configured the message is written to the log instead, which
+// is enough for local development.
+func sendEmail(to []string, subject, body string) error {
+	return sendEmailWithAttachments(to, subject, body, nil)
+}
+
+// sendEmailWithAttachments sends a plain text email with files attached as a
+// multipart/mixed message. Without attachments it is a plain text message.
+func sendEmailWithAttachments(to []string, subject, body string, attachments []emailAttachment) error {
+	if config.AppConfig.SMTPHost == "" {
+		var names []string
+		for _, attachment := range attachments {
+			names = append(names, fmt.Sprintf("%s (%d bytes)", attachment.Filename, len(attachment.Data)))
+		}
+		if len(names) > 0 {
+			body += "\n\nAttachments: " + strings.Join(names, ", ")
+		}
+		log.Printf("SMTP not configured, email to %s: %s\n%s", strings.Join(to, ", "), subject, body)
+		return nil
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", config.AppConfig.SMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	text := strings.ReplaceAll(body, "\n", "\r\n")
+	if len(attachments) == 0 {
+		msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		msg.WriteString("\r\n")
+		msg.WriteString(text)
+		return smtp.SendMail(smtpAddr(), smtpAuth(), config.AppConfig.SMTPFrom, to, msg.Bytes())
+	}
+
+	parts := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n", parts.Boundary())
+	msg.WriteString("\r\n")
+	w, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	if err != nil {
+		return err
+	}
+	w.Write([]byte(text))
+	for _, attachment := range attachments {
+		mediaType, params, err := mime.ParseMediaType(attachment.ContentType)
+		if err != nil {
+			return err
+		}
+		params["name"] = attachment.Filename
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(mediaType, params)},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		writeBase64Lines(w, attachment.Data)
+	}
+	if err := parts.Close(); err != nil {
+		return err
+	}
+	return smtp.SendMail(smtpAddr(), smtpAuth(), config.AppConfig.SMTPFrom, to, msg.Bytes())
+}
+
+// writeBase64Lines encodes data in lines of 76 characters as MIME requires.
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+func smtpAddr() string {
+	return config.AppConfig.SMTPHost + ":" + config.AppConfig.SMTPPort
+}
+
+func smtpAuth() smtp.Auth {
+	if config.AppConfig.SMTPUsername == "" {
+		return nil
+	}
+	return smtp.PlainAuth("", config.AppConfig.SMTPUsername, config.AppConfig.SMTPPassword, config.AppConfig.SMTPHost)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"simplee-k/config"
+	"simplee-k/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +14,7 @@ func main() {
 	connectDB()
 	migrateDB()
 	seedDB()
+	startReportScheduler()
 
 	r := setupRoutes()
 
@@ -56,6 +58,8 @@ func setupRoutes() *gin.Engine {
 	// Serve HTML files
 	r.GET("/", func(c *gin.Context) { c.File("./web/login.html") })
 	r.GET("/login", func(c *gin.Context) { c.File("./web/login.html") })
+	r.GET("/reset-password", func(c *gin.Context) { c.File("./web/reset-password.html") })
+	r.GET("/verify-email", func(c *gin.Context) { c.File("./web/verify-email.html") })
 	r.GET("/student/dashboard", func(c *gin.Context) { c.File("./web/student-dashboard.html") })
 	r.GET("/admin/dashboard", func(c *gin.Context) { c.File("./web/admin-dashboard.html") })
 	r.GET("/admin/users", func(c *gin.Context) { c.File("./web/users.html") })
@@ -80,47 +84,183 @@ func setupRoutes() *gin.Engine {
 	api := r.Group("/api")
 	{
 		api.POST("/login", login)
+		api.POST("/login/2fa", loginTwoFactor)
+		api.POST("/refresh", refreshSession)
+		api.POST("/password/forgot", forgotPassword)
+		api.POST("/password/reset", resetPassword)
+		api.POST("/email/verify", verifyEmail)
+		api.POST("/register", register)
+		api.POST("/register/resend", resendVerification)
+		api.GET("/auth/providers", getAuthProviders)
+		api.GET("/auth/oidc/login", oidcLogin)
+		api.GET("/auth/oidc/callback", oidcCallback)
 
 		protected := api.Group("")
-		protected.Use(authMiddleware())
+		protected.Use(authMiddleware(), auditMiddleware())
 		{
-			protected.GET("/profile", getProfile)
-			protected.GET("/categories", getCategories)
-			protected.POST("/complaints", createComplaint)
-			protected.GET("/complaints", getComplaints)
-			protected.GET("/complaints/stats", getComplaintStats)
-			protected.GET("/complaints/:id", getComplaint)
-			protected.PUT("/complaints/:id", updateComplaint)
-			protected.DELETE("/complaints/:id", deleteComplaint)
+			self := requirePermission(models.PermAccountSelf)
+			readComplaints := requirePermission(models.PermComplaintsReadOwn, models.PermComplaintsReadAll)
+			readAllComplaints := requirePermission(models.PermComplaintsReadAll)
+			updateComplaints := requirePermission(models.PermComplaintsUpdate)
+			manageCannedResponses := requirePermission(models.PermCannedResponsesManage)
+			manageTriageRules := requirePermission(models.PermTriageRulesManage)
+			readReports := requirePermission(models.PermReportsRead)
+			manageAnnouncements := requirePermission(models.PermAnnouncementsManage)
+			manageUsers := requirePermission(models.PermUsersManage)
+			manageRoles := requirePermission(models.PermRolesManage)
+			manageSecurity := requirePermission(models.PermSecurityManage)
+			manageOrgUnits := requirePermission(models.PermOrgUnitsManage)
+			impersonate := requirePermission(models.PermUsersImpersonate)
+			readAudit := requirePermission(models.PermAuditRead)
+			scheduleReports := requirePermission(models.PermReportsSchedule)
+
+			// Own account
+			protected.GET("/profile", self, getProfile)
+			protected.PUT("/profile", self, updateProfile)
+			protected.POST("/profile/email/verify", self, sendProfileEmailVerification)
+			protected.POST("/profile/avatar", self, uploadAvatar)
+			protected.DELETE("/profile/avatar", self, deleteAvatar)
+			protected.GET("/profile/export", self, exportProfileData)
+			protected.PUT("/profile/password", self, changePassword)
+			protected.POST("/profile/2fa/setup", self, setupTwoFactor)
+			protected.POST("/profile/2fa/enable", self, enableTwoFactor)
+			protected.POST("/profile/2fa/disable", self, disableTwoFactor)
+			protected.POST("/profile/2fa/recovery-codes", self, regenerateRecoveryCodes)
+			protected.GET("/profile/api-keys", self, getMyAPIKeys)
+			protected.POST("/profile/api-keys", self, createMyAPIKey)
+			protected.DELETE("/profile/api-keys/:id", self, revokeMyAPIKey)
+			protected.POST("/logout", self, logout)
+			protected.POST("/logout-all", self, logoutAll)
+			protected.POST("/impersonation/stop", self, stopImpersonation)
+
+			protected.GET("/categories", requirePermission(models.PermCategoriesRead), getCategories)
+
+			// Complaints
+			protected.POST("/complaints", requirePermission(models.PermComplaintsCreate), createComplaint)
+			protected.GET("/complaints", readComplaints, getComplaints)
+			protected.GET("/complaints/stats", readComplaints, getComplaintStats)
+			protected.GET("/complaints/by-ticket/:ticket", readComplaints, getComplaintByTicket)
+			protected.GET("/complaints/:id", readComplaints, getComplaint)
+			protected.PUT("/complaints/:id", updateComplaints, updateComplaint)
+			protected.DELETE("/complaints/:id", requirePermission(models.PermComplaintsDelete), deleteComplaint)
+
+			// Duplicate handling
+			protected.GET("/complaints/:id/similar", readAllComplaints, getSimilarComplaints)
+			protected.POST("/complaints/:id/merge", updateComplaints, mergeComplaints)
+
+			// Watchers
+			protected.GET("/complaints/:id/watchers", readComplaints, getWatchers)
+			protected.POST("/complaints/:id/watch", readComplaints, watchComplaint)
+			protected.DELETE("/complaints/:id/watch", readComplaints, unwatchComplaint)
+			protected.POST("/complaints/:id/watchers", updateComplaints, addComplaintWatcher)
+			protected.DELETE("/complaints/:id/watchers/:userId", updateComplaints, removeComplaintWatcher)
 
 			// Notifications
-			protected.GET("/notifications", getNotifications)
-			protected.PUT("/notifications/:id/read", markNotificationAsRead)
-			protected.PUT("/notifications/read-all", markAllNotificationsAsRead)
+			protected.GET("/notifications", self, getNotifications)
+			protected.PUT("/notifications/:id/read", self, markNotificationAsRead)
+			protected.PUT("/notifications/read-all", self, markAllNotificationsAsRead)
 
-			// Users (Admin only)
-			protected.GET("/users", getAllUsers)
-			protected.GET("/users/stats", getUserStats)
-		}
+			// Users
+			protected.GET("/users", requirePermission(models.PermUsersRead), getAllUsers)
+			protected.GET("/users/stats", requirePermission(models.PermUsersRead), getUserStats)
+			protected.POST("/users", manageUsers, createUser)
+			protected.POST("/users/import", manageUsers, importUsers)
+			protected.GET("/registrations", manageUsers, getRegistrations)
+			protected.POST("/registrations/:id/approve", manageUsers, approveRegistration)
+			protected.POST("/registrations/:id/reject", manageUsers, rejectRegistration)
+			protected.GET("/users/import/template", manageUsers, getImportTemplate)
+			protected.GET("/users/:id", requirePermission(models.PermUsersRead), getUser)
+			protected.PUT("/users/:id", manageUsers, updateUser)
+			protected.DELETE("/users/:id", manageUsers, deleteUser)
+			protected.POST("/users/:id/deactivate", manageUsers, deactivateUser)
+			protected.POST("/users/:id/reactivate", manageUsers, reactivateUser)
+			protected.POST("/users/:id/restore", manageUsers, restoreUser)
+			protected.GET("/users/:id/export", manageUsers, exportUserDataAsAdmin)
+			protected.POST("/users/:id/erase", manageUsers, eraseUser)
+			protected.PUT("/users/:id/role", manageRoles, assignUserRole)
+			protected.POST("/users/:id/unlock", manageSecurity, unlockUser)
+			protected.DELETE("/users/:id/2fa", manageSecurity, adminResetTwoFactor)
+
+			// Impersonation
+			protected.POST("/users/:id/impersonate", impersonate, startImpersonation)
+			protected.GET("/impersonation-sessions", manageSecurity, getImpersonationSessions)
+			protected.GET("/impersonation-sessions/:id", manageSecurity, getImpersonationSession)
+			protected.POST("/impersonation-sessions/:id/end", manageSecurity, adminEndImpersonation)
+
+			// Roles and permissions
+			protected.GET("/permissions", manageRoles, getPermissions)
+			protected.GET("/roles", manageRoles, getRoles)
+			protected.POST("/roles", manageRoles, createRole)
+			protected.GET("/roles/:id", manageRoles, getRole)
+			protected.PUT("/roles/:id", manageRoles, updateRole)
+			protected.DELETE("/roles/:id", manageRoles, deleteRole)
+
+			// Organizational units
+			protected.GET("/org-units", manageOrgUnits, getOrgUnits)
+			protected.POST("/org-units", manageOrgUnits, createOrgUnit)
+			protected.GET("/org-units/:id", manageOrgUnits, getOrgUnit)
+			protected.PUT("/org-units/:id", manageOrgUnits, updateOrgUnit)
+			protected.DELETE("/org-units/:id", manageOrgUnits, deleteOrgUnit)
+			protected.PUT("/categories/:id/org-unit", manageOrgUnits, setCategoryOrgUnit)
+			protected.PUT("/users/:id/org-unit", manageOrgUnits, setUserOrgUnit)
+
+			// API keys and service accounts
+			protected.GET("/api-keys", manageSecurity, getAPIKeys)
+			protected.DELETE("/api-keys/:id", manageSecurity, adminRevokeAPIKey)
+			protected.GET("/service-accounts", manageUsers, getServiceAccounts)
+			protected.POST("/service-accounts", manageUsers, createServiceAccount)
+			protected.POST("/service-accounts/:id/api-keys", manageUsers, createServiceAccountAPIKey)
+
+			// Audit log
+			protected.GET("/audit-logs", readAudit, getAuditLogs)
+			protected.GET("/audit-logs/export", readAudit, exportAuditLogs)
+			protected.GET("/audit-logs/verify", readAudit, verifyAuditLog)
+
+			// Login security
+			protected.GET("/login-attempts", manageSecurity, getLoginAttempts)
+			protected.GET("/login-throttles", manageSecurity, getLoginThrottles)
+			protected.DELETE("/login-throttles/:id", manageSecurity, deleteLoginThrottle)
+
+			// Canned responses
+			protected.GET("/canned-responses", updateComplaints, getCannedResponses)
+			protected.POST("/canned-responses", manageCannedResponses, createCannedResponse)
+			protected.GET("/canned-responses/:id", updateComplaints, getCannedResponse)
+			protected.PUT("/canned-responses/:id", manageCannedResponses, updateCannedResponse)
+			protected.DELETE("/canned-responses/:id", manageCannedResponses, deleteCannedResponse)
+			protected.POST("/complaints/:id/canned-response", updateComplaints, applyCannedResponse)
+
+			// Triage rules
+			protected.GET("/triage-rules", manageTriageRules, getTriageRules)
+			protected.POST("/triage-rules", manageTriageRules, createTriageRule)
+			protected.POST("/triage-rules/dry-run", manageTriageRules, dryRunTriageRule)
+			protected.GET("/triage-rules/:id", manageTriageRules, getTriageRule)
+			protected.PUT("/triage-rules/:id", manageTriageRules, updateTriageRule)
+			protected.DELETE("/triage-rules/:id", manageTriageRules, deleteTriageRule)
+			protected.POST("/triage-rules/:id/dry-run", manageTriageRules, dryRunTriageRule)
+
+			// Reports
+			protected.GET("/reports/stats", readReports, getReportStats)
+			protected.GET("/reports/categories", readReports, getCategoryStats)
+			protected.GET("/reports/trends", readReports, getComplaintTrends)
+			protected.GET("/reports/resolution-times", readReports, getResolutionTimes)
+			protected.GET("/reports/export/complaints", readReports, exportComplaints)
+			protected.GET("/reports/export/summary", readReports, exportReportSummary)
+
+			// Scheduled reports
+			protected.GET("/report-subscriptions", scheduleReports, getReportSubscriptions)
+			protected.POST("/report-subscriptions", scheduleReports, createReportSubscription)
+			protected.GET("/report-subscriptions/:id", scheduleReports, getReportSubscription)
+			protected.PUT("/report-subscriptions/:id", scheduleReports, updateReportSubscription)
+			protected.DELETE("/report-subscriptions/:id", scheduleReports, deleteReportSubscription)
+			protected.POST("/report-subscriptions/:id/send", scheduleReports, sendReportSubscription)
+			protected.GET("/report-subscriptions/:id/deliveries", scheduleReports, getReportDeliveries)
 
-		// Admin only routes
-		admin := protected.Group("")
-		admin.Use(adminOnly())
-		{
-			// Users (Admin only)
-			admin.POST("/users", createUser)
-			
-			// Reports (Admin only)
-			admin.GET("/reports/stats", getReportStats)
-			admin.GET("/reports/categories", getCategoryStats)
-			admin.GET("/reports/trends", getComplaintTrends)
-			
 			// Announcements
-			admin.GET("/announcements", getAnnouncements)
-			admin.POST("/announcements", createAnnouncement)
-			admin.GET("/announcements/:id", getAnnouncement)
-			admin.PUT("/announcements/:id", updateAnnouncement)
-			admin.DELETE("/announcements/:id", deleteAnnouncement)
+			protected.GET("/announcements", manageAnnouncements, getAnnouncements)
+			protected.POST("/announcements", manageAnnouncements, createAnnouncement)
+			protected.GET("/announcements/:id", manageAnnouncements, getAnnouncement)
+			protected.PUT("/announcements/:id", manageAnnouncements, updateAnnouncement)
+			protected.DELETE("/announcements/:id", manageAnnouncements, deleteAnnouncement)
 		}
 	}
 
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,11 +10,48 @@ import (
 
 type UserRole string
 
+type AuthProvider string
+
+const (
+	AuthProviderLocal AuthProvider = "local"
+	AuthProviderOIDC  AuthProvider = "oidc"
+	AuthProviderLDAP  AuthProvider = "ldap"
+)
+
+// Built-in roles. Admin is the super admin role and always has every
+// permission; more roles can be created at runtime.
 const (
 	RoleAdmin   UserRole = "admin"
+	RoleHandler UserRole = "handler"
+	RoleViewer  UserRole = "viewer"
 	RoleStudent UserRole = "student"
 )
 
+type Permission string
+
+const (
+	PermAccountSelf           Permission = "account.self"
+	PermCategoriesRead        Permission = "categories.read"
+	PermComplaintsCreate      Permission = "complaints.create"
+	PermComplaintsReadOwn     Permission = "complaints.read_own"
+	PermComplaintsReadAll     Permission = "complaints.read_all"
+	PermComplaintsUpdate      Permission = "complaints.update"
+	PermComplaintsDelete      Permission = "complaints.delete"
+	PermCannedResponsesManage Permission = "canned_responses.manage"
+	PermTriageRulesManage     Permission = "triage_rules.manage"
+	PermReportsRead           Permission = "reports.read"
+	PermAnnouncementsManage   Permission = "announcements.manage"
+	PermUsersRead             Permission = "users.read"
+	PermUsersManage           Permission = "users.manage"
+	PermRolesManage           Permission = "roles.manage"
+	PermSecurityManage        Permission = "security.manage"
+	PermComplaintsAllUnits    Permission = "complaints.all_units"
+	PermOrgUnitsManage        Permission = "org_units.manage"
+	PermUsersImpersonate      Permission = "users.impersonate"
+	PermAuditRead             Permission = "audit.read"
+	PermReportsSchedule       Permission = "reports.schedule"
+)
+
 type ComplaintStatus string
 
 const (
@@ -20,6 +59,16 @@ const (
 	StatusInProcess ComplaintStatus = "in_process"
 	StatusCompleted ComplaintStatus = "completed"
 	StatusRejected  ComplaintStatus = "rejected"
+	StatusMerged    ComplaintStatus = "merged"
+)
+
+type ComplaintPriority string
+
+const (
+	PriorityLow    ComplaintPriority = "low"
+	PriorityNormal ComplaintPriority = "normal"
+	PriorityHigh   ComplaintPriority = "high"
+	PriorityUrgent ComplaintPriority = "urgent"
 )
 
 type AnnouncementStatus string
@@ -30,24 +79,128 @@ const (
 	AnnouncementArchived  AnnouncementStatus = "archived"
 )
 
+// Self-registered accounts must verify their email and, when approval is
+// required, be approved before they can log in.
+type RegistrationStatus string
+
+const (
+	RegistrationUnverified RegistrationStatus = "unverified"
+	RegistrationPending    RegistrationStatus = "pending"
+	RegistrationApproved   RegistrationStatus = "approved"
+	RegistrationRejected   RegistrationStatus = "rejected"
+)
+
 type User struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Username  string    `gorm:"uniqueIndex;not null" json:"username"`
-	StudentID string    `gorm:"uniqueIndex" json:"student_id"`
+	// StudentID is NULL for users without one, since the unique index allows
+	// any number of NULLs but only one empty string
+	StudentID *string   `gorm:"uniqueIndex" json:"student_id"`
 	Email     string    `gorm:"uniqueIndex" json:"email"`
 	Password  string    `gorm:"not null" json:"-"`
 	Name      string    `json:"name"`
-	Role      UserRole  `gorm:"type:enum('admin','student');default:'student'" json:"role"`
+	Role      UserRole  `gorm:"size:50;not null;default:'student';index" json:"role"`
 	Phone     string    `json:"phone"`
+	TokenVersion int    `gorm:"not null;default:0" json:"-"`
+	MustChangePassword bool `gorm:"not null;default:false" json:"must_change_password"`
+	TOTPSecret      string `gorm:"column:totp_secret;size:64" json:"-"`
+	TOTPEnabled     bool   `gorm:"column:totp_enabled;not null;default:false" json:"totp_enabled"`
+	TOTPLastCounter int64  `gorm:"column:totp_last_counter;not null;default:0" json:"-"`
+	AuthProvider    AuthProvider `gorm:"size:20;not null;default:'local'" json:"auth_provider"`
+	OIDCSubject     *string      `gorm:"column:oidc_subject;size:255;uniqueIndex" json:"-"`
+	IsServiceAccount bool        `gorm:"not null;default:false" json:"is_service_account"`
+	OrgUnitID       *uint        `gorm:"index" json:"org_unit_id"`
+	// Deactivated users keep their data but cannot log in or use API keys
+	IsActive        bool         `gorm:"not null;default:true;index" json:"is_active"`
+	DeactivatedAt   *time.Time   `json:"deactivated_at"`
+	AvatarURL       string       `gorm:"size:255" json:"avatar_url"`
+	EmailVerifiedAt *time.Time   `json:"email_verified_at,omitempty"`
+	// ErasedAt is set once the personal data was anonymized on request
+	ErasedAt        *time.Time   `json:"erased_at,omitempty"`
+	// RegistrationStatus is empty for accounts created by an administrator
+	RegistrationStatus RegistrationStatus `gorm:"size:20;not null;default:'';index" json:"registration_status,omitempty"`
+	// Notification preferences
+	NotifyComplaintUpdates bool `gorm:"not null;default:true" json:"notify_complaint_updates"`
+	NotifyNewComplaints    bool `gorm:"not null;default:true" json:"notify_new_complaints"`
+	NotifyAnnouncements    bool `gorm:"not null;default:true" json:"notify_announcements"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// RefreshToken is a long-lived, single-use token stored as a SHA-256 hash.
+// All tokens rotated from the same login share a SessionID.
+type RefreshToken struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	SessionID    string     `gorm:"size:64;not null;index" json:"session_id"`
+	TokenHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedByID *uint      `json:"replaced_by_id,omitempty"`
+	IPAddress    string     `json:"ip_address"`
+	UserAgent    string     `json:"user_agent"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a single-use backup code for a user with two-factor
+// authentication, stored as a SHA-256 hash.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null;index" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use token emailed to a user who forgot their
+// password. Only its SHA-256 hash is stored.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginAttempt is an audit record of a login attempt.
+type LoginAttempt struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Identifier string    `gorm:"size:255;index" json:"identifier"`
+	UserID     *uint     `gorm:"index" json:"user_id,omitempty"`
+	IPAddress  string    `gorm:"size:64;index" json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	Success    bool      `gorm:"index" json:"success"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+type ThrottleKind string
+
+const (
+	ThrottleAccount ThrottleKind = "account"
+	ThrottleIP      ThrottleKind = "ip"
+)
+
+// LoginThrottle tracks consecutive failed logins for an account or an IP
+// address. It lives in the database so every server instance shares it.
+type LoginThrottle struct {
+	ID            uint         `gorm:"primaryKey" json:"id"`
+	Kind          ThrottleKind `gorm:"type:enum('account','ip');not null;uniqueIndex:idx_login_throttle" json:"kind"`
+	Key           string       `gorm:"column:throttle_key;size:255;not null;uniqueIndex:idx_login_throttle" json:"key"`
+	Failures      int          `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time    `json:"last_failure_at"`
+	LockedUntil   *time.Time   `json:"locked_until,omitempty"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
 type Category struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
 	Slug      string    `gorm:"uniqueIndex;not null" json:"slug"`
+	TicketPrefix string `gorm:"size:10" json:"ticket_prefix"`
+	OrgUnitID *uint     `gorm:"index" json:"org_unit_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -61,14 +214,73 @@ type Complaint struct {
 	Category    Category       `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
 	Title       string         `gorm:"not null" json:"title"`
 	Description string         `gorm:"type:text;not null" json:"description"`
-	Status      ComplaintStatus `gorm:"type:enum('pending','in_process','completed','rejected');default:'pending'" json:"status"`
+	Status      ComplaintStatus `gorm:"type:enum('pending','in_process','completed','rejected','merged');default:'pending'" json:"status"`
 	AdminResponse string        `gorm:"type:text" json:"admin_response"`
 	EvidencePath  string        `json:"evidence_path"`
+	Priority      ComplaintPriority `gorm:"type:enum('low','normal','high','urgent');default:'normal'" json:"priority"`
+	Tags          string        `json:"tags"`
+	AssignedToID  *uint         `gorm:"index" json:"assigned_to_id,omitempty"`
+	AssignedTo    *User         `gorm:"foreignKey:AssignedToID" json:"assigned_to,omitempty"`
+	MergedIntoID  *uint         `gorm:"index" json:"merged_into_id,omitempty"`
+	// TriageRules lists the names of the triage rules applied on submission
+	TriageRules   string        `gorm:"type:text" json:"triage_rules,omitempty"`
+	SimilarComplaints []SimilarComplaint `gorm:"-" json:"similar_complaints,omitempty"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+type ComplaintEventType string
+
+const (
+	ComplaintEventStatus   ComplaintEventType = "status"
+	ComplaintEventResponse ComplaintEventType = "response"
+)
+
+// ComplaintEvent records a status change or admin response on a complaint.
+// Resolution and first-response times are measured from these events.
+type ComplaintEvent struct {
+	ID          uint               `gorm:"primaryKey" json:"id"`
+	ComplaintID uint               `gorm:"not null;index:idx_complaint_event" json:"complaint_id"`
+	Type        ComplaintEventType `gorm:"size:20;not null;index:idx_complaint_event" json:"type"`
+	FromStatus  ComplaintStatus    `gorm:"size:20" json:"from_status,omitempty"`
+	ToStatus    ComplaintStatus    `gorm:"size:20;index" json:"to_status,omitempty"`
+	ActorID     *uint              `json:"actor_id,omitempty"`
+	CreatedAt   time.Time          `gorm:"index" json:"created_at"`
+}
+
+// TicketSequence holds the last issued ticket number for a prefix and year.
+// Rows are locked while a complaint is created so numbers are never reused.
+type TicketSequence struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Prefix    string    `gorm:"size:10;not null;uniqueIndex:idx_ticket_sequence" json:"prefix"`
+	Year      int       `gorm:"not null;uniqueIndex:idx_ticket_sequence" json:"year"`
+	LastValue int64     `gorm:"not null;default:0" json:"last_value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ComplaintWatcher is a user who follows a complaint and receives the same
+// update notifications as its reporter.
+type ComplaintWatcher struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ComplaintID uint      `gorm:"not null;uniqueIndex:idx_complaint_watcher" json:"complaint_id"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_complaint_watcher;index" json:"user_id"`
+	User        User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	AddedByID   uint      `json:"added_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SimilarComplaint is a lightweight summary of an open complaint that looks
+// like a duplicate of another one. It is never persisted.
+type SimilarComplaint struct {
+	ID        uint            `json:"id"`
+	TicketID  string          `json:"ticket_id"`
+	Title     string          `json:"title"`
+	Status    ComplaintStatus `json:"status"`
+	Score     float64         `json:"score"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
 type Announcement struct {
 	ID        uint               `gorm:"primaryKey" json:"id"`
 	Title     string             `gorm:"not null" json:"title"`
@@ -81,6 +293,61 @@ type Announcement struct {
 	DeletedAt gorm.DeletedAt     `gorm:"index" json:"-"`
 }
 
+// CannedResponse is a reusable admin reply. Its body may contain placeholders
+// such as {{student_name}} that are filled in when applied to a complaint.
+type CannedResponse struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	Title       string         `gorm:"not null" json:"title"`
+	Body        string         `gorm:"type:text;not null" json:"body"`
+	CategoryID  *uint          `gorm:"index" json:"category_id,omitempty"`
+	Category    *Category      `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	CreatedByID uint           `gorm:"not null;index" json:"created_by_id"`
+	CreatedBy   User           `gorm:"foreignKey:CreatedByID" json:"created_by,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+type RuleField string
+
+const (
+	RuleFieldTitle       RuleField = "title"
+	RuleFieldDescription RuleField = "description"
+	RuleFieldAny         RuleField = "any"
+)
+
+type RuleMatchType string
+
+const (
+	RuleMatchKeyword RuleMatchType = "keyword"
+	RuleMatchRegex   RuleMatchType = "regex"
+)
+
+// TriageRule is evaluated against new complaints in Position order. When its
+// conditions match, its actions are applied to the complaint before it is saved.
+type TriageRule struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Name     string `gorm:"not null" json:"name"`
+	Position int    `gorm:"not null;default:0;index" json:"position"`
+	Enabled  bool   `gorm:"not null;default:true" json:"enabled"`
+
+	// Conditions
+	Field      RuleField     `gorm:"type:enum('title','description','any');default:'any'" json:"field"`
+	MatchType  RuleMatchType `gorm:"type:enum('keyword','regex');default:'keyword'" json:"match_type"`
+	Pattern    string        `gorm:"type:text;not null" json:"pattern"`
+	CategoryID *uint         `json:"category_id,omitempty"`
+
+	// Actions
+	SetCategoryID  *uint             `json:"set_category_id,omitempty"`
+	SetPriority    ComplaintPriority `json:"set_priority,omitempty"`
+	AddTags        string            `json:"add_tags,omitempty"`
+	AssignToID     *uint             `json:"assign_to_id,omitempty"`
+	StopProcessing bool              `gorm:"not null;default:false" json:"stop_processing"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type NotificationType string
 
 const (
@@ -103,3 +370,195 @@ type Notification struct {
 	UpdatedAt time.Time        `json:"updated_at"`
 }
 
+// API key scopes. Read keys may only call GET endpoints; write keys may call
+// every endpoint the owning user can.
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+)
+
+// APIKey lets scripts and integrations call the API as a user. Only the
+// SHA-256 hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	User        User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Name        string     `gorm:"size:100;not null" json:"name"`
+	Prefix      string     `gorm:"size:16;not null" json:"prefix"`
+	KeyHash     string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes      string     `gorm:"size:100;not null" json:"scopes"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedByID uint       `gorm:"not null" json:"created_by_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Role is a named set of permissions assigned to users by name.
+type Role struct {
+	ID          uint             `gorm:"primaryKey" json:"id"`
+	Name        string           `gorm:"size:50;not null;uniqueIndex" json:"name"`
+	DisplayName string           `gorm:"size:100;not null" json:"display_name"`
+	Description string           `gorm:"size:255" json:"description"`
+	IsSystem    bool             `gorm:"not null;default:false" json:"is_system"`
+	Permissions []RolePermission `gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+type RolePermission struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	RoleID     uint       `gorm:"not null;uniqueIndex:idx_role_permission" json:"role_id"`
+	Permission Permission `gorm:"size:50;not null;uniqueIndex:idx_role_permission" json:"permission"`
+}
+
+type OrgUnitType string
+
+const (
+	OrgUnitFaculty    OrgUnitType = "faculty"
+	OrgUnitDepartment OrgUnitType = "department"
+	OrgUnitBuilding   OrgUnitType = "building"
+)
+
+// OrgUnit is a faculty, department or building. Units form a tree; staff
+// linked to a unit handle the complaints of every category in its subtree.
+type OrgUnit struct {
+	ID        uint        `gorm:"primaryKey" json:"id"`
+	Name      string      `gorm:"size:100;not null" json:"name"`
+	Code      string      `gorm:"size:20;not null;uniqueIndex" json:"code"`
+	Type      OrgUnitType `gorm:"size:20;not null" json:"type"`
+	ParentID  *uint       `gorm:"index" json:"parent_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// ImpersonationSession records an administrator viewing the app as another
+// user. Tokens issued for it stop working once it is ended or expires.
+type ImpersonationSession struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ImpersonatorID uint       `gorm:"not null;index" json:"impersonator_id"`
+	Impersonator   User       `gorm:"foreignKey:ImpersonatorID" json:"impersonator,omitempty"`
+	TargetUserID   uint       `gorm:"not null;index" json:"target_user_id"`
+	TargetUser     User       `gorm:"foreignKey:TargetUserID" json:"target_user,omitempty"`
+	Reason         string     `gorm:"size:255;not null" json:"reason"`
+	IPAddress      string     `gorm:"size:45" json:"ip_address"`
+	UserAgent      string     `gorm:"size:255" json:"user_agent"`
+	ExpiresAt      time.Time  `gorm:"not null" json:"expires_at"`
+	EndedAt        *time.Time `json:"ended_at"`
+	EndedByID      *uint      `json:"ended_by_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ImpersonationAction is one request made while impersonating, including
+// requests that were refused.
+type ImpersonationAction struct {
+	ID                     uint      `gorm:"primaryKey" json:"id"`
+	ImpersonationSessionID uint      `gorm:"not null;index" json:"impersonation_session_id"`
+	Method                 string    `gorm:"size:10;not null" json:"method"`
+	Path                   string    `gorm:"size:255;not null" json:"path"`
+	Route                  string    `gorm:"size:255" json:"route"`
+	Status                 int       `gorm:"not null" json:"status"`
+	IPAddress              string    `gorm:"size:45" json:"ip_address"`
+	CreatedAt              time.Time `json:"created_at"`
+}
+
+// EmailVerificationToken confirms that a user owns an email address before it
+// replaces their current one. Only the SHA-256 hash of the token is stored.
+type EmailVerificationToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Email     string     `gorm:"size:255;not null" json:"email"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ErrAuditLogImmutable is returned when code tries to change an audit entry.
+var ErrAuditLogImmutable = errors.New("audit log entries cannot be changed")
+
+// AuditLog records a mutating API call: who made it, what it targeted and
+// which fields changed. Entries are append-only and each Hash covers the
+// entry and the previous Hash, so editing or removing a row breaks the chain.
+type AuditLog struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	ActorID        *uint           `gorm:"index" json:"actor_id"`
+	Actor          *User           `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+	ImpersonatorID *uint           `gorm:"index" json:"impersonator_id,omitempty"`
+	Action         string          `gorm:"size:100;not null;index" json:"action"`
+	Method         string          `gorm:"size:10;not null" json:"method"`
+	Path           string          `gorm:"size:255;not null" json:"path"`
+	TargetType     string          `gorm:"size:50;index:idx_audit_target" json:"target_type"`
+	TargetID       string          `gorm:"size:50;index:idx_audit_target" json:"target_id"`
+	Status         int             `gorm:"not null;index" json:"status"`
+	Changes        json.RawMessage `gorm:"type:longtext;not null" json:"changes"`
+	IPAddress      string          `gorm:"size:45" json:"ip_address"`
+	UserAgent      string          `gorm:"size:255" json:"user_agent"`
+	PrevHash       string          `gorm:"size:64;not null" json:"prev_hash"`
+	Hash           string          `gorm:"size:64;not null;uniqueIndex" json:"hash"`
+	CreatedAt      time.Time       `gorm:"index" json:"created_at"`
+}
+
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return ErrAuditLogImmutable }
+
+func (AuditLog) BeforeDelete(*gorm.DB) error { return ErrAuditLogImmutable }
+
+type ReportType string
+
+const (
+	ReportSummaryPDF     ReportType = "summary_pdf"
+	ReportComplaintsCSV  ReportType = "complaints_csv"
+	ReportComplaintsXLSX ReportType = "complaints_xlsx"
+)
+
+type ReportCadence string
+
+const (
+	CadenceDaily   ReportCadence = "daily"
+	CadenceWeekly  ReportCadence = "weekly"
+	CadenceMonthly ReportCadence = "monthly"
+)
+
+// ReportSubscription emails a report to its recipients on every cadence. The
+// report is generated with the permissions of the owner, the user who last
+// saved the subscription.
+type ReportSubscription struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	Name       string          `gorm:"size:100;not null" json:"name"`
+	ReportType ReportType      `gorm:"size:20;not null" json:"report_type"`
+	Status     ComplaintStatus `gorm:"size:20" json:"status,omitempty"`
+	Search     string          `gorm:"size:255" json:"search,omitempty"`
+	Cadence    ReportCadence   `gorm:"size:20;not null" json:"cadence"`
+	Recipients string          `gorm:"type:text;not null" json:"recipients"`
+	Enabled    bool            `gorm:"not null;default:true" json:"enabled"`
+	OwnerID    uint            `gorm:"not null;index" json:"owner_id"`
+	Owner      *User           `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	NextRunAt  time.Time       `gorm:"index" json:"next_run_at"`
+	LastRunAt  *time.Time      `json:"last_run_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+type ReportDeliveryStatus string
+
+const (
+	DeliverySent   ReportDeliveryStatus = "sent"
+	DeliveryFailed ReportDeliveryStatus = "failed"
+)
+
+// ReportDelivery records one attempt to email a subscription's report.
+type ReportDelivery struct {
+	ID             uint                 `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint                 `gorm:"not null;index" json:"subscription_id"`
+	PeriodStart    time.Time            `json:"period_start"`
+	PeriodEnd      time.Time            `json:"period_end"`
+	Recipients     string               `gorm:"type:text;not null" json:"recipients"`
+	Filename       string               `gorm:"size:255" json:"filename"`
+	Size           int                  `json:"size"`
+	Status         ReportDeliveryStatus `gorm:"size:20;not null;index" json:"status"`
+	Error          string               `gorm:"type:text" json:"error,omitempty"`
+	// Manual is set when the report was sent on request instead of on schedule
+	Manual    bool      `gorm:"not null;default:false" json:"manual"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcStateMinutes = 10
+	oidcCacheTTL     = time.Hour
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is the part of the discovery document we use
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// The discovery document and signing keys are cached; keys are fetched again
+// when a token is signed with an unknown key ID.
+var oidcCache struct {
+	sync.Mutex
+	provider  *oidcProvider
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// OIDCStateClaims travel in a short lived cookie between the redirect to the
+// identity provider and the callback.
+type OIDCStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+func oidcEnabled() bool {
+	return config.AppConfig.OIDCIssuer != "" && config.AppConfig.OIDCClientID != ""
+}
+
+func oidcStateKey() []byte {
+	return []byte(config.AppConfig.JWTSecret + ":oidc")
+}
+
+func fetchJSON(rawURL string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// loadOIDCProvider returns the issuer's discovery document and signing keys.
+func loadOIDCProvider(refreshKeys bool) (*oidcProvider, map[string]*rsa.PublicKey, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+
+	if oidcCache.provider != nil && !refreshKeys && time.Since(oidcCache.fetchedAt) < oidcCacheTTL {
+		return oidcCache.provider, oidcCache.keys, nil
+	}
+
+	issuer := strings.TrimRight(config.AppConfig.OIDCIssuer, "/")
+	var provider oidcProvider
+	if err := fetchJSON(issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimRight(provider.Issuer, "/") != issuer {
+		return nil, nil, fmt.Errorf("discovery document issuer %q does not match %q", provider.Issuer, issuer)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(provider.JWKSURI, &jwks); err != nil {
+		return nil, nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		publicKey, err := parseRSAKey(key)
+		if err != nil {
+			log.Printf("Warning: Skipping OIDC signing key %q: %v", key.Kid, err)
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+
+	oidcCache.provider = &provider
+	oidcCache.keys = keys
+	oidcCache.fetchedAt = time.Now()
+	return oidcCache.provider, oidcCache.keys, nil
+}
+
+func parseRSAKey(key jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// verifyIDToken checks the ID token signature, issuer, audience, expiry and
+// nonce, and returns its claims.
+func verifyIDToken(rawToken, nonce string) (jwt.MapClaims, error) {
+	provider, _, err := loadOIDCProvider(false)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		_, keys, err := loadOIDCProvider(false)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		// The provider may have rotated its keys
+		if _, keys, err = loadOIDCProvider(true); err != nil {
+			return nil, err
+		}
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(config.AppConfig.OIDCClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	return claims, nil
+}
+
+// exchangeOIDCCode redeems the authorization code for an ID token.
+func exchangeOIDCCode(provider *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.AppConfig.OIDCRedirectURL},
+		"client_id":     {config.AppConfig.OIDCClientID},
+		"code_verifier": {verifier},
+	}
+	if config.AppConfig.OIDCClientSecret != "" {
+		form.Set("client_secret", config.AppConfig.OIDCClientSecret)
+	}
+
+	resp, err := oidcHTTPClient.PostForm(provider.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s: %s", resp.Status, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	if name == "" {
+		return ""
+	}
+	switch value := claims[name].(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return fmt.Sprintf("%.0f", value)
+	}
+	return ""
+}
+
+// errOIDCAccountExists is returned when the email or student number of an ID
+// token belongs to an account that is not linked automatically.
+var errOIDCAccountExists = errors.New("an account that cannot be linked automatically already has this email or student ID")
+
+// oidcUser finds the user an ID token belongs to. Users are matched by IdP
+// subject, then by verified email, then by student number; the subject is
+// linked on first login. SSO accounts without a subject are linked by email or
+// student number, local accounts only by an email they verified here too, so
+// an account can never be taken over through claims the identity provider
+// alone controls. Administrative accounts are never linked. Unknown users are
+// provisioned as students.
+func oidcUser(claims jwt.MapClaims) (models.User, error) {
+	subject := claimString(claims, "sub")
+	if subject == "" {
+		return models.User{}, errors.New("ID token has no subject")
+	}
+	var email string
+	if verified, _ := claims["email_verified"].(bool); verified {
+		email = claimString(claims, config.AppConfig.OIDCEmailClaim)
+	}
+	studentID := claimString(claims, config.AppConfig.OIDCStudentIDClaim)
+	name := claimString(claims, config.AppConfig.OIDCNameClaim)
+	privilegedRoles := administrativeRoles()
+
+	var user models.User
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("oidc_subject = ?", subject).First(&user).Error; err == nil {
+			return nil
+		}
+		// The identity provider vouches for the email, so a registration that
+		// never proved owning it must not be linked or block the account
+		if err := purgeUnverifiedRegistrations(tx, true, "", email, studentID); err != nil {
+			return err
+		}
+		// Service accounts can never log in interactively
+		linkable := func() *gorm.DB {
+			return tx.Where("oidc_subject IS NULL AND is_service_account = ? AND auth_provider = ? AND role NOT IN ?",
+				false, models.AuthProviderOIDC, privilegedRoles)
+		}
+		if email != "" && linkable().Where("email = ?", email).First(&user).Error == nil {
+			return tx.Model(&user).Update("oidc_subject", subject).Error
+		}
+		if studentID != "" && linkable().Where("student_id = ?", studentID).First(&user).Error == nil {
+			return tx.Model(&user).Update("oidc_subject", subject).Error
+		}
+		// Both the identity provider and this app verified the email
+		if email != "" && tx.Where("oidc_subject IS NULL AND is_service_account = ? AND auth_provider = ? AND role NOT IN ?",
+			false, models.AuthProviderLocal, privilegedRoles).
+			Where("email = ? AND email_verified_at IS NOT NULL", email).First(&user).Error == nil {
+			return tx.Model(&user).Update("oidc_subject", subject).Error
+		}
+
+		var taken int64
+		tx.Unscoped().Model(&models.User{}).
+			Where("(email = ? AND email <> '') OR (student_id = ? AND student_id <> '')", email, studentID).Count(&taken)
+		if taken > 0 {
+			return errOIDCAccountExists
+		}
+		if email == "" {
+			return errors.New("ID token has no verified email")
+		}
+		username, err := availableUsername(tx, claimString(claims, config.AppConfig.OIDCUsernameClaim), email)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			name = username
+		}
+		// SSO users never log in with a password, so an unguessable one is stored
+		password, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+		hashedPassword, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		user = models.User{
+			Username:     username,
+			StudentID:    studentIDColumn(studentID),
+			Email:        email,
+			Password:     hashedPassword,
+			Name:         name,
+			Role:         models.RoleStudent,
+			AuthProvider: models.AuthProviderOIDC,
+			OIDCSubject:  &subject,
+		}
+		return tx.Create(&user).Error
+	})
+	return user, err
+}
+
+// availableUsername derives a free username from the preferred one or the
+// local part of the email.
+func availableUsername(tx *gorm.DB, preferred, email string) (string, error) {
+	base := preferred
+	if base == "" {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+	if base == "" {
+		return "", errors.New("cannot derive a username")
+	}
+	for i := 0; i < 100; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s%d", base, i+1)
+		}
+		var count int64
+		tx.Model(&models.User{}).Where("username = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("cannot find a free username")
+}
+
+// redirectToLogin sends the browser back to the login page with the result in
+// the URL fragment, which is never sent to a server.
+func redirectToLogin(c *gin.Context, values url.Values) {
+	c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, "/login#"+values.Encode())
+}
+
+func getAuthProviders(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"oidc": oidcEnabled(),
+		"registration": gin.H{
+			"enabled":       config.AppConfig.RegistrationEnabled,
+			"email_domains": registrationDomains(),
+		},
+	})
+}
+
+// oidcLogin redirects the browser to the identity provider.
+func oidcLogin(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(404, gin.H{"error": "SSO login is not configured"})
+		return
+	}
+	provider, _, err := loadOIDCProvider(false)
+	if err != nil {
+		log.Printf("Error loading OIDC provider: %v", err)
+		c.JSON(502, gin.H{"error": "SSO provider is unavailable"})
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		if values[i], err = randomToken(32); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to start SSO login"})
+			return
+		}
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	stateToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, OIDCStateClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateMinutes * time.Minute)),
+		},
+	}).SignedString(oidcStateKey())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start SSO login"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, stateToken, oidcStateMinutes*60, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {config.AppConfig.OIDCClientID},
+		"redirect_uri":          {config.AppConfig.OIDCRedirectURL},
+		"scope":                 {config.AppConfig.OIDCScopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, provider.AuthorizationEndpoint+separator+query.Encode())
+}
+
+// oidcCallback completes the SSO login started by oidcLogin.
+func oidcCallback(c *gin.Context) {
+	if errCode := c.Query("error"); errCode != "" {
+		redirectToLogin(c, url.Values{"sso_error": {"SSO login was cancelled or denied"}})
+		return
+	}
+
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		redirectToLogin(c, url.Values{"sso_error": {"SSO login expired, please try again"}})
+		return
+	}
+	stateClaims := &OIDCStateClaims{}
+	if _, err := jwt.ParseWithClaims(cookie, stateClaims, func(token *jwt.Token) (interface{}, error) {
+		return oidcStateKey(), nil
+	}, jwt.WithValidMethods([]string{"HS256"})); err != nil || stateClaims.State != c.Query("state") {
+		redirectToLogin(c, url.Values{"sso_error": {"SSO login expired, please try again"}})
+		return
+	}
+
+	provider, _, err := loadOIDCProvider(false)
+	if err != nil {
+		log.Printf("Error loading OIDC provider: %v", err)
+		redirectToLogin(c, url.Values{"sso_error": {"SSO provider is unavailable"}})
+		return
+	}
+	idToken, err := exchangeOIDCCode(provider, c.Query("code"), stateClaims.Verifier)
+	if err != nil {
+		log.Printf("Error exchanging OIDC code: %v", err)
+		redirectToLogin(c, url.Values{"sso_error": {"SSO login failed"}})
+		return
+	}
+	claims, err := verifyIDToken(idToken, stateClaims.Nonce)
+	if err != nil {
+		log.Printf("Error verifying OIDC ID token: %v", err)
+		redirectToLogin(c, url.Values{"sso_error": {"SSO login failed"}})
+		return
+	}
+
+	user, err := oidcUser(claims)
+	if errors.Is(err, errOIDCAccountExists) {
+		redirectToLogin(c, url.Values{"sso_error": {"An account with your email or student ID already exists. Sign in with your password and confirm your email address to use SSO with it, or contact the administrator."}})
+		return
+	}
+	if err != nil {
+		log.Printf("Error provisioning OIDC user: %v", err)
+		redirectToLogin(c, url.Values{"sso_error": {"Your SSO account could not be linked"}})
+		return
+	}
+	if !user.IsActive {
+		recordLoginAttempt(c, user.Username, &user, false, "deactivated")
+		redirectToLogin(c, url.Values{"sso_error": {"Your account has been deactivated"}})
+		return
+	}
+	if code, msg := registrationError(user); code != "" {
+		recordLoginAttempt(c, user.Username, &user, false, code)
+		redirectToLogin(c, url.Values{"sso_error": {msg}})
+		return
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, err := generateMFAToken(user.ID)
+		if err != nil {
+			redirectToLogin(c, url.Values{"sso_error": {"SSO login failed"}})
+			return
+		}
+		redirectToLogin(c, url.Values{"mfa_token": {mfaToken}})
+		return
+	}
+
+	response, err := startSession(c, user)
+	if err != nil {
+		redirectToLogin(c, url.Values{"sso_error": {"SSO login failed"}})
+		return
+	}
+	redirectToLogin(c, url.Values{
+		"token":                     {response["token"].(string)},
+		"refresh_token":             {response["refresh_token"].(string)},
+		"role":                      {string(user.Role)},
+		"two_factor_setup_required": {fmt.Sprint(response["two_factor_setup_required"])},
+	})
+}
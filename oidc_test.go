@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider is an identity provider serving discovery, signing keys
+// and a token endpoint that answers every code with an ID token for claims.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	nonce  string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]string{"id_token": p.idToken(t, p.nonce)})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	config.AppConfig.OIDCIssuer = p.server.URL
+	config.AppConfig.OIDCClientID = "simplee-k"
+	config.AppConfig.OIDCRedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+	config.AppConfig.OIDCScopes = "openid email profile"
+	oidcCache.Lock()
+	oidcCache.provider = nil
+	oidcCache.Unlock()
+	return p
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// idToken signs the provider's claims as an ID token for the client.
+func (p *mockOIDCProvider) idToken(t *testing.T, nonce string) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   "simplee-k",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// login verifies an ID token for the claims and resolves its user.
+func (p *mockOIDCProvider) login(t *testing.T, claims jwt.MapClaims) (models.User, error) {
+	t.Helper()
+	p.claims = claims
+	verified, err := verifyIDToken(p.idToken(t, "nonce"), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oidcUser(verified)
+}
+
+func TestOIDCCallbackFlow(t *testing.T) {
+	setupTestDB(t)
+	p := newMockOIDCProvider(t)
+	p.claims = jwt.MapClaims{"sub": "sub-1", "email": "new@example.com", "email_verified": true, "name": "New Student"}
+
+	r := gin.New()
+	r.GET("/api/auth/oidc/login", oidcLogin)
+	r.GET("/api/auth/oidc/callback", oidcCallback)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login = %d, want 302", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), p.server.URL+"/authorize") {
+		t.Fatalf("login redirected to %q", w.Header().Get("Location"))
+	}
+	p.nonce = location.Query().Get("nonce")
+	cookie := w.Result().Cookies()[0]
+
+	req := httptest.NewRequest("GET", "/api/auth/oidc/callback?code=good-code&state="+location.Query().Get("state"), nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	fragment, _ := url.ParseQuery(strings.TrimPrefix(w.Header().Get("Location"), "/login#"))
+	if fragment.Get("token") == "" {
+		t.Fatalf("callback redirected to %q, want tokens", w.Header().Get("Location"))
+	}
+
+	var user models.User
+	if err := DB.Where("oidc_subject = ?", "sub-1").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "new@example.com" || user.Role != models.RoleStudent || user.AuthProvider != models.AuthProviderOIDC {
+		t.Errorf("provisioned %+v", user)
+	}
+}
+
+func TestOIDCUserLinking(t *testing.T) {
+	setupTestDB(t)
+	p := newMockOIDCProvider(t)
+
+	local := createTestUser(t, "local", models.RoleStudent)
+	admin := createTestUser(t, "ssoadmin", models.RoleAdmin)
+	DB.Model(&admin).Update("auth_provider", models.AuthProviderOIDC)
+	unlinked := createTestUser(t, "unlinked", models.RoleStudent)
+	DB.Model(&unlinked).Update("auth_provider", models.AuthProviderOIDC)
+	verified := createTestUser(t, "verified", models.RoleStudent)
+	DB.Model(&verified).Update("email_verified_at", time.Now())
+	verifiedAdmin := createTestUser(t, "verifiedadmin", models.RoleAdmin)
+	DB.Model(&verifiedAdmin).Update("email_verified_at", time.Now())
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		wantID uint
+	}{
+		{"local account by unverified email", jwt.MapClaims{"sub": "a", "email": local.Email, "email_verified": true}, 0},
+		{"local account by student ID", jwt.MapClaims{"sub": "b", "email": "b@example.com", "email_verified": true, "student_number": studentIDOf(local)}, 0},
+		{"verified local administrator", jwt.MapClaims{"sub": "g", "email": verifiedAdmin.Email, "email_verified": true}, 0},
+		{"local account by email verified only here", jwt.MapClaims{"sub": "h", "email": verified.Email}, 0},
+		{"administrative SSO account", jwt.MapClaims{"sub": "c", "email": admin.Email, "email_verified": true}, 0},
+		{"unverified email", jwt.MapClaims{"sub": "d", "email": unlinked.Email}, 0},
+		{"email explicitly unverified", jwt.MapClaims{"sub": "e", "email": unlinked.Email, "email_verified": false}, 0},
+		{"SSO account by verified email", jwt.MapClaims{"sub": "f", "email": unlinked.Email, "email_verified": true}, unlinked.ID},
+		{"local account by email verified on both sides", jwt.MapClaims{"sub": "i", "email": verified.Email, "email_verified": true}, verified.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := p.login(t, tt.claims)
+			if tt.wantID == 0 {
+				if err == nil {
+					t.Fatalf("linked to user %d (%s), want an error", user.ID, user.Username)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != tt.wantID {
+				t.Errorf("got user %d, want %d", user.ID, tt.wantID)
+			}
+		})
+	}
+
+	var count int64
+	DB.Model(&models.User{}).Where("oidc_subject IS NOT NULL").Count(&count)
+	if count != 2 {
+		t.Errorf("%d users have a subject, want 2", count)
+	}
+
+	var linked models.User
+	DB.First(&linked, verified.ID)
+	if linked.AuthProvider != models.AuthProviderLocal || linked.Password != verified.Password {
+		t.Errorf("linking changed the local account to %+v", linked)
+	}
+}
+
+func TestOIDCUsersWithoutStudentID(t *testing.T) {
+	setupTestDB(t)
+	p := newMockOIDCProvider(t)
+
+	for _, sub := range []string{"one", "two"} {
+		user, err := p.login(t, jwt.MapClaims{"sub": sub, "email": sub + "@example.com", "email_verified": true})
+		if err != nil {
+			t.Fatalf("%s: %v", sub, err)
+		}
+		if user.StudentID != nil {
+			t.Errorf("%s: student ID = %q, want none", sub, *user.StudentID)
+		}
+	}
+}
+
+func TestOIDCCallbackExplainsUnlinkableAccount(t *testing.T) {
+	setupTestDB(t)
+	p := newMockOIDCProvider(t)
+	local := createTestUser(t, "local", models.RoleStudent)
+	p.claims = jwt.MapClaims{"sub": "sub-1", "email": local.Email, "email_verified": true}
+
+	r := gin.New()
+	r.GET("/api/auth/oidc/login", oidcLogin)
+	r.GET("/api/auth/oidc/callback", oidcCallback)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/oidc/login", nil))
+	location, _ := url.Parse(w.Header().Get("Location"))
+	p.nonce = location.Query().Get("nonce")
+
+	req := httptest.NewRequest("GET", "/api/auth/oidc/callback?code=good-code&state="+location.Query().Get("state"), nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	fragment, _ := url.ParseQuery(strings.TrimPrefix(w.Header().Get("Location"), "/login#"))
+	if msg := fragment.Get("sso_error"); !strings.Contains(msg, "already exists") {
+		t.Errorf("callback redirected to %q, want the existing account explained", w.Header().Get("Location"))
+	}
+}
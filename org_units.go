@@ -0,0 +1,300 @@
+package main
+
+import (
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type OrgUnitRequest struct {
+	Name     string `json:"name" binding:"required,max=100"`
+	Code     string `json:"code" binding:"required,max=20"`
+	Type     string `json:"type" binding:"required,oneof=faculty department building"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+type AssignOrgUnitRequest struct {
+	OrgUnitID *uint `json:"org_unit_id"`
+}
+
+// orgUnitSubtree returns the unit and all units below it.
+func orgUnitSubtree(unitID uint) []uint {
+	var units []models.OrgUnit
+	DB.Select("id", "parent_id").Find(&units)
+	children := map[uint][]uint{}
+	for _, unit := range units {
+		if unit.ParentID != nil {
+			children[*unit.ParentID] = append(children[*unit.ParentID], unit.ID)
+		}
+	}
+
+	ids := []uint{unitID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
+
+// orgUnitAncestors returns the unit and all units above it.
+func orgUnitAncestors(unitID uint) []uint {
+	var units []models.OrgUnit
+	DB.Select("id", "parent_id").Find(&units)
+	parents := map[uint]*uint{}
+	for _, unit := range units {
+		parents[unit.ID] = unit.ParentID
+	}
+
+	ids := []uint{unitID}
+	for parent := parents[unitID]; parent != nil && len(ids) <= len(units); parent = parents[*parent] {
+		ids = append(ids, *parent)
+	}
+	return ids
+}
+
+// unitCategoryIDs returns the categories the current user's unit handles. It
+// is cached on the request since several checks may need it.
+func unitCategoryIDs(c *gin.Context) []uint {
+	if ids, ok := c.Get("unit_category_ids"); ok {
+		return ids.([]uint)
+	}
+
+	ids := []uint{}
+	var user models.User
+	if err := DB.Select("id", "org_unit_id").First(&user, getUserID(c)).Error; err == nil && user.OrgUnitID != nil {
+		DB.Model(&models.Category{}).Where("org_unit_id IN ?", orgUnitSubtree(*user.OrgUnitID)).Pluck("id", &ids)
+	}
+	c.Set("unit_category_ids", ids)
+	return ids
+}
+
+// visibleComplaints limits a complaint query to what the current user may
+// see: students their own complaints, unit staff the complaints of their
+// unit's categories and everyone else all complaints.
+func visibleComplaints(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !hasPermission(c, models.PermComplaintsReadAll) {
+			return db.Where("complaints.user_id = ?", getUserID(c))
+		}
+		if !hasPermission(c, models.PermComplaintsAllUnits) {
+			return db.Where("complaints.category_id IN ?", unitCategoryIDs(c))
+		}
+		return db
+	}
+}
+
+// inUserUnit reports whether staff limited to their unit may handle the
+// complaint. Staff with complaints.all_units may handle every complaint.
+func inUserUnit(c *gin.Context, complaint models.Complaint) bool {
+	if hasPermission(c, models.PermComplaintsAllUnits) {
+		return true
+	}
+	for _, id := range unitCategoryIDs(c) {
+		if id == complaint.CategoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// complaintHandlers returns the users who handle complaints of a category:
+// those with every unit plus those linked to the category's unit or a unit
+// above it.
+func complaintHandlers(categoryID uint) []models.User {
+	var allUnitRoles, unitRoles []string
+	for _, role := range rolesWithPermission(models.PermComplaintsUpdate) {
+		if roleHasPermission(models.UserRole(role), models.PermComplaintsAllUnits) {
+			allUnitRoles = append(allUnitRoles, role)
+		} else {
+			unitRoles = append(unitRoles, role)
+		}
+	}
+
+	query := DB.Where("role IN ?", allUnitRoles)
+	var category models.Category
+	if err := DB.First(&category, categoryID).Error; err == nil && category.OrgUnitID != nil {
+		query = query.Or("role IN ? AND org_unit_id IN ?", unitRoles, orgUnitAncestors(*category.OrgUnitID))
+	}
+
+	var users []models.User
+	query.Find(&users)
+	return users
+}
+
+func getOrgUnits(c *gin.Context) {
+	query := DB.Model(&models.OrgUnit{})
+	if unitType := c.Query("type"); unitType != "" {
+		query = query.Where("type = ?", unitType)
+	}
+	if parentID := c.Query("parent_id"); parentID != "" {
+		query = query.Where("parent_id = ?", parentID)
+	}
+	var units []models.OrgUnit
+	query.Order("type, name").Find(&units)
+	c.JSON(200, gin.H{"data": units})
+}
+
+func getOrgUnit(c *gin.Context) {
+	var unit models.OrgUnit
+	if err := DB.First(&unit, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Organizational unit not found"})
+		return
+	}
+	var categories []models.Category
+	DB.Where("org_unit_id = ?", unit.ID).Order("name").Find(&categories)
+	var userCount int64
+	DB.Model(&models.User{}).Where("org_unit_id = ?", unit.ID).Count(&userCount)
+	c.JSON(200, gin.H{"data": unit, "categories": categories, "user_count": userCount})
+}
+
+// validateOrgUnitParent checks that the parent exists and that making it the
+// parent would not create a cycle.
+func validateOrgUnitParent(unitID uint, parentID *uint) string {
+	if parentID == nil {
+		return ""
+	}
+	var parent models.OrgUnit
+	if err := DB.First(&parent, *parentID).Error; err != nil {
+		return "Parent unit not found"
+	}
+	if unitID != 0 {
+		for _, id := range orgUnitSubtree(unitID) {
+			if id == *parentID {
+				return "A unit cannot be moved below itself"
+			}
+		}
+	}
+	return ""
+}
+
+func createOrgUnit(c *gin.Context) {
+	var req OrgUnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateOrgUnitParent(0, req.ParentID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+	var count int64
+	DB.Model(&models.OrgUnit{}).Where("code = ?", req.Code).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"error": "Unit code already exists"})
+		return
+	}
+
+	unit := models.OrgUnit{Name: req.Name, Code: req.Code, Type: models.OrgUnitType(req.Type), ParentID: req.ParentID}
+	if err := DB.Create(&unit).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create organizational unit"})
+		return
+	}
+	c.JSON(201, unit)
+}
+
+func updateOrgUnit(c *gin.Context) {
+	var unit models.OrgUnit
+	if err := DB.First(&unit, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Organizational unit not found"})
+		return
+	}
+	var req OrgUnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateOrgUnitParent(unit.ID, req.ParentID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+	var count int64
+	DB.Model(&models.OrgUnit{}).Where("code = ? AND id <> ?", req.Code, unit.ID).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"error": "Unit code already exists"})
+		return
+	}
+
+	unit.Name = req.Name
+	unit.Code = req.Code
+	unit.Type = models.OrgUnitType(req.Type)
+	unit.ParentID = req.ParentID
+	if err := DB.Save(&unit).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update organizational unit"})
+		return
+	}
+	c.JSON(200, unit)
+}
+
+// deleteOrgUnit removes a unit that has no sub-units, categories or users.
+func deleteOrgUnit(c *gin.Context) {
+	var unit models.OrgUnit
+	if err := DB.First(&unit, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Organizational unit not found"})
+		return
+	}
+	var children, categories, users int64
+	DB.Model(&models.OrgUnit{}).Where("parent_id = ?", unit.ID).Count(&children)
+	DB.Model(&models.Category{}).Where("org_unit_id = ?", unit.ID).Count(&categories)
+	DB.Model(&models.User{}).Where("org_unit_id = ?", unit.ID).Count(&users)
+	if children+categories+users > 0 {
+		c.JSON(400, gin.H{"error": "Unit still has sub-units, categories or users"})
+		return
+	}
+	if err := DB.Delete(&unit).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete organizational unit"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Organizational unit deleted successfully"})
+}
+
+func bindOrgUnit(c *gin.Context) (*uint, bool) {
+	var req AssignOrgUnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if req.OrgUnitID != nil {
+		var unit models.OrgUnit
+		if err := DB.First(&unit, *req.OrgUnitID).Error; err != nil {
+			c.JSON(400, gin.H{"error": "Organizational unit not found"})
+			return nil, false
+		}
+	}
+	return req.OrgUnitID, true
+}
+
+// setCategoryOrgUnit links a category to a unit, or unlinks it with null.
+func setCategoryOrgUnit(c *gin.Context) {
+	var category models.Category
+	if err := DB.First(&category, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+	unitID, ok := bindOrgUnit(c)
+	if !ok {
+		return
+	}
+	if err := DB.Model(&category).Update("org_unit_id", unitID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update category"})
+		return
+	}
+	c.JSON(200, category)
+}
+
+// setUserOrgUnit links a handler account to a unit, or unlinks it with null.
+func setUserOrgUnit(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	unitID, ok := bindOrgUnit(c)
+	if !ok {
+		return
+	}
+	if err := DB.Model(&user).Update("org_unit_id", unitID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update user"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Organizational unit updated successfully", "org_unit_id": unitID})
+}
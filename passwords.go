@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Routes a user who must change their password can still reach. The 2FA
+// enrollment routes are included so a staff account that has to do both is
+// not locked out by the other gate.
+var passwordChangeAllowedRoutes = map[string]bool{
+	"/api/profile":            true,
+	"/api/profile/password":   true,
+	"/api/profile/2fa/setup":  true,
+	"/api/profile/2fa/enable": true,
+	"/api/logout":             true,
+	"/api/logout-all":         true,
+}
+
+// The seeded default passwords are public and always denied
+var builtinDeniedPasswords = []string{"admin123", "student123", "password", "12345678", "123456789"}
+
+var (
+	deniedPasswords     map[string]bool
+	deniedPasswordsOnce sync.Once
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// loadDeniedPasswords reads the configured deny-list file, one password per line.
+func loadDeniedPasswords() {
+	deniedPasswords = map[string]bool{}
+	for _, password := range builtinDeniedPasswords {
+		deniedPasswords[password] = true
+	}
+
+	path := config.AppConfig.PasswordDenyListFile
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Warning: Could not open password deny list: %v", err)
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			deniedPasswords[strings.ToLower(line)] = true
+		}
+	}
+	log.Printf("Loaded %d denied passwords", len(deniedPasswords))
+}
+
+// validatePassword checks a new password against the configured policy.
+func validatePassword(password string, user models.User) error {
+	if len(password) < config.AppConfig.PasswordMinLength {
+		return fmt.Errorf("Password must be at least %d characters", config.AppConfig.PasswordMinLength)
+	}
+
+	deniedPasswordsOnce.Do(loadDeniedPasswords)
+	lower := strings.ToLower(password)
+	if deniedPasswords[lower] {
+		return errors.New("Password is too common, please choose another one")
+	}
+	if user.Username != "" && lower == strings.ToLower(user.Username) {
+		return errors.New("Password must not be the same as the username")
+	}
+	return nil
+}
+
+// setUserPassword stores a new password and signs the user out everywhere.
+func setUserPassword(tx *gorm.DB, userID uint, password string, mustChange bool) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password":             hashedPassword,
+		"must_change_password": mustChange,
+	}).Error; err != nil {
+		return err
+	}
+	return revokeUserTokens(tx, userID)
+}
+
+// changePassword lets a signed in user change their password after verifying
+// the current one. Other sessions are logged out and a fresh token pair is
+// returned for this one.
+func changePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.AuthProvider != "" && user.AuthProvider != models.AuthProviderLocal {
+		c.JSON(400, gin.H{"error": "Your password is managed by the campus account system"})
+		return
+	}
+	if !checkPasswordHash(req.CurrentPassword, user.Password) {
+		c.JSON(400, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(400, gin.H{"error": "New password must be different from the current password"})
+		return
+	}
+	if err := validatePassword(req.NewPassword, user); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		return setUserPassword(tx, user.ID, req.NewPassword, false)
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	DB.First(&user, user.ID)
+	token, refreshToken, _, err := issueTokenPair(c, user, "")
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response := tokenResponse(token, refreshToken)
+	response["message"] = "Password changed successfully"
+	c.JSON(200, response)
+}
+
+// forgotPassword emails a single-use reset link. It always answers the same
+// way so it cannot be used to find out which emails are registered.
+func forgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "If the email is registered, a password reset link has been sent"}
+
+	var user models.User
+	if err := DB.Where("email = ? AND auth_provider = ?", req.Email, models.AuthProviderLocal).First(&user).Error; err != nil {
+		c.JSON(200, response)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.PasswordResetMinutes) * time.Minute),
+	}
+	if err := DB.Create(&resetToken).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimRight(config.AppConfig.AppBaseURL, "/"), token)
+	body := fmt.Sprintf("Hello %s,\n\nWe received a request to reset your SIMPEL-K password. Open the link below to choose a new password:\n\n%s\n\nThe link expires in %d minutes and can only be used once. If you did not request this, you can ignore this email.\n",
+		user.Name, link, config.AppConfig.PasswordResetMinutes)
+	if err := sendEmail([]string{user.Email}, "Reset your SIMPEL-K password", body); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+
+	c.JSON(200, response)
+}
+
+// resetPassword sets a new password using a token from forgotPassword.
+func resetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resetToken models.PasswordResetToken
+	if err := DB.Where("token_hash = ?", hashToken(req.Token)).First(&resetToken).Error; err != nil ||
+		resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, resetToken.UserID).Error; err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err := validatePassword(req.NewPassword, user); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Mark the token used first so it cannot be replayed concurrently
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("token already used")
+		}
+		return setUserPassword(tx, user.ID, req.NewPassword, false)
+	})
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Password has been reset, please log in with your new password"})
+}
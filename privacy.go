@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type EraseUserRequest struct {
+	// Confirm must repeat the username to guard against erasing the wrong user
+	Confirm string `json:"confirm" binding:"required"`
+}
+
+// erasedName replaces the name of an erased user everywhere it is shown.
+const erasedName = "Erased User"
+
+// exportUserData writes a ZIP archive with everything stored about the user:
+// data.json with the profile, complaints, watched complaints, notifications,
+// API keys and login history, plus the complaint attachments and avatar.
+func exportUserData(c *gin.Context, user models.User) {
+	var complaints []models.Complaint
+	DB.Unscoped().Preload("Category").Where("user_id = ?", user.ID).Order("created_at ASC").Find(&complaints)
+	var watched []models.ComplaintWatcher
+	DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&watched)
+	var notifications []models.Notification
+	DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&notifications)
+	var apiKeys []models.APIKey
+	DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&apiKeys)
+	var loginAttempts []models.LoginAttempt
+	DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&loginAttempts)
+
+	complaintData := make([]gin.H, len(complaints))
+	for i, complaint := range complaints {
+		complaintData[i] = gin.H{
+			"ticket_id":      complaint.TicketID,
+			"category":       complaint.Category.Name,
+			"title":          complaint.Title,
+			"description":    complaint.Description,
+			"status":         complaint.Status,
+			"priority":       complaint.Priority,
+			"tags":           complaint.Tags,
+			"admin_response": complaint.AdminResponse,
+			"created_at":     complaint.CreatedAt,
+			"updated_at":     complaint.UpdatedAt,
+		}
+		if complaint.EvidencePath != "" {
+			complaintData[i]["attachment"] = exportAttachmentName(complaint)
+		}
+		if complaint.DeletedAt.Valid {
+			complaintData[i]["deleted_at"] = complaint.DeletedAt.Time
+		}
+	}
+	watchedIDs := make([]uint, len(watched))
+	for i, watcher := range watched {
+		watchedIDs[i] = watcher.ComplaintID
+	}
+
+	data := gin.H{
+		"exported_at": time.Now(),
+		"profile": gin.H{
+			"id":                       user.ID,
+			"username":                 user.Username,
+			"student_id":               user.StudentID,
+			"email":                    user.Email,
+			"email_verified_at":        user.EmailVerifiedAt,
+			"name":                     user.Name,
+			"role":                     user.Role,
+			"phone":                    user.Phone,
+			"auth_provider":            user.AuthProvider,
+			"totp_enabled":             user.TOTPEnabled,
+			"org_unit_id":              user.OrgUnitID,
+			"is_active":                user.IsActive,
+			"deactivated_at":           user.DeactivatedAt,
+			"notify_complaint_updates": user.NotifyComplaintUpdates,
+			"notify_new_complaints":    user.NotifyNewComplaints,
+			"notify_announcements":     user.NotifyAnnouncements,
+			"created_at":               user.CreatedAt,
+			"updated_at":               user.UpdatedAt,
+		},
+		"complaints":            complaintData,
+		"watched_complaint_ids": watchedIDs,
+		"notifications":         notifications,
+		"api_keys":              apiKeys,
+		"login_history":         loginAttempts,
+	}
+
+	filename := fmt.Sprintf("simplee-k-data-%s-%s.zip", user.Username, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(200)
+
+	archive := zip.NewWriter(c.Writer)
+	defer archive.Close()
+	w, err := archive.Create("data.json")
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return
+	}
+	for _, complaint := range complaints {
+		if complaint.EvidencePath != "" {
+			addFileToArchive(archive, complaint.EvidencePath, exportAttachmentName(complaint))
+		}
+	}
+	if user.AvatarURL != "" {
+		addFileToArchive(archive, strings.TrimPrefix(user.AvatarURL, "/"), "avatar"+filepath.Ext(user.AvatarURL))
+	}
+}
+
+func exportAttachmentName(complaint models.Complaint) string {
+	return "attachments/" + complaint.TicketID + "/" + filepath.Base(complaint.EvidencePath)
+}
+
+// addFileToArchive copies a stored upload into the archive. Files that were
+// removed from disk are skipped.
+func addFileToArchive(archive *zip.Writer, path, name string) {
+	file, err := os.Open(filepath.FromSlash(path))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	w, err := archive.Create(name)
+	if err != nil {
+		return
+	}
+	io.Copy(w, file)
+}
+
+func exportProfileData(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	exportUserData(c, user)
+}
+
+// exportUserDataAsAdmin lets an administrator answer a data request on
+// behalf of a user, also one who was deleted.
+func exportUserDataAsAdmin(c *gin.Context) {
+	var user models.User
+	if err := DB.Unscoped().First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ErasedAt != nil {
+		c.JSON(400, gin.H{"error": "User has been erased"})
+		return
+	}
+	exportUserData(c, user)
+}
+
+// redactPersonalData removes the given values, e.g. the name and student ID
+// of an erased user, from free text. Very short values are left alone so
+// unrelated words are not mangled.
+func redactPersonalData(text string, values []string) string {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) < 3 {
+			continue
+		}
+		text = regexp.MustCompile("(?i)"+regexp.QuoteMeta(value)).ReplaceAllString(text, "[redacted]")
+	}
+	return text
+}
+
+// redactNotifications redacts personal data from every notification that
+// mentions it, whoever received it.
+func redactNotifications(tx *gorm.DB, values []string) error {
+	var conditions []string
+	var args []interface{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); len(value) < 3 {
+			continue
+		}
+		conditions = append(conditions, "title LIKE ? OR message LIKE ?")
+		args = append(args, "%"+value+"%", "%"+value+"%")
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	var notifications []models.Notification
+	if err := tx.Where(strings.Join(conditions, " OR "), args...).Find(&notifications).Error; err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		if err := tx.Model(&notification).Updates(map[string]interface{}{
+			"title":   redactPersonalData(notification.Title, values),
+			"message": redactPersonalData(notification.Message, values),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eraseUser anonymizes a user on a right-to-erasure request. The account row
+// is kept with placeholder values so complaints, assignments and
+// announcements keep their history without pointing to a person. Personal
+// details are redacted from the user's complaints and from notifications
+// other users received about them, e.g. new complaints and registrations.
+// Their attachments, avatar, notifications, API keys, tokens and login
+// history are removed. This cannot be undone.
+func eraseUser(c *gin.Context) {
+	user, ok := loadManagedUser(c, DB.Unscoped())
+	if !ok {
+		return
+	}
+	var req EraseUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Confirm != user.Username {
+		c.JSON(400, gin.H{"error": "Confirm with the username of the user to erase"})
+		return
+	}
+	if user.IsActive && !user.DeletedAt.Valid && isLastRoleManager(user) {
+		c.JSON(400, gin.H{"error": "At least one active user must keep the permission to manage roles"})
+		return
+	}
+
+	placeholder := fmt.Sprintf("erased-%d", user.ID)
+	password, err := randomToken(32)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to erase user"})
+		return
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to erase user"})
+		return
+	}
+	var studentID *string
+	if user.StudentID != nil {
+		studentID = &placeholder
+	}
+	personalData := []string{user.Name, user.Email, studentIDOf(user), user.Phone, user.Username}
+
+	var evidencePaths []string
+	now := time.Now()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var complaints []models.Complaint
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Find(&complaints).Error; err != nil {
+			return err
+		}
+		for _, complaint := range complaints {
+			if complaint.EvidencePath != "" {
+				evidencePaths = append(evidencePaths, complaint.EvidencePath)
+			}
+			if err := tx.Unscoped().Model(&complaint).Updates(map[string]interface{}{
+				"title":          redactPersonalData(complaint.Title, personalData),
+				"description":    redactPersonalData(complaint.Description, personalData),
+				"admin_response": redactPersonalData(complaint.AdminResponse, personalData),
+				"evidence_path":  "",
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := revokeUserTokens(tx, user.ID); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&models.RefreshToken{}, &models.RecoveryCode{}, &models.PasswordResetToken{},
+			&models.EmailVerificationToken{}, &models.APIKey{}, &models.Notification{},
+			&models.ComplaintWatcher{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := redactNotifications(tx, personalData); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.LoginAttempt{}).Where("user_id = ? OR identifier IN ?", user.ID, []string{user.Username, user.Email}).
+			Updates(map[string]interface{}{"identifier": placeholder, "ip_address": "", "user_agent": ""}).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"username":                 placeholder,
+			"student_id":               studentID,
+			"email":                    placeholder + "@invalid",
+			"password":                 hashedPassword,
+			"name":                     erasedName,
+			"phone":                    "",
+			"must_change_password":     false,
+			"totp_secret":              "",
+			"totp_enabled":             false,
+			"auth_provider":            models.AuthProviderLocal,
+			"oidc_subject":             nil,
+			"org_unit_id":              nil,
+			"is_active":                false,
+			"avatar_url":               "",
+			"email_verified_at":        nil,
+			"notify_complaint_updates": false,
+			"notify_new_complaints":    false,
+			"notify_announcements":     false,
+			"erased_at":                now,
+		}
+		if user.DeactivatedAt == nil {
+			updates["deactivated_at"] = now
+		}
+		return tx.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to erase user"})
+		return
+	}
+
+	for _, path := range evidencePaths {
+		os.Remove(filepath.FromSlash(path))
+	}
+	removeAvatarFile(user.AvatarURL)
+	c.Set("audit_redact_before", true)
+	c.JSON(200, gin.H{"message": "User erased successfully"})
+}
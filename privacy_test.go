@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"simplee-k/models"
+)
+
+func TestEraseUserRedactsOtherUsersNotifications(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	student := createTestUser(t, "budi", models.RoleStudent)
+	DB.Model(&student).Updates(map[string]interface{}{"name": "Budi Santoso", "student_id": "2201234567"})
+
+	mentions := []models.Notification{
+		{UserID: admin.ID, Title: "New Complaint", Message: `New complaint "Broken AC" submitted by Budi Santoso (Ticket: TKT-000001)`},
+		{UserID: admin.ID, Title: "New Registration", Message: "Budi Santoso (budi@example.com, student ID 2201234567) registered and is waiting for approval."},
+	}
+	unrelated := models.Notification{UserID: admin.ID, Title: "New Complaint", Message: `New complaint "Leaking roof" submitted by Siti Aminah (Ticket: TKT-000002)`}
+	for i := range mentions {
+		DB.Create(&mentions[i])
+	}
+	DB.Create(&unrelated)
+
+	path := "/api/users/" + strconv.Itoa(int(student.ID)) + "/erase"
+	w := callAs(eraseUser, admin.ID, string(models.RoleAdmin), "POST", "/api/users/:id/erase", path, `{"confirm":"budi"}`)
+	if w.Code != 200 {
+		t.Fatalf("erase = %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, mention := range mentions {
+		var stored models.Notification
+		DB.First(&stored, mention.ID)
+		for _, value := range []string{"Budi Santoso", "budi@example.com", "2201234567"} {
+			if strings.Contains(stored.Message, value) {
+				t.Errorf("notification %d still mentions %q: %s", stored.ID, value, stored.Message)
+			}
+		}
+		if !strings.Contains(stored.Message, "[redacted]") {
+			t.Errorf("notification %d was not redacted: %s", stored.ID, stored.Message)
+		}
+	}
+	var stored models.Notification
+	DB.First(&stored, unrelated.ID)
+	if stored.Message != unrelated.Message {
+		t.Errorf("unrelated notification changed to %q", stored.Message)
+	}
+}
+
+func TestEraseAdministratorNeedsRolesManage(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "usermanager", models.PermUsersManage)
+	manager := createTestUser(t, "manager", "usermanager")
+	createTestUser(t, "keeper", models.RoleAdmin)
+	admin := createTestUser(t, "boss", models.RoleAdmin)
+
+	path := "/api/users/" + strconv.Itoa(int(admin.ID)) + "/erase"
+	if w := callAs(eraseUser, manager.ID, "usermanager", "POST", "/api/users/:id/erase", path, `{"confirm":"boss"}`); w.Code != 403 {
+		t.Fatalf("manager erasing an admin = %d, want 403: %s", w.Code, w.Body.String())
+	}
+	var stored models.User
+	DB.Unscoped().First(&stored, admin.ID)
+	if stored.ErasedAt != nil || stored.Username != "boss" {
+		t.Errorf("refused erase changed the admin to %+v", stored)
+	}
+
+	if w := callAs(eraseUser, manager.ID, string(models.RoleAdmin), "POST", "/api/users/:id/erase", path, `{"confirm":"boss"}`); w.Code != 200 {
+		t.Errorf("role manager erasing an admin = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
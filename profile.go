@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAvatarSize = 2 << 20
+	// Larger images are rejected before decoding so a small file cannot
+	// expand into a huge bitmap
+	maxAvatarDimension = 4096
+	avatarSize         = 256
+	avatarDir          = "uploads/avatars"
+)
+
+type UpdateProfileRequest struct {
+	Name                   string `json:"name" binding:"required,max=255"`
+	Phone                  string `json:"phone" binding:"max=50"`
+	Email                  string `json:"email" binding:"required,email"`
+	CurrentPassword        string `json:"current_password"`
+	NotifyComplaintUpdates *bool  `json:"notify_complaint_updates"`
+	NotifyNewComplaints    *bool  `json:"notify_new_complaints"`
+	NotifyAnnouncements    *bool  `json:"notify_announcements"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// profileResponse is the current user as returned by the profile endpoints.
+func profileResponse(c *gin.Context, user models.User) gin.H {
+	response := gin.H{
+		"id":                       user.ID,
+		"username":                 user.Username,
+		"student_id":               user.StudentID,
+		"email":                    user.Email,
+		"name":                     user.Name,
+		"role":                     user.Role,
+		"phone":                    user.Phone,
+		"avatar_url":               user.AvatarURL,
+		"auth_provider":            user.AuthProvider,
+		"must_change_password":     user.MustChangePassword,
+		"totp_enabled":             user.TOTPEnabled,
+		"permissions":              rolePermissionList(user.Role),
+		"org_unit_id":              user.OrgUnitID,
+		"notify_complaint_updates": user.NotifyComplaintUpdates,
+		"notify_new_complaints":    user.NotifyNewComplaints,
+		"notify_announcements":     user.NotifyAnnouncements,
+		"impersonation":            impersonationInfo(c),
+	}
+
+	var pending models.EmailVerificationToken
+	if err := DB.Where("user_id = ? AND used_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("created_at DESC").First(&pending).Error; err == nil {
+		response["pending_email"] = pending.Email
+	}
+	return response
+}
+
+// notificationsEnabled reports whether the user wants notifications of the
+// kind stored in the given preference column.
+func notificationsEnabled(userID uint, column string) bool {
+	var count int64
+	DB.Model(&models.User{}).Where("id = ? AND "+column+" = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+// sendEmailVerification emails a link that makes the address the user's
+// email once opened. Links sent earlier stop working.
+func sendEmailVerification(user models.User, email string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := DB.Model(&models.EmailVerificationToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", time.Now()).Error; err != nil {
+		return err
+	}
+	verification := models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     email,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.EmailVerificationHours) * time.Hour),
+	}
+	if err := DB.Create(&verification).Error; err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", strings.TrimRight(config.AppConfig.AppBaseURL, "/"), token)
+	body := fmt.Sprintf("Hello %s,\n\nOpen the link below to confirm %s as the email address of your SIMPEL-K account:\n\n%s\n\nThe link expires in %d hours. If you did not request this, you can ignore this email.\n",
+		user.Name, email, link, config.AppConfig.EmailVerificationHours)
+	return sendEmail([]string{email}, "Confirm your SIMPEL-K email address", body)
+}
+
+// updateProfile lets users edit their own name, phone number, email and
+// notification preferences. A new email only replaces the current one once
+// it is verified, and changing it needs the current password because the
+// email can be used to reset it.
+func updateProfile(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	emailChanged := !strings.EqualFold(req.Email, user.Email)
+	if user.AuthProvider != models.AuthProviderLocal && (emailChanged || req.Name != user.Name) {
+		c.JSON(400, gin.H{"error": "Name and email are managed by your campus account"})
+		return
+	}
+	if emailChanged {
+		if !checkPasswordHash(req.CurrentPassword, user.Password) {
+			c.JSON(400, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+		if msg := userConflict(user.ID, user.Username, req.Email, studentIDOf(user)); msg != "" {
+			c.JSON(400, gin.H{"error": msg})
+			return
+		}
+	}
+
+	updates := map[string]interface{}{"name": req.Name, "phone": req.Phone}
+	if req.NotifyComplaintUpdates != nil {
+		updates["notify_complaint_updates"] = *req.NotifyComplaintUpdates
+	}
+	if req.NotifyNewComplaints != nil {
+		updates["notify_new_complaints"] = *req.NotifyNewComplaints
+	}
+	if req.NotifyAnnouncements != nil {
+		updates["notify_announcements"] = *req.NotifyAnnouncements
+	}
+	if err := DB.Model(&user).Updates(updates).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	message := "Profile updated successfully"
+	if emailChanged {
+		if err := sendEmailVerification(user, req.Email); err != nil {
+			log.Printf("Error sending email verification: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+		message = "Profile updated, open the link sent to your new email address to confirm it"
+	}
+
+	DB.First(&user, user.ID)
+	response := profileResponse(c, user)
+	response["message"] = message
+	c.JSON(200, response)
+}
+
+// sendProfileEmailVerification sends a verification link for the user's
+// current email, e.g. for accounts created by an admin. A verified email lets
+// SSO sign in to a local account.
+func sendProfileEmailVerification(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(400, gin.H{"error": "Your email address is already verified"})
+		return
+	}
+	if err := sendEmailVerification(user, user.Email); err != nil {
+		log.Printf("Error sending email verification: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Open the link sent to your email address to confirm it"})
+}
+
+// verifyEmail confirms an address with a token from sendEmailVerification,
+// for a changed email or a new registration. The previous address is told
+// about a change.
+func verifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var verification models.EmailVerificationToken
+	if err := DB.Where("token_hash = ?", hashToken(req.Token)).First(&verification).Error; err != nil ||
+		verification.UsedAt != nil || time.Now().After(verification.ExpiresAt) {
+		c.JSON(400, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+	var user models.User
+	if err := DB.First(&user, verification.UserID).Error; err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+	if msg := userConflict(user.ID, user.Username, verification.Email, studentIDOf(user)); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	previousEmail := user.Email
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.EmailVerificationToken{}).
+			Where("id = ? AND used_at IS NULL", verification.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("token already used")
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"email":             verification.Email,
+			"email_verified_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return completeRegistration(tx, user)
+	})
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	if previousEmail != "" && !strings.EqualFold(previousEmail, verification.Email) {
+		body := fmt.Sprintf("Hello %s,\n\nThe email address of your SIMPEL-K account was changed to %s. If you did not make this change, contact the administrator.\n",
+			user.Name, verification.Email)
+		if err := sendEmail([]string{previousEmail}, "Your SIMPEL-K email address was changed", body); err != nil {
+			log.Printf("Error sending email change notice: %v", err)
+		}
+	}
+	c.JSON(200, gin.H{"message": "Email address verified successfully"})
+}
+
+// resizeAvatar crops the image to a centered square and scales it down to
+// avatarSize. Transparent areas become white.
+func resizeAvatar(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	size := avatarSize
+	if side < size {
+		size = side
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Average the block of source pixels that maps to this pixel
+			sx0, sx1 := x0+x*side/size, x0+(x+1)*side/size
+			sy0, sy1 := y0+y*side/size, y0+(y+1)*side/size
+			var r, g, b, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+pr, g+pg, b+pb, a+pa, n+1
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			// Colors are alpha-premultiplied, so adding the missing alpha
+			// as white composites the pixel onto a white background
+			white := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{uint16(r/n + white), uint16(g/n + white), uint16(b/n + white), 0xffff})
+		}
+	}
+	return dst
+}
+
+// removeAvatarFile deletes a stored avatar. Only files in avatarDir are
+// touched.
+func removeAvatarFile(url string) {
+	if url == "" {
+		return
+	}
+	name := filepath.Base(url)
+	if "/"+avatarDir+"/"+name == url {
+		os.Remove(filepath.Join(avatarDir, name))
+	}
+}
+
+// uploadAvatar stores a profile picture. The upload is decoded as PNG, JPEG
+// or GIF and re-encoded as PNG, so nothing but pixels from the original file
+// is ever served.
+func uploadAvatar(c *gin.Context) {
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Avatar image is required"})
+		return
+	}
+	if file.Size > maxAvatarSize {
+		c.JSON(400, gin.H{"error": "File size exceeds maximum limit (2MB)"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Failed to read image"})
+		return
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Avatar must be a PNG, JPEG or GIF image"})
+		return
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Image must be at most %dx%d pixels", maxAvatarDimension, maxAvatarDimension)})
+		return
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		c.JSON(400, gin.H{"error": "Failed to read image"})
+		return
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid " + format + " image"})
+		return
+	}
+
+	name, err := randomToken(16)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save avatar"})
+		return
+	}
+	if err := os.MkdirAll(avatarDir, 0755); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save avatar"})
+		return
+	}
+	path := filepath.Join(avatarDir, name+".png")
+	out, err := os.Create(path)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save avatar"})
+		return
+	}
+	if err := png.Encode(out, resizeAvatar(img)); err != nil {
+		out.Close()
+		os.Remove(path)
+		c.JSON(500, gin.H{"error": "Failed to save avatar"})
+		return
+	}
+	out.Close()
+
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		os.Remove(path)
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	previousURL := user.AvatarURL
+	avatarURL := "/" + filepath.ToSlash(path)
+	if err := DB.Model(&user).Update("avatar_url", avatarURL).Error; err != nil {
+		os.Remove(path)
+		c.JSON(500, gin.H{"error": "Failed to save avatar"})
+		return
+	}
+	removeAvatarFile(previousURL)
+
+	c.JSON(200, gin.H{"message": "Avatar updated successfully", "avatar_url": avatarURL})
+}
+
+func deleteAvatar(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	previousURL := user.AvatarURL
+	if err := DB.Model(&user).Update("avatar_url", "").Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to remove avatar"})
+		return
+	}
+	removeAvatarFile(previousURL)
+	c.JSON(200, gin.H{"message": "Avatar removed successfully"})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"simplee-k/models"
+)
+
+func TestSendProfileEmailVerification(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "student", models.RoleStudent)
+	path := "/api/profile/email/verify"
+
+	if w := callAs(sendProfileEmailVerification, user.ID, "student", "POST", path, path, ""); w.Code != 200 {
+		t.Fatalf("unverified email = %d: %s", w.Code, w.Body.String())
+	}
+	var tokens int64
+	DB.Model(&models.EmailVerificationToken{}).Where("user_id = ? AND email = ?", user.ID, user.Email).Count(&tokens)
+	if tokens != 1 {
+		t.Errorf("%d verification tokens, want 1", tokens)
+	}
+
+	DB.Model(&user).Update("email_verified_at", time.Now())
+	if w := callAs(sendProfileEmailVerification, user.ID, "student", "POST", path, path, ""); w.Code != 400 {
+		t.Errorf("verified email = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Role permissions are cached for a short time so a change made on another
+// instance is picked up without a restart.
+const rolePermissionsTTL = 30 * time.Second
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,49}$`)
+
+// permissionCatalog lists every permission with a description for the role
+// management UI.
+var permissionCatalog = []struct {
+	Name        models.Permission `json:"name"`
+	Description string            `json:"description"`
+}{
+	{models.PermAccountSelf, "Manage own profile, password, 2FA, API keys and notifications"},
+	{models.PermCategoriesRead, "List complaint categories"},
+	{models.PermComplaintsCreate, "Submit complaints"},
+	{models.PermComplaintsReadOwn, "View own and watched complaints"},
+	{models.PermComplaintsReadAll, "View complaints of other users (limited to the own unit without complaints.all_units)"},
+	{models.PermComplaintsAllUnits, "View and handle complaints of every unit"},
+	{models.PermComplaintsUpdate, "Respond to, assign, merge and change the status of complaints"},
+	{models.PermComplaintsDelete, "Delete complaints"},
+	{models.PermCannedResponsesManage, "Create and edit canned responses"},
+	{models.PermTriageRulesManage, "Create and edit triage rules"},
+	{models.PermReportsRead, "View reports"},
+	{models.PermAnnouncementsManage, "Manage announcements"},
+	{models.PermUsersRead, "List users"},
+	{models.PermUsersManage, "Create users and service accounts"},
+	{models.PermRolesManage, "Manage roles and assign them to users"},
+	{models.PermSecurityManage, "Review logins, unlock accounts, reset 2FA and revoke API keys"},
+	{models.PermOrgUnitsManage, "Manage organizational units and link categories and users to them"},
+	{models.PermUsersImpersonate, "View the app as another non-admin user, with every request audited"},
+	{models.PermAuditRead, "View, export and verify the audit log of all changes"},
+	{models.PermReportsSchedule, "Schedule reports to be emailed to recipients"},
+}
+
+type defaultRole struct {
+	Name        models.UserRole
+	DisplayName string
+	Description string
+	Permissions []models.Permission
+}
+
+// defaultRoles are created on startup when missing. Existing users keep their
+// role name, so "admin" users become super admins and students stay students.
+var defaultRoles = []defaultRole{
+	{models.RoleAdmin, "Super Admin", "Full access to everything", allPermissions()},
+	{models.RoleHandler, "Department Handler", "Handles and responds to complaints", []models.Permission{
+		models.PermAccountSelf, models.PermCategoriesRead, models.PermComplaintsReadAll, models.PermComplaintsUpdate,
+		models.PermReportsRead, models.PermUsersRead,
+	}},
+	{models.RoleViewer, "Viewer", "Read-only access to complaints and reports", []models.Permission{
+		models.PermAccountSelf, models.PermCategoriesRead, models.PermComplaintsReadAll, models.PermReportsRead,
+	}},
+	{models.RoleStudent, "Student", "Submits and follows own complaints", []models.Permission{
+		models.PermAccountSelf, models.PermCategoriesRead, models.PermComplaintsCreate, models.PermComplaintsReadOwn,
+	}},
+}
+
+var roleCache struct {
+	sync.RWMutex
+	permissions map[models.UserRole]map[models.Permission]bool
+	loadedAt    time.Time
+}
+
+type RoleRequest struct {
+	Name        string              `json:"name"`
+	DisplayName string              `json:"display_name" binding:"required,max=100"`
+	Description string              `json:"description" binding:"max=255"`
+	Permissions []models.Permission `json:"permissions"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func allPermissions() []models.Permission {
+	permissions := make([]models.Permission, len(permissionCatalog))
+	for i, p := range permissionCatalog {
+		permissions[i] = p.Name
+	}
+	return permissions
+}
+
+func containsPermission(permissions []models.Permission, permission models.Permission) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownPermission(permission models.Permission) bool {
+	for _, p := range permissionCatalog {
+		if p.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// seedRoles creates the default roles and keeps the super admin role in sync
+// with the permission catalog.
+func seedRoles() {
+	for _, def := range defaultRoles {
+		var role models.Role
+		err := DB.Where("name = ?", def.Name).First(&role).Error
+		if err == gorm.ErrRecordNotFound {
+			role = models.Role{Name: string(def.Name), DisplayName: def.DisplayName, Description: def.Description, IsSystem: true}
+			if err := DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&role).Error; err != nil {
+					return err
+				}
+				return setRolePermissions(tx, role.ID, def.Permissions)
+			}); err != nil {
+				log.Printf("Error seeding role %s: %v", def.Name, err)
+			}
+			continue
+		}
+		if err == nil && def.Name == models.RoleAdmin {
+			setRolePermissions(DB, role.ID, allPermissions())
+		}
+	}
+
+	invalidateRoleCache()
+
+	var unknown []string
+	DB.Model(&models.User{}).Where("role NOT IN (?)", DB.Model(&models.Role{}).Select("name")).Distinct().Pluck("role", &unknown)
+	if len(unknown) > 0 {
+		log.Printf("Warning: Users have roles that do not exist: %v", unknown)
+	}
+}
+
+func setRolePermissions(tx *gorm.DB, roleID uint, permissions []models.Permission) error {
+	if err := tx.Where("role_id = ?", roleID).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	seen := map[models.Permission]bool{}
+	for _, permission := range permissions {
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		if err := tx.Create(&models.RolePermission{RoleID: roleID, Permission: permission}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func invalidateRoleCache() {
+	roleCache.Lock()
+	roleCache.permissions = nil
+	roleCache.Unlock()
+}
+
+func loadRolePermissions() map[models.UserRole]map[models.Permission]bool {
+	roleCache.RLock()
+	if roleCache.permissions != nil && time.Since(roleCache.loadedAt) < rolePermissionsTTL {
+		defer roleCache.RUnlock()
+		return roleCache.permissions
+	}
+	roleCache.RUnlock()
+
+	var roles []models.Role
+	DB.Preload("Permissions").Find(&roles)
+	permissions := map[models.UserRole]map[models.Permission]bool{}
+	for _, role := range roles {
+		set := map[models.Permission]bool{}
+		for _, p := range role.Permissions {
+			set[p.Permission] = true
+		}
+		permissions[models.UserRole(role.Name)] = set
+	}
+
+	roleCache.Lock()
+	roleCache.permissions = permissions
+	roleCache.loadedAt = time.Now()
+	roleCache.Unlock()
+	return permissions
+}
+
+func roleExists(role models.UserRole) bool {
+	_, ok := loadRolePermissions()[role]
+	return ok
+}
+
+func roleHasPermission(role models.UserRole, permission models.Permission) bool {
+	return loadRolePermissions()[role][permission]
+}
+
+// rolePermissionList returns the sorted permissions of a role for API responses.
+func rolePermissionList(role models.UserRole) []models.Permission {
+	permissions := []models.Permission{}
+	for p := range loadRolePermissions()[role] {
+		permissions = append(permissions, p)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+	return permissions
+}
+
+// rolesWithPermission returns the names of the roles that grant a permission.
+func rolesWithPermission(permission models.Permission) []string {
+	var roles []string
+	for role, set := range loadRolePermissions() {
+		if set[permission] {
+			roles = append(roles, string(role))
+		}
+	}
+	return roles
+}
+
+// isStaffRole reports whether a role works on other people's complaints.
+func isStaffRole(role models.UserRole) bool {
+	return roleHasPermission(role, models.PermComplaintsReadAll)
+}
+
+func hasPermission(c *gin.Context, permission models.Permission) bool {
+	return roleHasPermission(models.UserRole(getUserRole(c)), permission)
+}
+
+// canAssignRole reports whether the caller may give a new account the role.
+// Any role but the default student role requires roles.manage, so users.manage
+// alone cannot create staff or administrator accounts.
+func canAssignRole(c *gin.Context, role models.UserRole) bool {
+	return role == models.RoleStudent || hasPermission(c, models.PermRolesManage)
+}
+
+// requirePermission only lets the request through when the user's role has at
+// least one of the permissions. Every protected route is wrapped in it.
+func requirePermission(permissions ...models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if hasPermission(c, permission) {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(403, gin.H{"error": "You do not have permission to perform this action"})
+		c.Abort()
+	}
+}
+
+func roleResponse(role models.Role) gin.H {
+	var userCount int64
+	DB.Model(&models.User{}).Where("role = ?", role.Name).Count(&userCount)
+	return gin.H{
+		"id":           role.ID,
+		"name":         role.Name,
+		"display_name": role.DisplayName,
+		"description":  role.Description,
+		"is_system":    role.IsSystem,
+		"permissions":  rolePermissionList(models.UserRole(role.Name)),
+		"user_count":   userCount,
+		"created_at":   role.CreatedAt,
+		"updated_at":   role.UpdatedAt,
+	}
+}
+
+func validatePermissions(c *gin.Context, permissions []models.Permission) bool {
+	for _, permission := range permissions {
+		if !isKnownPermission(permission) {
+			c.JSON(400, gin.H{"error": "Unknown permission: " + string(permission)})
+			return false
+		}
+	}
+	return true
+}
+
+// countRoleManagers counts the active users that could still manage roles if
+// the given user had the given role instead.
+func countRoleManagers(tx *gorm.DB, userID uint, role models.UserRole) int64 {
+	var count int64
+	tx.Model(&models.User{}).Where("role IN ? AND id <> ? AND is_active = ?", rolesWithPermission(models.PermRolesManage), userID, true).Count(&count)
+	if roleHasPermission(role, models.PermRolesManage) {
+		count++
+	}
+	return count
+}
+
+func getPermissions(c *gin.Context) {
+	c.JSON(200, gin.H{"data": permissionCatalog})
+}
+
+func getRoles(c *gin.Context) {
+	var roles []models.Role
+	DB.Order("is_system DESC, name").Find(&roles)
+	data := make([]gin.H, len(roles))
+	for i, role := range roles {
+		data[i] = roleResponse(role)
+	}
+	c.JSON(200, gin.H{"data": data})
+}
+
+func getRole(c *gin.Context) {
+	var role models.Role
+	if err := DB.First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Role not found"})
+		return
+	}
+	c.JSON(200, roleResponse(role))
+}
+
+func createRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(400, gin.H{"error": "Role name must be 2-50 lowercase letters, digits or underscores"})
+		return
+	}
+	if !validatePermissions(c, req.Permissions) {
+		return
+	}
+	var count int64
+	DB.Model(&models.Role{}).Where("name = ?", req.Name).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"error": "Role already exists"})
+		return
+	}
+
+	role := models.Role{Name: req.Name, DisplayName: req.DisplayName, Description: req.Description}
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+		return setRolePermissions(tx, role.ID, req.Permissions)
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create role"})
+		return
+	}
+	invalidateRoleCache()
+	c.JSON(201, roleResponse(role))
+}
+
+// updateRole changes a role's description and permissions. The name cannot
+// change because users reference it, and the super admin role always keeps
+// every permission.
+func updateRole(c *gin.Context) {
+	var role models.Role
+	if err := DB.First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Role not found"})
+		return
+	}
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !validatePermissions(c, req.Permissions) {
+		return
+	}
+	if role.Name == string(models.RoleAdmin) {
+		req.Permissions = allPermissions()
+	}
+	if roleHasPermission(models.UserRole(role.Name), models.PermRolesManage) && !containsPermission(req.Permissions, models.PermRolesManage) {
+		var others int64
+		DB.Model(&models.User{}).Where("role IN ? AND role <> ?", rolesWithPermission(models.PermRolesManage), role.Name).Count(&others)
+		if others == 0 {
+			c.JSON(400, gin.H{"error": "At least one user must keep the permission to manage roles"})
+			return
+		}
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Updates(map[string]interface{}{
+			"display_name": req.DisplayName,
+			"description":  req.Description,
+		}).Error; err != nil {
+			return err
+		}
+		return setRolePermissions(tx, role.ID, req.Permissions)
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update role"})
+		return
+	}
+	invalidateRoleCache()
+	c.JSON(200, roleResponse(role))
+}
+
+func deleteRole(c *gin.Context) {
+	var role models.Role
+	if err := DB.First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Role not found"})
+		return
+	}
+	if role.IsSystem {
+		c.JSON(400, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+	var userCount int64
+	DB.Model(&models.User{}).Where("role = ?", role.Name).Count(&userCount)
+	if userCount > 0 {
+		c.JSON(400, gin.H{"error": "Role is still assigned to users"})
+		return
+	}
+	if err := DB.Select("Permissions").Delete(&role).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	invalidateRoleCache()
+	c.JSON(200, gin.H{"message": "Role deleted successfully"})
+}
+
+// assignUserRole changes a user's role. The user's tokens carry the old role,
+// so they are revoked.
+func assignUserRole(c *gin.Context) {
+	var req AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	role := models.UserRole(req.Role)
+	if !roleExists(role) {
+		c.JSON(400, gin.H{"error": "Role does not exist"})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == role {
+		c.JSON(200, gin.H{"message": "Role updated successfully", "role": role})
+		return
+	}
+	if countRoleManagers(DB, user.ID, role) == 0 {
+		c.JSON(400, gin.H{"error": "At least one user must keep the permission to manage roles"})
+		return
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return err
+		}
+		return revokeUserTokens(tx, user.ID)
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update role"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Role updated successfully", "role": role})
+}
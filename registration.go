@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RegisterRequest struct {
+	Username  string `json:"username" binding:"required,max=50"`
+	StudentID string `json:"student_id" binding:"required,max=50"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required"`
+	Name      string `json:"name" binding:"required,max=255"`
+	Phone     string `json:"phone" binding:"max=50"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type RejectRegistrationRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// emailDomains parses a comma separated list of email domains, with or
+// without a leading @.
+func emailDomains(list string) []string {
+	var domains []string
+	for _, domain := range strings.Split(list, ",") {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// emailInDomains reports whether the email belongs to one of the domains. An
+// empty list allows every domain.
+func emailInDomains(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	emailDomain := strings.ToLower(email[at+1:])
+	for _, domain := range domains {
+		if emailDomain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// registrationDomains returns the allowed email domains. An empty list allows
+// every domain.
+func registrationDomains() []string {
+	return emailDomains(config.AppConfig.RegistrationEmailDomains)
+}
+
+func registrationEmailAllowed(email string) bool {
+	return emailInDomains(email, registrationDomains())
+}
+
+// registrationError returns why a self-registered user may not log in yet,
+// or an empty code.
+func registrationError(user models.User) (string, string) {
+	switch user.RegistrationStatus {
+	case models.RegistrationUnverified:
+		return "email_not_verified", "Please verify your email address first"
+	case models.RegistrationPending:
+		return "registration_pending", "Your registration is waiting for approval"
+	case models.RegistrationRejected:
+		return "registration_rejected", "Your registration was rejected"
+	}
+	return "", ""
+}
+
+// purgeUnverifiedRegistrations removes registrations that never verified
+// their email and hold one of the given values, so nobody can block an
+// address or student ID by registering it without owning it. Registrations
+// whose link is still valid are kept unless all is set.
+func purgeUnverifiedRegistrations(tx *gorm.DB, all bool, username, email, studentID string) error {
+	var conditions []string
+	var values []interface{}
+	for i, column := range []string{"username", "email", "student_id"} {
+		if value := []string{username, email, studentID}[i]; value != "" {
+			conditions = append(conditions, column+" = ?")
+			values = append(values, value)
+		}
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	query := tx.Unscoped().Where("registration_status = ?", models.RegistrationUnverified).
+		Where(strings.Join(conditions, " OR "), values...)
+	if !all {
+		query = query.Where("created_at < ?", time.Now().Add(-time.Duration(config.AppConfig.EmailVerificationHours)*time.Hour))
+	}
+	return query.Delete(&models.User{}).Error
+}
+
+// register lets students create their own account when registration is
+// enabled. The account can log in once its email is verified and, if
+// required, an administrator approved it.
+func register(c *gin.Context) {
+	if !config.AppConfig.RegistrationEnabled {
+		c.JSON(404, gin.H{"error": "Registration is disabled"})
+		return
+	}
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !registrationEmailAllowed(req.Email) {
+		c.JSON(400, gin.H{"error": "Registration is only open to email addresses of: " + strings.Join(registrationDomains(), ", ")})
+		return
+	}
+	if err := validatePassword(req.Password, models.User{Username: req.Username}); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := purgeUnverifiedRegistrations(DB, false, req.Username, req.Email, req.StudentID); err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	switch msg := userConflict(0, req.Username, req.Email, req.StudentID); msg {
+	case "":
+	case "Username already exists":
+		c.JSON(400, gin.H{"error": msg})
+		return
+	default:
+		// Answering like a new registration keeps registered emails and
+		// student IDs secret; the owner of the email is told instead
+		sendRegistrationConflictEmail(req, msg == "Email already exists")
+		c.JSON(201, gin.H{"message": registrationReceivedMessage})
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	user := models.User{
+		Username:           req.Username,
+		StudentID:          studentIDColumn(req.StudentID),
+		Email:              req.Email,
+		Password:           hashedPassword,
+		Name:               req.Name,
+		Role:               models.RoleStudent,
+		Phone:              req.Phone,
+		RegistrationStatus: models.RegistrationUnverified,
+	}
+	if err := DB.Create(&user).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create account"})
+		return
+	}
+
+	if err := sendEmailVerification(user, user.Email); err != nil {
+		log.Printf("Error sending registration verification email: %v", err)
+	}
+	c.JSON(201, gin.H{"message": registrationReceivedMessage})
+}
+
+const registrationReceivedMessage = "Registration received, open the link sent to your email address to verify it"
+
+// sendRegistrationConflictEmail tells the owner of the email why no account
+// was created: the email, or otherwise the student ID, already has one.
+func sendRegistrationConflictEmail(req RegisterRequest, emailTaken bool) {
+	loginURL := strings.TrimRight(config.AppConfig.AppBaseURL, "/") + "/login"
+	subject := "Your SIMPEL-K registration"
+	body := fmt.Sprintf("Hello,\n\nSomeone tried to register a new SIMPEL-K account with this email address, which already has an account. Sign in at %s, or use \"Forgot password\" there if you do not remember your password. If this was not you, you can ignore this email.\n", loginURL)
+	if !emailTaken {
+		body = fmt.Sprintf("Hello %s,\n\nYour SIMPEL-K registration could not be completed because the student ID is already used by another account. If it is yours, sign in at %s, or use \"Forgot password\" there. Otherwise contact the administrator.\n", req.Name, loginURL)
+	}
+	if err := sendEmail([]string{req.Email}, subject, body); err != nil {
+		log.Printf("Error sending registration conflict email: %v", err)
+	}
+}
+
+// resendVerification sends a new verification link to an unverified
+// registration. The response does not reveal whether the email is registered.
+func resendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := DB.Where("email = ? AND registration_status = ?", req.Email, models.RegistrationUnverified).First(&user).Error; err == nil {
+		if err := sendEmailVerification(user, user.Email); err != nil {
+			log.Printf("Error sending registration verification email: %v", err)
+		}
+	}
+	c.JSON(200, gin.H{"message": "If the email has an unverified registration, a new verification link has been sent"})
+}
+
+// completeRegistration moves a registration on once its email is verified:
+// to the approval queue, or straight to approved.
+func completeRegistration(tx *gorm.DB, user models.User) error {
+	if user.RegistrationStatus != models.RegistrationUnverified {
+		return nil
+	}
+	if !config.AppConfig.RegistrationRequireApproval {
+		return tx.Model(&user).Update("registration_status", models.RegistrationApproved).Error
+	}
+	if err := tx.Model(&user).Update("registration_status", models.RegistrationPending).Error; err != nil {
+		return err
+	}
+
+	var admins []models.User
+	tx.Where("role IN ? AND is_active = ?", rolesWithPermission(models.PermUsersManage), true).Find(&admins)
+	relatedID := user.ID
+	for _, admin := range admins {
+		tx.Create(&models.Notification{
+			UserID:    admin.ID,
+			Title:     "New Registration",
+			Message:   fmt.Sprintf("%s (%s, student ID %s) registered and is waiting for approval.", user.Name, user.Email, studentIDOf(user)),
+			Type:      models.NotificationSystem,
+			RelatedID: &relatedID,
+		})
+	}
+	return nil
+}
+
+func getRegistrations(c *gin.Context) {
+	status := c.DefaultQuery("status", string(models.RegistrationPending))
+	var users []models.User
+	DB.Where("registration_status = ?", status).Order("created_at ASC").Find(&users)
+
+	registrations := make([]gin.H, len(users))
+	for i, user := range users {
+		registrations[i] = userSummary(user)
+		registrations[i]["registration_status"] = user.RegistrationStatus
+		registrations[i]["email_verified_at"] = user.EmailVerifiedAt
+	}
+	c.JSON(200, gin.H{"data": registrations})
+}
+
+func loadPendingRegistration(c *gin.Context) (models.User, bool) {
+	var user models.User
+	if err := DB.Where("registration_status = ?", models.RegistrationPending).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Pending registration not found"})
+		return user, false
+	}
+	return user, true
+}
+
+func approveRegistration(c *gin.Context) {
+	user, ok := loadPendingRegistration(c)
+	if !ok {
+		return
+	}
+	if err := DB.Model(&user).Update("registration_status", models.RegistrationApproved).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to approve registration"})
+		return
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nYour SIMPEL-K registration has been approved. You can now log in at %s with the username %s.\n",
+		user.Name, strings.TrimRight(config.AppConfig.AppBaseURL, "/")+"/login", user.Username)
+	if err := sendEmail([]string{user.Email}, "Your SIMPEL-K registration was approved", body); err != nil {
+		log.Printf("Error sending registration approval email: %v", err)
+	}
+	c.JSON(200, gin.H{"message": "Registration approved successfully"})
+}
+
+// rejectRegistration keeps the account as rejected so the same email and
+// student ID cannot simply register again. Deleting the user does not change
+// that, since deleted users keep their values; erasing the user frees them.
+// The body with the reason is optional.
+func rejectRegistration(c *gin.Context) {
+	user, ok := loadPendingRegistration(c)
+	if !ok {
+		return
+	}
+	var req RejectRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := DB.Model(&user).Update("registration_status", models.RegistrationRejected).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reject registration"})
+		return
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nYour SIMPEL-K registration has been rejected.\n", user.Name)
+	if req.Reason != "" {
+		body += "\nReason: " + req.Reason + "\n"
+	}
+	if err := sendEmail([]string{user.Email}, "Your SIMPEL-K registration was rejected", body); err != nil {
+		log.Printf("Error sending registration rejection email: %v", err)
+	}
+	c.JSON(200, gin.H{"message": "Registration rejected"})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"simplee-k/config"
+	"simplee-k/models"
+)
+
+func TestRejectRegistrationBody(t *testing.T) {
+	setupTestDB(t)
+
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{"without body", "", 200},
+		{"with reason", `{"reason":"Not a student here"}`, 200},
+		{"malformed body", `{"reason":`, 400},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, "applicant"+strconv.Itoa(i), models.RoleStudent)
+			DB.Model(&user).Update("registration_status", models.RegistrationPending)
+
+			path := "/api/registrations/" + strconv.Itoa(int(user.ID)) + "/reject"
+			w := callAs(rejectRegistration, 0, string(models.RoleAdmin), "POST", "/api/registrations/:id/reject", path, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+
+			var stored models.User
+			DB.First(&stored, user.ID)
+			wantStatus := models.RegistrationRejected
+			if tt.want != 200 {
+				wantStatus = models.RegistrationPending
+			}
+			if stored.RegistrationStatus != wantStatus {
+				t.Errorf("registration status = %s, want %s", stored.RegistrationStatus, wantStatus)
+			}
+		})
+	}
+}
+
+func TestRegisterDoesNotRevealExistingAccounts(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.RegistrationEnabled = true
+	sink := startSMTPSink(t)
+	existing := createTestUser(t, "existing", models.RoleStudent)
+
+	submit := func(username, studentID, email string) int {
+		body := `{"username":"` + username + `","student_id":"` + studentID + `","email":"` + email + `","password":"Str0ng-passw0rd","name":"New Student"}`
+		w := callAs(register, 0, "", "POST", "/api/register", "/api/register", body)
+		return w.Code
+	}
+
+	tests := []struct {
+		name, username, studentID, email string
+		want                             int
+		wantMail                         string
+	}{
+		{"taken email", "new1", "S-new1", existing.Email, 201, "already has an account"},
+		{"taken student ID", "new2", studentIDOf(existing), "new2@example.com", 201, "student ID is already used"},
+		{"taken username", existing.Username, "S-new3", "new3@example.com", 400, ""},
+		{"new account", "new4", "S-new4", "new4@example.com", 201, "verify-email?token="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(sink.received())
+			if got := submit(tt.username, tt.studentID, tt.email); got != tt.want {
+				t.Fatalf("status = %d, want %d", got, tt.want)
+			}
+			messages := sink.received()[before:]
+			if tt.wantMail == "" {
+				if len(messages) != 0 {
+					t.Errorf("sent %d emails, want none", len(messages))
+				}
+				return
+			}
+			if len(messages) != 1 || messages[0].to[0] != tt.email || !strings.Contains(messages[0].data, tt.wantMail) {
+				t.Errorf("emails = %+v, want one to %s mentioning %q", messages, tt.email, tt.wantMail)
+			}
+		})
+	}
+
+	var count int64
+	DB.Model(&models.User{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d users, want the existing one and the new registration", count)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is how many complaints are loaded at a time while an
+// export is written, so memory use does not grow with the export.
+const exportBatchSize = 500
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+var complaintExportColumns = []string{
+	"Ticket ID", "Title", "Description", "Category", "Status", "Priority", "Tags",
+	"Reporter", "Student ID", "Assigned To", "Admin Response", "Created At", "Updated At",
+}
+
+// exportedComplaints returns the complaints the user may see with the same
+// filters as the complaint list.
+func exportedComplaints(c *gin.Context) *gorm.DB {
+	return DB.Model(&models.Complaint{}).Preload("User").Preload("Category").Preload("AssignedTo").
+		Scopes(visibleComplaints(c), complaintListFilters(c))
+}
+
+func complaintExportRow(complaint models.Complaint) []string {
+	assignedTo := ""
+	if complaint.AssignedTo != nil {
+		assignedTo = complaint.AssignedTo.Name
+	}
+	return []string{
+		complaint.TicketID, complaint.Title, complaint.Description, complaint.Category.Name,
+		string(complaint.Status), string(complaint.Priority), complaint.Tags,
+		complaint.User.Name, studentIDOf(complaint.User), assignedTo, complaint.AdminResponse,
+		complaint.CreatedAt.Format("2006-01-02 15:04"), complaint.UpdatedAt.Format("2006-01-02 15:04"),
+	}
+}
+
+// eachComplaint calls fn for every complaint of the query, oldest first,
+// loading them in batches.
+func eachComplaint(query *gorm.DB, fn func(models.Complaint) error) error {
+	var complaints []models.Complaint
+	return query.FindInBatches(&complaints, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, complaint := range complaints {
+			if err := fn(complaint); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// csvCells keeps spreadsheet programs from running text as a formula: cells
+// starting with =, +, -, @, tab or carriage return get a leading quote.
+func csvCells(values []string) []string {
+	cells := make([]string, len(values))
+	for i, value := range values {
+		if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			value = "'" + value
+		}
+		cells[i] = value
+	}
+	return cells
+}
+
+func writeComplaintsCSV(w io.Writer, query *gorm.DB) error {
+	writer := csv.NewWriter(w)
+	writer.Write(complaintExportColumns)
+	if err := eachComplaint(query, func(complaint models.Complaint) error {
+		writer.Write(csvCells(complaintExportRow(complaint)))
+		// Send every row on so the response is streamed
+		writer.Flush()
+		return writer.Error()
+	}); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeComplaintsXLSX uses the excelize stream writer, which keeps large
+// sheets in a temporary file instead of memory.
+func writeComplaintsXLSX(w io.Writer, query *gorm.DB) error {
+	file := excelize.NewFile()
+	defer file.Close()
+	sheet := "Complaints"
+	file.SetSheetName("Sheet1", sheet)
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	if err := stream.SetRow("A1", stringCells(complaintExportColumns)); err != nil {
+		return err
+	}
+	row := 2
+	if err := eachComplaint(query, func(complaint models.Complaint) error {
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		row++
+		return stream.SetRow(cell, stringCells(complaintExportRow(complaint)))
+	}); err != nil {
+		return err
+	}
+	if err := stream.Flush(); err != nil {
+		return err
+	}
+	return file.Write(w)
+}
+
+func stringCells(values []string) []interface{} {
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		cells[i] = value
+	}
+	return cells
+}
+
+// exportComplaints downloads the complaint list as CSV or XLSX
+// (?format=csv|xlsx), filtered like GET /api/complaints.
+func exportComplaints(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	filename := fmt.Sprintf("complaints-%s.%s", time.Now().Format("20060102"), format)
+	switch format {
+	case "csv":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(200)
+		writeComplaintsCSV(c.Writer, exportedComplaints(c))
+	case "xlsx":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Header("Content-Type", xlsxContentType)
+		c.Status(200)
+		writeComplaintsXLSX(c.Writer, exportedComplaints(c))
+	default:
+		c.JSON(400, gin.H{"error": "Invalid format, use csv or xlsx"})
+	}
+}
+
+type categoryCount struct {
+	Name  string
+	Count int64
+}
+
+type dailyCount struct {
+	Date  string
+	Count int64
+}
+
+// summaryCategoryCounts counts the complaints submitted in the period per
+// category, largest first.
+func summaryCategoryCounts(c *gin.Context, start, end time.Time) []categoryCount {
+	var counts []categoryCount
+	DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).
+		Select("categories.name AS name, COUNT(*) AS count").
+		Joins("LEFT JOIN categories ON complaints.category_id = categories.id").
+		Where("complaints.created_at >= ? AND complaints.created_at < ?", start, end).
+		Group("categories.name").
+		Order("count DESC").
+		Scan(&counts)
+	return counts
+}
+
+// summaryDailyCounts returns the complaints submitted and completed on each
+// day of the period, by date. Completions come from the status events.
+func summaryDailyCounts(c *gin.Context, start, end time.Time) ([]dailyCount, []dailyCount, error) {
+	var submitted, resolved []dailyCount
+	if err := DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).
+		Select("DATE(complaints.created_at) AS date, COUNT(*) AS count").
+		Where("complaints.created_at >= ? AND complaints.created_at < ?", start, end).
+		Group("DATE(complaints.created_at)").
+		Order("date ASC").
+		Scan(&submitted).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := DB.Model(&models.Complaint{}).Scopes(visibleComplaints(c)).
+		Select("DATE(complaint_events.created_at) AS date, COUNT(*) AS count").
+		Joins("JOIN complaint_events ON complaint_events.complaint_id = complaints.id").
+		Where("complaint_events.type = ? AND complaint_events.to_status = ?", models.ComplaintEventStatus, models.StatusCompleted).
+		Where("complaint_events.created_at >= ? AND complaint_events.created_at < ?", start, end).
+		Group("DATE(complaint_events.created_at)").
+		Order("date ASC").
+		Scan(&resolved).Error; err != nil {
+		return nil, nil, err
+	}
+	return submitted, resolved, nil
+}
+
+func formatChange(change interface{}) string {
+	value, ok := change.(float64)
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", value)
+}
+
+// pdfTable draws a table with a shaded header row. The text is translated
+// to the code page of the core fonts.
+func pdfTable(pdf *fpdf.Fpdf, tr func(string) string, headers []string, widths []float64, rows [][]string) {
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(226, 232, 240)
+	for i, header := range headers {
+		pdf.CellFormat(widths[i], 7, tr(header), "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 9)
+	for _, row := range rows {
+		for i, value := range row {
+			align := "L"
+			if i > 0 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[i], 6, tr(value), "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(4)
+}
+
+func pdfHeading(pdf *fpdf.Fpdf, text string) {
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.CellFormat(0, 8, text, "", 1, "L", false, 0, "")
+}
+
+// pdfCategoryChart draws a horizontal bar per category.
+func pdfCategoryChart(pdf *fpdf.Fpdf, tr func(string) string, counts []categoryCount) {
+	var max int64 = 1
+	for _, count := range counts {
+		if count.Count > max {
+			max = count.Count
+		}
+	}
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	labelWidth := 45.0
+	barWidth := pageWidth - left - right - labelWidth - 15
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetFillColor(37, 99, 235)
+	for _, count := range counts {
+		y := pdf.GetY()
+		pdf.CellFormat(labelWidth, 6, tr(count.Name), "", 0, "L", false, 0, "")
+		width := barWidth * float64(count.Count) / float64(max)
+		pdf.Rect(left+labelWidth, y+1, width, 4, "F")
+		pdf.SetXY(left+labelWidth+width+2, y)
+		pdf.CellFormat(15, 6, strconv.FormatInt(count.Count, 10), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+}
+
+// pdfDailyChart draws submitted and resolved complaints per day as pairs of
+// vertical bars.
+func pdfDailyChart(pdf *fpdf.Fpdf, start, end time.Time, submitted, resolved []dailyCount) {
+	submittedByDate := map[string]int64{}
+	resolvedByDate := map[string]int64{}
+	var max int64 = 1
+	for _, day := range submitted {
+		submittedByDate[day.Date[:10]] = day.Count
+		if day.Count > max {
+			max = day.Count
+		}
+	}
+	for _, day := range resolved {
+		resolvedByDate[day.Date[:10]] = day.Count
+		if day.Count > max {
+			max = day.Count
+		}
+	}
+
+	var days []string
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	chartWidth := pageWidth - left - right
+	chartHeight := 50.0
+	if pdf.GetY()+chartHeight+20 > 277 {
+		pdf.AddPage()
+	}
+	top := pdf.GetY()
+	bottom := top + chartHeight
+	slot := chartWidth / float64(len(days))
+
+	pdf.SetDrawColor(148, 163, 184)
+	pdf.Line(left, bottom, left+chartWidth, bottom)
+	for i, day := range days {
+		x := left + float64(i)*slot
+		height := chartHeight * float64(submittedByDate[day]) / float64(max)
+		pdf.SetFillColor(37, 99, 235)
+		pdf.Rect(x, bottom-height, slot/2, height, "F")
+		height = chartHeight * float64(resolvedByDate[day]) / float64(max)
+		pdf.SetFillColor(22, 163, 74)
+		pdf.Rect(x+slot/2, bottom-height, slot/2, height, "F")
+	}
+
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.SetXY(left, bottom+1)
+	pdf.CellFormat(chartWidth/2, 5, days[0], "", 0, "L", false, 0, "")
+	pdf.CellFormat(chartWidth/2, 5, days[len(days)-1], "", 1, "R", false, 0, "")
+	pdf.SetFillColor(37, 99, 235)
+	pdf.Rect(left, pdf.GetY()+1.5, 3, 3, "F")
+	pdf.SetX(left + 4)
+	pdf.CellFormat(25, 6, "Submitted", "", 0, "L", false, 0, "")
+	pdf.SetFillColor(22, 163, 74)
+	pdf.Rect(left+30, pdf.GetY()+1.5, 3, 3, "F")
+	pdf.SetX(left + 34)
+	pdf.CellFormat(25, 6, fmt.Sprintf("Resolved (max %d per day)", max), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+}
+
+// writeSummaryPDF writes the summary report for complaints submitted from
+// start up to end: the stats compared with the previous period, charts per
+// category and per day, and resolution times per category.
+func writeSummaryPDF(c *gin.Context, w io.Writer, start, end time.Time) error {
+	previousStart := previousPeriodStart(start, end)
+	current, err := reportPeriodStats(c, start, end)
+	if err != nil {
+		return err
+	}
+	previous, err := reportPeriodStats(c, previousStart, start)
+	if err != nil {
+		return err
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle("SIMPEL-K Complaint Report", true)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(0, 6, fmt.Sprintf("Generated %s - page %d", time.Now().Format("2006-01-02 15:04"), pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, "SIMPEL-K Complaint Report", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, fmt.Sprintf("Period: %s to %s, compared with %s to %s",
+		start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02"),
+		previousStart.Format("2006-01-02"), start.AddDate(0, 0, -1).Format("2006-01-02")), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdfHeading(pdf, "Summary")
+	pdfTable(pdf, tr, []string{"Metric", "This period", "Previous period", "Change"}, []float64{70, 35, 35, 30}, [][]string{
+		{"Complaints submitted", strconv.FormatInt(current.Total, 10), strconv.FormatInt(previous.Total, 10),
+			formatChange(percentChange(float64(current.Total), float64(previous.Total)))},
+		{"Pending", strconv.FormatInt(current.Pending, 10), strconv.FormatInt(previous.Pending, 10),
+			formatChange(percentChange(float64(current.Pending), float64(previous.Pending)))},
+		{"Resolved", strconv.FormatInt(current.Resolved, 10), strconv.FormatInt(previous.Resolved, 10),
+			formatChange(percentChange(float64(current.Resolved), float64(previous.Resolved)))},
+		{"Average resolution (days)", fmt.Sprintf("%.1f", current.AvgResolutionHours/24), fmt.Sprintf("%.1f", previous.AvgResolutionHours/24),
+			formatChange(percentChange(current.AvgResolutionHours, previous.AvgResolutionHours))},
+	})
+
+	pdfHeading(pdf, "Complaints per category")
+	pdfCategoryChart(pdf, tr, summaryCategoryCounts(c, start, end))
+
+	pdfHeading(pdf, "Complaints per day")
+	submitted, resolved, err := summaryDailyCounts(c, start, end)
+	if err != nil {
+		return err
+	}
+	pdfDailyChart(pdf, start, end, submitted, resolved)
+
+	pdfHeading(pdf, "Resolution times (hours)")
+	var categories []models.Category
+	if err := DB.Find(&categories).Error; err != nil {
+		return err
+	}
+	categoryNames := map[uint]string{}
+	for _, category := range categories {
+		categoryNames[category.ID] = category.Name
+	}
+	categoryResponse, err := complaintDurationStats(c, start, end, false, "complaints.category_id")
+	if err != nil {
+		return err
+	}
+	firstResponse := map[uint]durationStats{}
+	for _, stats := range categoryResponse {
+		firstResponse[*stats.GroupID] = stats
+	}
+	durationRow := func(name string, resolution, response durationStats) []string {
+		return []string{name, strconv.FormatInt(resolution.Count, 10), fmt.Sprintf("%.1f", hours(resolution.Mean)),
+			fmt.Sprintf("%.1f", hours(resolution.Median)), fmt.Sprintf("%.1f", hours(resolution.P90)),
+			fmt.Sprintf("%.1f", hours(response.Median))}
+	}
+	overallResolution, err := complaintDurationStats(c, start, end, true, "0")
+	if err != nil {
+		return err
+	}
+	overallResponse, err := complaintDurationStats(c, start, end, false, "0")
+	if err != nil {
+		return err
+	}
+	categoryResolution, err := complaintDurationStats(c, start, end, true, "complaints.category_id")
+	if err != nil {
+		return err
+	}
+	rows := [][]string{durationRow("All categories", overallDuration(overallResolution), overallDuration(overallResponse))}
+	for _, stats := range categoryResolution {
+		rows = append(rows, durationRow(categoryNames[*stats.GroupID], stats, firstResponse[*stats.GroupID]))
+	}
+	pdfTable(pdf, tr, []string{"Category", "Resolved", "Mean", "Median", "P90", "First response (median)"},
+		[]float64{50, 20, 20, 20, 20, 40}, rows)
+
+	return pdf.Output(w)
+}
+
+// exportReportSummary downloads the PDF summary report for start_date to
+// end_date (default the last 30 days).
+func exportReportSummary(c *gin.Context) {
+	start, end, ok := reportDateRange(c)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeSummaryPDF(c, &buf, start, end); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate report"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="complaint-report-%s.pdf"`, start.Format("20060102")))
+	c.Data(200, "application/pdf", buf.Bytes())
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"simplee-k/models"
+)
+
+func TestCSVCells(t *testing.T) {
+	got := csvCells([]string{"=1+1", "+62 812", "-5", "@SUM(A1)", "\tcmd", "\rcmd", "plain", "", "a=b"})
+	want := []string{"'=1+1", "'+62 812", "'-5", "'@SUM(A1)", "'\tcmd", "'\rcmd", "plain", "", "a=b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteComplaintsCSVEscapesFormulas(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "reporter", models.RoleStudent)
+	DB.Model(&user).Update("name", "=cmd|' /C calc'!A0")
+	category := models.Category{Name: "Fasilitas", Slug: "fasilitas"}
+	DB.Create(&category)
+	DB.Create(&models.Complaint{
+		TicketID:    "FAS-0001",
+		UserID:      user.ID,
+		CategoryID:  category.ID,
+		Title:       `=HYPERLINK("http://evil.example","klik")`,
+		Description: "@SUM(1+1)",
+	})
+
+	var buf bytes.Buffer
+	query := DB.Model(&models.Complaint{}).Preload("User").Preload("Category").Preload("AssignedTo")
+	if err := writeComplaintsCSV(&buf, query); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("rows = %v, err = %v", rows, err)
+	}
+	row := rows[1]
+	if row[1] != `'=HYPERLINK("http://evil.example","klik")` || row[2] != "'@SUM(1+1)" || row[7] != "'=cmd|' /C calc'!A0" {
+		t.Errorf("row = %q", row)
+	}
+	if row[0] != "FAS-0001" || row[3] != "Fasilitas" {
+		t.Errorf("plain cells changed: %q", row)
+	}
+}
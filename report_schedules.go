@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	reportSchedulerInterval = time.Minute
+	maxReportRecipients     = 50
+)
+
+type ReportSubscriptionRequest struct {
+	Name       string `json:"name" binding:"required,max=100"`
+	ReportType string `json:"report_type" binding:"required,oneof=summary_pdf complaints_csv complaints_xlsx"`
+	Status     string `json:"status" binding:"omitempty,oneof=pending in_process completed rejected"`
+	Search     string `json:"search" binding:"max=255"`
+	Cadence    string `json:"cadence" binding:"required,oneof=daily weekly monthly"`
+	// Recipients is a comma separated list of email addresses
+	Recipients string `json:"recipients" binding:"required"`
+	Enabled    *bool  `json:"enabled"`
+}
+
+// toSubscription validates the request and copies it onto a subscription.
+func (req ReportSubscriptionRequest) toSubscription(sub *models.ReportSubscription) error {
+	reportType := models.ReportType(req.ReportType)
+	if reportType == models.ReportSummaryPDF && (req.Status != "" || req.Search != "") {
+		return errors.New("Filters only apply to complaint exports")
+	}
+	recipients, err := normalizeRecipients(req.Recipients)
+	if err != nil {
+		return err
+	}
+	sub.Name = strings.TrimSpace(req.Name)
+	sub.ReportType = reportType
+	sub.Status = models.ComplaintStatus(req.Status)
+	sub.Search = strings.TrimSpace(req.Search)
+	sub.Cadence = models.ReportCadence(req.Cadence)
+	sub.Recipients = recipients
+	sub.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+// normalizeRecipients checks a comma separated list of email addresses and
+// returns it lowercased and without duplicates. Reports contain complaint
+// data, so with REPORT_RECIPIENT_DOMAINS set they only go to those domains.
+func normalizeRecipients(recipients string) (string, error) {
+	domains := emailDomains(config.AppConfig.ReportRecipientDomains)
+	seen := map[string]bool{}
+	var result []string
+	for _, recipient := range strings.Split(recipients, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == "" {
+			continue
+		}
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return "", fmt.Errorf("Invalid recipient email address: %s", recipient)
+		}
+		email := strings.ToLower(address.Address)
+		if !emailInDomains(email, domains) {
+			return "", fmt.Errorf("Reports can only be sent to email addresses of: %s", strings.Join(domains, ", "))
+		}
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+		result = append(result, email)
+	}
+	if len(result) == 0 {
+		return "", errors.New("At least one recipient is required")
+	}
+	if len(result) > maxReportRecipients {
+		return "", fmt.Errorf("At most %d recipients are allowed", maxReportRecipients)
+	}
+	return strings.Join(result, ","), nil
+}
+
+// nextReportRun returns the first scheduled time after the given time: every
+// day, every Monday or the first of every month at REPORT_SCHEDULE_HOUR.
+func nextReportRun(cadence models.ReportCadence, after time.Time) time.Time {
+	after = after.In(time.Local)
+	hour := config.AppConfig.ReportScheduleHour
+	next := time.Date(after.Year(), after.Month(), after.Day(), hour, 0, 0, 0, time.Local)
+	switch cadence {
+	case models.CadenceWeekly:
+		next = next.AddDate(0, 0, (int(time.Monday)-int(next.Weekday())+7)%7)
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case models.CadenceMonthly:
+		next = time.Date(after.Year(), after.Month(), 1, hour, 0, 0, 0, time.Local)
+		if !next.After(after) {
+			next = next.AddDate(0, 1, 0)
+		}
+	default:
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// subscriptionPeriod returns the last complete period before a run: the
+// previous day, the previous seven days or the previous calendar month. The
+// end is exclusive.
+func subscriptionPeriod(cadence models.ReportCadence, runAt time.Time) (time.Time, time.Time) {
+	runAt = runAt.In(time.Local)
+	end := time.Date(runAt.Year(), runAt.Month(), runAt.Day(), 0, 0, 0, 0, time.Local)
+	switch cadence {
+	case models.CadenceWeekly:
+		return end.AddDate(0, 0, -7), end
+	case models.CadenceMonthly:
+		end = time.Date(runAt.Year(), runAt.Month(), 1, 0, 0, 0, 0, time.Local)
+		return end.AddDate(0, -1, 0), end
+	default:
+		return end.AddDate(0, 0, -1), end
+	}
+}
+
+// ownerContext builds the context the report generators expect, with the
+// owner as the current user and the subscription's filters as the query, so
+// the report shows exactly what the owner would see in the app.
+func ownerContext(sub models.ReportSubscription, owner models.User) *gin.Context {
+	query := url.Values{}
+	if sub.Status != "" {
+		query.Set("status", string(sub.Status))
+	}
+	if sub.Search != "" {
+		query.Set("search", sub.Search)
+	}
+	c := &gin.Context{Request: &http.Request{Method: "GET", URL: &url.URL{RawQuery: query.Encode()}}}
+	c.Set("user_id", owner.ID)
+	c.Set("user_role", string(owner.Role))
+	return c
+}
+
+// generateReport renders a subscription's report for the period. Complaint
+// exports contain the complaints submitted in the period.
+func generateReport(sub models.ReportSubscription, owner models.User, start, end time.Time) (emailAttachment, error) {
+	c := ownerContext(sub, owner)
+	period := start.Format("20060102") + "-" + end.AddDate(0, 0, -1).Format("20060102")
+	var buf bytes.Buffer
+	var attachment emailAttachment
+	var err error
+	switch sub.ReportType {
+	case models.ReportSummaryPDF:
+		attachment = emailAttachment{Filename: "complaint-report-" + period + ".pdf", ContentType: "application/pdf"}
+		err = writeSummaryPDF(c, &buf, start, end)
+	case models.ReportComplaintsCSV:
+		attachment = emailAttachment{Filename: "complaints-" + period + ".csv", ContentType: "text/csv; charset=utf-8"}
+		err = writeComplaintsCSV(&buf, exportedComplaints(c).Where("complaints.created_at >= ? AND complaints.created_at < ?", start, end))
+	case models.ReportComplaintsXLSX:
+		attachment = emailAttachment{Filename: "complaints-" + period + ".xlsx", ContentType: xlsxContentType}
+		err = writeComplaintsXLSX(&buf, exportedComplaints(c).Where("complaints.created_at >= ? AND complaints.created_at < ?", start, end))
+	default:
+		err = fmt.Errorf("unknown report type %q", sub.ReportType)
+	}
+	attachment.Data = buf.Bytes()
+	return attachment, err
+}
+
+// deliverReport generates the report for the period, emails it to the
+// recipients and records the delivery, also when it failed.
+func deliverReport(sub models.ReportSubscription, start, end time.Time, manual bool) models.ReportDelivery {
+	delivery := models.ReportDelivery{
+		SubscriptionID: sub.ID,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		Recipients:     sub.Recipients,
+		Status:         models.DeliverySent,
+		Manual:         manual,
+	}
+
+	err := func() error {
+		var owner models.User
+		if err := DB.First(&owner, sub.OwnerID).Error; err != nil {
+			return errors.New("owner of the subscription no longer exists")
+		}
+		if !owner.IsActive || owner.ErasedAt != nil || !roleHasPermission(owner.Role, models.PermReportsRead) {
+			return fmt.Errorf("owner %s can no longer read reports", owner.Username)
+		}
+		attachment, err := generateReport(sub, owner, start, end)
+		if err != nil {
+			return fmt.Errorf("generating report: %v", err)
+		}
+		delivery.Filename = attachment.Filename
+		delivery.Size = len(attachment.Data)
+
+		lastDay := end.AddDate(0, 0, -1)
+		body := fmt.Sprintf("Hello,\n\nAttached is the SIMPEL-K report \"%s\" for %s to %s.\n\nMore reports are available at %s.\n\nYou receive this email because you are a recipient of this scheduled report. Contact the administrator to be removed.\n",
+			sub.Name, start.Format("2 January 2006"), lastDay.Format("2 January 2006"),
+			strings.TrimRight(config.AppConfig.AppBaseURL, "/")+"/admin/reports")
+		subject := fmt.Sprintf("SIMPEL-K report: %s (%s - %s)", sub.Name, start.Format("2 Jan 2006"), lastDay.Format("2 Jan 2006"))
+		if err := sendEmailWithAttachments(strings.Split(sub.Recipients, ","), subject, body, []emailAttachment{attachment}); err != nil {
+			return fmt.Errorf("sending email: %v", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	if err := DB.Create(&delivery).Error; err != nil {
+		log.Printf("Error recording delivery of report subscription %d: %v", sub.ID, err)
+	}
+	return delivery
+}
+
+// startReportScheduler sends the due report subscriptions every minute in
+// the background.
+func startReportScheduler() {
+	go func() {
+		ticker := time.NewTicker(reportSchedulerInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("Report scheduler panic: %v", r)
+					}
+				}()
+				runDueReports(time.Now())
+			}()
+		}
+	}()
+}
+
+// runDueReports sends every enabled subscription whose next run has passed.
+// A subscription is claimed by moving its next run forward first, so it is
+// sent once even when several servers share the database. Runs missed while
+// the server was down are sent once, for the period of the missed run.
+func runDueReports(now time.Time) {
+	var due []models.ReportSubscription
+	if err := DB.Where("enabled = ? AND next_run_at <= ?", true, now).Order("next_run_at ASC").Find(&due).Error; err != nil {
+		log.Printf("Error loading due report subscriptions: %v", err)
+		return
+	}
+	for _, sub := range due {
+		result := DB.Model(&models.ReportSubscription{}).Where("id = ? AND next_run_at = ?", sub.ID, sub.NextRunAt).
+			Updates(map[string]interface{}{"next_run_at": nextReportRun(sub.Cadence, now), "last_run_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		start, end := subscriptionPeriod(sub.Cadence, sub.NextRunAt)
+		if delivery := deliverReport(sub, start, end, false); delivery.Status == models.DeliveryFailed {
+			log.Printf("Error sending report subscription %d: %s", sub.ID, delivery.Error)
+		}
+	}
+}
+
+func getReportSubscriptions(c *gin.Context) {
+	var subs []models.ReportSubscription
+	if err := DB.Preload("Owner").Order("name ASC, id ASC").Find(&subs).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch report subscriptions"})
+		return
+	}
+	c.JSON(200, gin.H{"data": subs})
+}
+
+func getReportSubscription(c *gin.Context) {
+	var sub models.ReportSubscription
+	if err := DB.Preload("Owner").First(&sub, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Report subscription not found"})
+		return
+	}
+	c.JSON(200, sub)
+}
+
+func createReportSubscription(c *gin.Context) {
+	var req ReportSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sub models.ReportSubscription
+	if err := req.toSubscription(&sub); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	sub.OwnerID = getUserID(c)
+	sub.NextRunAt = nextReportRun(sub.Cadence, time.Now())
+
+	if err := DB.Create(&sub).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create report subscription"})
+		return
+	}
+	c.JSON(201, sub)
+}
+
+// updateReportSubscription also makes the current user the owner, since the
+// report now reflects their choice of filters.
+func updateReportSubscription(c *gin.Context) {
+	var sub models.ReportSubscription
+	if err := DB.First(&sub, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Report subscription not found"})
+		return
+	}
+
+	var req ReportSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.toSubscription(&sub); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	sub.OwnerID = getUserID(c)
+	sub.Owner = nil
+	sub.NextRunAt = nextReportRun(sub.Cadence, time.Now())
+
+	if err := DB.Save(&sub).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update report subscription"})
+		return
+	}
+	c.JSON(200, sub)
+}
+
+func deleteReportSubscription(c *gin.Context) {
+	var sub models.ReportSubscription
+	if err := DB.First(&sub, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Report subscription not found"})
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", sub.ID).Delete(&models.ReportDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&sub).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete report subscription"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Report subscription deleted successfully"})
+}
+
+// sendReportSubscription sends the report for the last complete period right
+// away, e.g. to check the recipients and filters. The schedule is unchanged.
+func sendReportSubscription(c *gin.Context) {
+	var sub models.ReportSubscription
+	if err := DB.First(&sub, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Report subscription not found"})
+		return
+	}
+
+	start, end := subscriptionPeriod(sub.Cadence, time.Now())
+	delivery := deliverReport(sub, start, end, true)
+	if delivery.Status == models.DeliveryFailed {
+		c.JSON(502, gin.H{"error": "Failed to send report: " + delivery.Error, "delivery": delivery})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Report sent successfully", "delivery": delivery})
+}
+
+func getReportDeliveries(c *gin.Context) {
+	var sub models.ReportSubscription
+	if err := DB.First(&sub, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Report subscription not found"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := DB.Model(&models.ReportDelivery{}).Where("subscription_id = ?", sub.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	query.Count(&total)
+	var deliveries []models.ReportDelivery
+	query.Order("created_at DESC, id DESC").Offset((page - 1) * limit).Limit(limit).Find(&deliveries)
+
+	c.JSON(200, gin.H{"data": deliveries, "total": total, "page": page, "limit": limit, "total_pages": (int(total) + limit - 1) / limit})
+}
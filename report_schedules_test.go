@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+)
+
+func localTime(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestNextReportRun(t *testing.T) {
+	config.AppConfig = testConfig()
+
+	// 9 and 16 March 2026 are Mondays
+	tests := []struct {
+		cadence     models.ReportCadence
+		after, want string
+	}{
+		{models.CadenceDaily, "2026-03-10 06:00", "2026-03-10 07:00"},
+		{models.CadenceDaily, "2026-03-10 07:00", "2026-03-11 07:00"},
+		{models.CadenceDaily, "2026-03-31 23:59", "2026-04-01 07:00"},
+		{models.CadenceWeekly, "2026-03-09 06:00", "2026-03-09 07:00"},
+		{models.CadenceWeekly, "2026-03-09 07:00", "2026-03-16 07:00"},
+		{models.CadenceWeekly, "2026-03-11 12:00", "2026-03-16 07:00"},
+		{models.CadenceWeekly, "2026-03-15 23:00", "2026-03-16 07:00"},
+		{models.CadenceMonthly, "2026-03-01 06:59", "2026-03-01 07:00"},
+		{models.CadenceMonthly, "2026-03-01 07:00", "2026-04-01 07:00"},
+		{models.CadenceMonthly, "2026-12-15 12:00", "2027-01-01 07:00"},
+	}
+	for _, tt := range tests {
+		if got := nextReportRun(tt.cadence, localTime(tt.after)); !got.Equal(localTime(tt.want)) {
+			t.Errorf("%s after %s = %s, want %s", tt.cadence, tt.after, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestSubscriptionPeriod(t *testing.T) {
+	tests := []struct {
+		cadence               models.ReportCadence
+		runAt, start, endDate string
+	}{
+		{models.CadenceDaily, "2026-03-10 07:00", "2026-03-09 00:00", "2026-03-10 00:00"},
+		{models.CadenceDaily, "2026-03-01 07:00", "2026-02-28 00:00", "2026-03-01 00:00"},
+		{models.CadenceWeekly, "2026-03-16 07:00", "2026-03-09 00:00", "2026-03-16 00:00"},
+		{models.CadenceMonthly, "2026-03-01 07:00", "2026-02-01 00:00", "2026-03-01 00:00"},
+		{models.CadenceMonthly, "2027-01-01 07:00", "2026-12-01 00:00", "2027-01-01 00:00"},
+		// A run sent late still covers the month before it was scheduled
+		{models.CadenceMonthly, "2026-03-02 09:30", "2026-02-01 00:00", "2026-03-01 00:00"},
+	}
+	for _, tt := range tests {
+		start, end := subscriptionPeriod(tt.cadence, localTime(tt.runAt))
+		if !start.Equal(localTime(tt.start)) || !end.Equal(localTime(tt.endDate)) {
+			t.Errorf("%s run at %s = %s to %s, want %s to %s", tt.cadence, tt.runAt,
+				start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"), tt.start, tt.endDate)
+		}
+	}
+}
+
+func TestNormalizeRecipients(t *testing.T) {
+	config.AppConfig = testConfig()
+	tooMany := make([]string, maxReportRecipients+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("r%d@kampus.ac.id", i)
+	}
+
+	tests := []struct {
+		name, domains, recipients, want string
+	}{
+		{"lowercased without duplicates", "", "Dekan@Kampus.ac.id, wakil@kampus.ac.id,dekan@kampus.ac.id", "dekan@kampus.ac.id,wakil@kampus.ac.id"},
+		{"display names", "", "Dekan <dekan@kampus.ac.id>, , ", "dekan@kampus.ac.id"},
+		{"any domain without a list", "", "someone@gmail.com", "someone@gmail.com"},
+		{"allowed domain", "@kampus.ac.id, fakultas.ac.id", "dekan@KAMPUS.ac.id,kaprodi@fakultas.ac.id", "dekan@kampus.ac.id,kaprodi@fakultas.ac.id"},
+		{"other domain", "kampus.ac.id", "dekan@kampus.ac.id,someone@gmail.com", ""},
+		{"subdomain", "kampus.ac.id", "dekan@mail.kampus.ac.id", ""},
+		{"lookalike domain", "kampus.ac.id", "dekan@evilkampus.ac.id", ""},
+		{"invalid address", "", "not-an-email", ""},
+		{"empty", "", " , ", ""},
+		{"too many", "", strings.Join(tooMany, ","), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig.ReportRecipientDomains = tt.domains
+			got, err := normalizeRecipients(tt.recipients)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("accepted %q", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteReportSubscription(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	sub := models.ReportSubscription{Name: "Weekly", ReportType: models.ReportSummaryPDF, Cadence: models.CadenceWeekly,
+		Recipients: "dekan@kampus.ac.id", Enabled: true, OwnerID: admin.ID}
+	DB.Create(&sub)
+	DB.Create(&models.ReportDelivery{SubscriptionID: sub.ID, Recipients: sub.Recipients, Status: models.DeliverySent})
+
+	route := "/api/report-subscriptions/:id"
+	if w := callAs(deleteReportSubscription, admin.ID, "admin", "DELETE", route, "/api/report-subscriptions/999", ""); w.Code != 404 {
+		t.Errorf("missing subscription = %d, want 404", w.Code)
+	}
+	if w := callAs(deleteReportSubscription, admin.ID, "admin", "DELETE", route, fmt.Sprintf("/api/report-subscriptions/%d", sub.ID), ""); w.Code != 200 {
+		t.Fatalf("delete = %d: %s", w.Code, w.Body.String())
+	}
+	var count int64
+	DB.Model(&models.ReportDelivery{}).Where("subscription_id = ?", sub.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d deliveries left", count)
+	}
+	if w := callAs(deleteReportSubscription, admin.ID, "admin", "DELETE", route, fmt.Sprintf("/api/report-subscriptions/%d", sub.ID), ""); w.Code != 404 {
+		t.Errorf("second delete = %d, want 404", w.Code)
+	}
+}
+
+// smtpMessage is a message received by the SMTP sink.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpSink is a minimal SMTP server that accepts every message, like Mailpit.
+type smtpSink struct {
+	sync.Mutex
+	messages []smtpMessage
+}
+
+// startSMTPSink serves the sink on a local port and points the SMTP settings
+// at it.
+func startSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sink := &smtpSink{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	config.AppConfig.SMTPHost = host
+	config.AppConfig.SMTPPort = port
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 sink ready")
+	var msg smtpMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			text.PrintfLine("250 sink")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			msg = smtpMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			text.PrintfLine("250 OK")
+		case command == "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.Lock()
+			s.messages = append(s.messages, msg)
+			s.Unlock()
+			text.PrintfLine("250 OK")
+		case command == "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpSink) received() []smtpMessage {
+	s.Lock()
+	defer s.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func TestDeliverReportOverSMTP(t *testing.T) {
+	setupTestDB(t)
+	sink := startSMTPSink(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	sub := models.ReportSubscription{Name: "Weekly complaints", ReportType: models.ReportComplaintsCSV, Cadence: models.CadenceWeekly,
+		Recipients: "dekan@kampus.ac.id,wakil@kampus.ac.id", Enabled: true, OwnerID: admin.ID}
+	DB.Create(&sub)
+
+	start, end := localTime("2026-03-09 00:00"), localTime("2026-03-16 00:00")
+	delivery := deliverReport(sub, start, end, true)
+	if delivery.Status != models.DeliverySent {
+		t.Fatalf("delivery failed: %s", delivery.Error)
+	}
+	if delivery.Filename != "complaints-20260309-20260315.csv" || delivery.Size == 0 {
+		t.Errorf("delivery = %+v", delivery)
+	}
+
+	messages := sink.received()
+	if len(messages) != 1 {
+		t.Fatalf("sink received %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.from != config.AppConfig.SMTPFrom || strings.Join(msg.to, ",") != sub.Recipients {
+		t.Errorf("envelope from %q to %v", msg.from, msg.to)
+	}
+	for _, want := range []string{"Subject: SIMPEL-K report: Weekly complaints (9 Mar 2026 - 15 Mar 2026)", "multipart/mixed", "attachment; filename=complaints-20260309-20260315.csv"} {
+		if !strings.Contains(msg.data, want) {
+			t.Errorf("message is missing %q:\n%s", want, msg.data)
+		}
+	}
+
+	// A failed delivery is recorded with the error
+	config.AppConfig.SMTPPort = "1"
+	if failed := deliverReport(sub, start, end, false); failed.Status != models.DeliveryFailed || !strings.Contains(failed.Error, "sending email") {
+		t.Errorf("delivery to a closed port = %+v", failed)
+	}
+	var count int64
+	DB.Model(&models.ReportDelivery{}).Where("subscription_id = ?", sub.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("%d deliveries recorded, want 2", count)
+	}
+}
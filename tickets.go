@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ticketPrefixFor returns the ticket prefix for a category, falling back to
+// the configured default prefix.
+func ticketPrefixFor(tx *gorm.DB, categoryID uint) string {
+	if config.AppConfig.TicketPrefixPerCategory {
+		var category models.Category
+		if err := tx.Select("ticket_prefix").First(&category, categoryID).Error; err == nil && category.TicketPrefix != "" {
+			return strings.ToUpper(category.TicketPrefix)
+		}
+	}
+	return strings.ToUpper(config.AppConfig.TicketPrefix)
+}
+
+// nextTicketID issues the next ticket number for the category's prefix in the
+// year of now, e.g. IT-2024-000123. It must run inside the transaction that
+// creates the complaint: the sequence row stays locked until that commits.
+func nextTicketID(tx *gorm.DB, categoryID uint, now time.Time) (string, error) {
+	prefix := ticketPrefixFor(tx, categoryID)
+	year := now.Year()
+
+	// Make sure the sequence row exists, then lock it for the increment
+	sequence := models.TicketSequence{Prefix: prefix, Year: year}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sequence).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("prefix = ? AND year = ?", prefix, year).
+		First(&sequence).Error; err != nil {
+		return "", err
+	}
+
+	sequence.LastValue++
+	if err := tx.Model(&sequence).Update("last_value", sequence.LastValue).Error; err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%d-%0*d", prefix, year, config.AppConfig.TicketSequenceDigits, sequence.LastValue), nil
+}
+
+func getComplaintByTicket(c *gin.Context) {
+	var complaint models.Complaint
+	if err := DB.Preload("User").Preload("Category").
+		Where("ticket_id = ?", strings.ToUpper(strings.TrimPrefix(c.Param("ticket"), "#"))).
+		First(&complaint).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+
+	c.JSON(200, complaint)
+}
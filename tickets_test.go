@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"gorm.io/gorm"
+)
+
+func issueTicketID(categoryID uint, now time.Time) (string, error) {
+	var ticketID string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		ticketID, err = nextTicketID(tx, categoryID, now)
+		return err
+	})
+	return ticketID, err
+}
+
+func TestNextTicketIDSequence(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.TicketPrefixPerCategory = true
+	it := models.Category{Name: "IT", Slug: "it", TicketPrefix: "it"}
+	general := models.Category{Name: "Umum", Slug: "umum"}
+	DB.Create(&it)
+	DB.Create(&general)
+
+	march := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	newYear := time.Date(2027, 1, 1, 0, 0, 1, 0, time.Local)
+	tests := []struct {
+		categoryID uint
+		now        time.Time
+		want       string
+	}{
+		{it.ID, march, "IT-2026-000001"},
+		{it.ID, march, "IT-2026-000002"},
+		// Categories without a prefix use the default sequence
+		{general.ID, march, "TKT-2026-000001"},
+		{it.ID, march, "IT-2026-000003"},
+		// Every prefix starts again at 1 in a new year
+		{it.ID, newYear, "IT-2027-000001"},
+		{general.ID, newYear, "TKT-2027-000001"},
+		{it.ID, newYear, "IT-2027-000002"},
+	}
+	for _, tt := range tests {
+		got, err := issueTicketID(tt.categoryID, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ticket ID = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestNextTicketIDConcurrent(t *testing.T) {
+	setupTestDB(t)
+	category := models.Category{Name: "Umum", Slug: "umum"}
+	DB.Create(&category)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+
+	const creates = 10
+	ids := make([]string, creates)
+	errs := make([]error, creates)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = issueTicketID(category.ID, now)
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Strings(ids)
+	for i, id := range ids {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if want := fmt.Sprintf("TKT-2026-%06d", i+1); id != want {
+			t.Errorf("ticket IDs = %v, want 1 to %d once each", ids, creates)
+			break
+		}
+	}
+}
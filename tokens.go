@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// randomToken returns a URL-safe random string built from n random bytes.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokenPair creates an access token and a new refresh token for the
+// session. An empty sessionID starts a new session.
+func issueTokenPair(c *gin.Context, user models.User, sessionID string) (string, string, *models.RefreshToken, error) {
+	if sessionID == "" {
+		id, err := randomToken(24)
+		if err != nil {
+			return "", "", nil, err
+		}
+		sessionID = id
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return "", "", nil, err
+	}
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().AddDate(0, 0, config.AppConfig.RefreshTokenDays),
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if err := DB.Create(&record).Error; err != nil {
+		return "", "", nil, err
+	}
+
+	accessToken, err := generateToken(user, sessionID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return accessToken, refreshToken, &record, nil
+}
+
+// tokenResponse is the JSON body shared by login and refresh.
+func tokenResponse(accessToken, refreshToken string) gin.H {
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    config.AppConfig.AccessTokenMinutes * 60,
+	}
+}
+
+// checkTokenRevocation rejects access tokens whose user no longer exists, whose
+// tokens were invalidated (password or role change, log out all devices) or
+// whose session was logged out. Impersonation tokens have no refresh token and
+// are checked against their impersonation session instead.
+func checkTokenRevocation(claims *Claims) error {
+	var user models.User
+	if err := DB.Select("id", "token_version").First(&user, claims.UserID).Error; err != nil {
+		return errors.New("User no longer exists")
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		return errors.New("Token has been revoked")
+	}
+
+	if claims.ImpersonationID != 0 {
+		return checkImpersonationSession(claims)
+	}
+
+	var active int64
+	DB.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", claims.SessionID, claims.UserID, time.Now()).
+		Count(&active)
+	if active == 0 {
+		return errors.New("Session has been logged out")
+	}
+	return nil
+}
+
+// revokeSession revokes every refresh token of a login session.
+func revokeSession(tx *gorm.DB, sessionID string) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserTokens invalidates every access and refresh token and every API
+// key of a user, and ends the impersonation sessions they are part of on
+// either side. Call it whenever the user's password or role changes.
+func revokeUserTokens(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.ImpersonationSession{}).
+		Where("(impersonator_id = ? OR target_user_id = ?) AND ended_at IS NULL", userID, userID).
+		Update("ended_at", time.Now()).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// refreshSession rotates a refresh token: the presented token is revoked and a
+// new pair is issued for the same session. Presenting an already rotated token
+// means it was stolen, so the whole session is revoked.
+func refreshSession(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var record models.RefreshToken
+	if err := DB.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&record).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if record.RevokedAt != nil {
+		if record.ReplacedByID != nil {
+			revokeSession(DB, record.SessionID)
+		}
+		c.JSON(401, gin.H{"error": "Refresh token has been revoked"})
+		return
+	}
+	if time.Now().After(record.ExpiresAt) {
+		c.JSON(401, gin.H{"error": "Refresh token has expired"})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, record.UserID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "User no longer exists"})
+		return
+	}
+
+	// Claim the token atomically so two concurrent refreshes cannot both use it
+	result := DB.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", record.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		revokeSession(DB, record.SessionID)
+		c.JSON(401, gin.H{"error": "Refresh token has been revoked"})
+		return
+	}
+
+	accessToken, newRefreshToken, newRecord, err := issueTokenPair(c, user, record.SessionID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	DB.Model(&record).Update("replaced_by_id", newRecord.ID)
+
+	c.JSON(200, tokenResponse(accessToken, newRefreshToken))
+}
+
+// logout ends the current session. Its access token stops working immediately.
+func logout(c *gin.Context) {
+	sessionID, _ := c.Get("session_id")
+	if err := revokeSession(DB, sessionID.(string)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Logged out successfully"})
+}
+
+// logoutAll ends every session of the current user on all devices.
+func logoutAll(c *gin.Context) {
+	if err := revokeUserTokens(DB, getUserID(c)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Logged out from all devices"})
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app)
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1
+	recoveryCodeCount = 10
+	mfaTokenMinutes   = 5
+)
+
+// Routes a user who still has to enroll in 2FA can reach, including the
+// password change a new account may be required to make first
+var twoFactorSetupAllowedRoutes = map[string]bool{
+	"/api/profile":            true,
+	"/api/profile/password":   true,
+	"/api/profile/2fa/setup":  true,
+	"/api/profile/2fa/enable": true,
+	"/api/logout":             true,
+	"/api/logout-all":         true,
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type LoginTwoFactorRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAClaims identify a user who passed the password check but still has to
+// provide a second factor. They are signed with a separate key so they can
+// never be used as access tokens.
+type MFAClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+func mfaSigningKey() []byte {
+	return []byte(config.AppConfig.JWTSecret + ":mfa")
+}
+
+func generateMFAToken(userID uint) (string, error) {
+	claims := &MFAClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaTokenMinutes * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "simplee-k",
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(mfaSigningKey())
+}
+
+func validateMFAToken(tokenString string) (*MFAClaims, error) {
+	claims := &MFAClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return mfaSigningKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// twoFactorSetupRequired reports whether policy forces the user to enroll
+// before using the API.
+func twoFactorSetupRequired(user models.User) bool {
+	return config.AppConfig.RequireAdminTOTP && isStaffRole(user.Role) && !user.TOTPEnabled
+}
+
+// generateTOTPSecret returns a random 160-bit base32 secret.
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// totpCode computes the code for a secret at the given time step.
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP returns the time step the code is valid for, allowing one step of
+// clock skew either way, or -1 if it does not match.
+func matchTOTP(secret, code string) int64 {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// verifyTOTP checks a code for the user and records its time step so the same
+// code cannot be replayed.
+func verifyTOTP(user *models.User, code string) bool {
+	if user.TOTPSecret == "" {
+		return false
+	}
+	step := matchTOTP(user.TOTPSecret, code)
+	if step < 0 || step <= user.TOTPLastCounter {
+		return false
+	}
+	result := DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, step).
+		Update("totp_last_counter", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	user.TOTPLastCounter = step
+	return true
+}
+
+// useRecoveryCode consumes one of the user's recovery codes.
+func useRecoveryCode(userID uint, code string) bool {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	result := DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new
+// codes in plain text. They are shown once and only their hashes are kept.
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := base32NoPadding.EncodeToString(b)
+		codes[i] = raw[:4] + "-" + raw[4:]
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// provisioningURI builds the otpauth:// URI that authenticator apps read from
+// a QR code.
+func provisioningURI(user models.User, secret string) string {
+	issuer := config.AppConfig.TOTPIssuer
+	label := url.PathEscape(issuer + ":" + user.Username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// setupTwoFactor starts enrollment by generating a new secret. 2FA is not
+// active until the first code is confirmed with enableTwoFactor.
+func setupTwoFactor(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	if err := DB.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_counter": 0}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start two-factor setup"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"secret":           secret,
+		"provisioning_uri": provisioningURI(user, secret),
+	})
+}
+
+// enableTwoFactor confirms enrollment with a code from the authenticator app
+// and returns the recovery codes.
+func enableTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(400, gin.H{"error": "Start two-factor setup first"})
+		return
+	}
+	if !verifyTOTP(&user, req.Code) {
+		c.JSON(400, gin.H{"error": "Invalid verification code"})
+		return
+	}
+
+	var codes []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	// Replace the current token in case it was limited to 2FA setup
+	user.TOTPEnabled = true
+	sessionID, _ := c.Get("session_id")
+	token, err := generateToken(user, sessionID.(string))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+		"token":          token,
+	})
+}
+
+// disableTwoFactor turns 2FA off after checking the password and a code.
+func disableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if config.AppConfig.RequireAdminTOTP && isStaffRole(user.Role) {
+		c.JSON(403, gin.H{"error": "Two-factor authentication is required for staff accounts"})
+		return
+	}
+	if !checkPasswordHash(req.Password, user.Password) || (!verifyTOTP(&user, req.Code) && !useRecoveryCode(user.ID, req.Code)) {
+		c.JSON(400, gin.H{"error": "Invalid password or verification code"})
+		return
+	}
+
+	if err := resetTwoFactor(DB, user.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// regenerateRecoveryCodes replaces the recovery codes after checking a code.
+func regenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, getUserID(c)).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.TOTPEnabled || !verifyTOTP(&user, req.Code) {
+		c.JSON(400, gin.H{"error": "Invalid verification code"})
+		return
+	}
+
+	var codes []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
+
+// resetTwoFactor removes a user's 2FA secret and recovery codes.
+func resetTwoFactor(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":       "",
+		"totp_enabled":      false,
+		"totp_last_counter": 0,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+// adminResetTwoFactor lets an admin clear the 2FA of a user who lost their
+// device. The user is signed out everywhere.
+func adminResetTwoFactor(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := resetTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+		return revokeUserTokens(tx, user.ID)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Two-factor authentication reset successfully"})
+}
+
+// loginTwoFactor completes a login that was paused for the second factor.
+func loginTwoFactor(c *gin.Context) {
+	var req LoginTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(400, gin.H{"error": "Verification code or recovery code is required"})
+		return
+	}
+
+	claims, err := validateMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired two-factor session, please log in again"})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired two-factor session, please log in again"})
+		return
+	}
+
+	if !user.IsActive {
+		c.JSON(403, gin.H{"error": "Your account has been deactivated", "code": "account_deactivated"})
+		return
+	}
+
+	ipKey := c.ClientIP()
+	accountKey := accountThrottleKey(user.Username, &user)
+	if rejectThrottledLogin(c, reserveLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)) {
+		return
+	}
+	if rejectThrottledLogin(c, reserveLoginAttempt(models.ThrottleAccount, accountKey, config.AppConfig.LoginMaxFailures)) {
+		releaseLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)
+		return
+	}
+
+	var ok bool
+	if req.Code != "" {
+		ok = verifyTOTP(&user, req.Code)
+	} else {
+		ok = useRecoveryCode(user.ID, req.RecoveryCode)
+	}
+	if !ok {
+		recordLoginAttempt(c, user.Username, &user, false, "invalid_2fa_code")
+		c.JSON(401, gin.H{"error": "Invalid verification code"})
+		return
+	}
+	releaseLoginAttempt(models.ThrottleIP, ipKey, config.AppConfig.LoginIPMaxFailures)
+
+	completeLogin(c, user)
+}
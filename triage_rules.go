@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dryRunDefaultLimit = 200
+	dryRunMaxLimit     = 1000
+)
+
+type TriageRuleRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Position       int    `json:"position"`
+	Enabled        *bool  `json:"enabled"`
+	Field          string `json:"field" binding:"omitempty,oneof=title description any"`
+	MatchType      string `json:"match_type" binding:"omitempty,oneof=keyword regex"`
+	Pattern        string `json:"pattern" binding:"required"`
+	CategoryID     *uint  `json:"category_id"`
+	SetCategoryID  *uint  `json:"set_category_id"`
+	SetPriority    string `json:"set_priority" binding:"omitempty,oneof=low normal high urgent"`
+	AddTags        string `json:"add_tags"`
+	AssignToID     *uint  `json:"assign_to_id"`
+	StopProcessing bool   `json:"stop_processing"`
+}
+
+// toRule copies the request onto a rule, applying defaults for omitted fields.
+func (req TriageRuleRequest) toRule(rule *models.TriageRule) {
+	rule.Name = req.Name
+	rule.Position = req.Position
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	rule.Field = models.RuleFieldAny
+	if req.Field != "" {
+		rule.Field = models.RuleField(req.Field)
+	}
+	rule.MatchType = models.RuleMatchKeyword
+	if req.MatchType != "" {
+		rule.MatchType = models.RuleMatchType(req.MatchType)
+	}
+	rule.Pattern = req.Pattern
+	rule.CategoryID = req.CategoryID
+	rule.SetCategoryID = req.SetCategoryID
+	rule.SetPriority = models.ComplaintPriority(req.SetPriority)
+	rule.AddTags = normalizeTags(req.AddTags)
+	rule.AssignToID = req.AssignToID
+	rule.StopProcessing = req.StopProcessing
+}
+
+// compiledRule is a triage rule with its pattern parsed once, so evaluating
+// it against many complaints does not recompile the regular expression.
+type compiledRule struct {
+	models.TriageRule
+	re       *regexp.Regexp
+	keywords []string
+}
+
+func compileTriageRule(rule models.TriageRule) (compiledRule, error) {
+	compiled := compiledRule{TriageRule: rule}
+	if rule.MatchType == models.RuleMatchRegex {
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return compiled, errors.New("Invalid regular expression: " + err.Error())
+		}
+		compiled.re = re
+		return compiled, nil
+	}
+	compiled.keywords = splitKeywords(rule.Pattern)
+	if len(compiled.keywords) == 0 {
+		return compiled, errors.New("Pattern must contain at least one keyword")
+	}
+	return compiled, nil
+}
+
+// validateTriageRule checks that a rule's pattern compiles and that the
+// categories and user it refers to exist.
+func validateTriageRule(rule models.TriageRule) error {
+	if _, err := compileTriageRule(rule); err != nil {
+		return err
+	}
+
+	if rule.SetCategoryID == nil && rule.SetPriority == "" && rule.AddTags == "" && rule.AssignToID == nil {
+		return errors.New("Rule must have at least one action")
+	}
+
+	for _, categoryID := range []*uint{rule.CategoryID, rule.SetCategoryID} {
+		if categoryID == nil {
+			continue
+		}
+		var category models.Category
+		if err := DB.First(&category, *categoryID).Error; err != nil {
+			return errors.New("Category not found")
+		}
+	}
+
+	if rule.AssignToID != nil {
+		var assignee models.User
+		if err := DB.Where("id = ? AND role IN ?", *rule.AssignToID, rolesWithPermission(models.PermComplaintsUpdate)).First(&assignee).Error; err != nil {
+			return errors.New("Assignee must be an existing user who handles complaints")
+		}
+	}
+	return nil
+}
+
+func splitKeywords(pattern string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(pattern, ",") {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// normalizeTags lowercases, trims and de-duplicates a comma separated tag list.
+func normalizeTags(tags string) string {
+	seen := map[string]bool{}
+	var result []string
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return strings.Join(result, ",")
+}
+
+// ruleMatches reports whether the rule's conditions hold for the complaint.
+func ruleMatches(rule compiledRule, complaint models.Complaint) bool {
+	if rule.CategoryID != nil && *rule.CategoryID != complaint.CategoryID {
+		return false
+	}
+
+	var text string
+	switch rule.Field {
+	case models.RuleFieldTitle:
+		text = complaint.Title
+	case models.RuleFieldDescription:
+		text = complaint.Description
+	default:
+		text = complaint.Title + "\n" + complaint.Description
+	}
+
+	if rule.re != nil {
+		return rule.re.MatchString(text)
+	}
+
+	text = strings.ToLower(text)
+	for _, keyword := range rule.keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRuleActions applies the rule's actions to the complaint in place.
+func applyRuleActions(rule models.TriageRule, complaint *models.Complaint) {
+	if rule.SetCategoryID != nil {
+		complaint.CategoryID = *rule.SetCategoryID
+	}
+	if rule.SetPriority != "" {
+		complaint.Priority = rule.SetPriority
+	}
+	if rule.AddTags != "" {
+		complaint.Tags = normalizeTags(complaint.Tags + "," + rule.AddTags)
+	}
+	if rule.AssignToID != nil {
+		assigneeID := *rule.AssignToID
+		complaint.AssignedToID = &assigneeID
+	}
+}
+
+// evaluateTriageRules runs every enabled rule against a new complaint in
+// order and returns the names of the rules that were applied.
+func evaluateTriageRules(complaint *models.Complaint) []string {
+	var rules []models.TriageRule
+	DB.Where("enabled = ?", true).Order("position ASC, id ASC").Find(&rules)
+
+	var applied []string
+	for _, rule := range rules {
+		compiled, err := compileTriageRule(rule)
+		if err != nil {
+			log.Printf("Skipping invalid triage rule %d: %v", rule.ID, err)
+			continue
+		}
+		if !ruleMatches(compiled, *complaint) {
+			continue
+		}
+		applyRuleActions(rule, complaint)
+		applied = append(applied, rule.Name)
+		if rule.StopProcessing {
+			break
+		}
+	}
+	return applied
+}
+
+func getTriageRules(c *gin.Context) {
+	var rules []models.TriageRule
+	if err := DB.Order("position ASC, id ASC").Find(&rules).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch triage rules"})
+		return
+	}
+	c.JSON(200, gin.H{"data": rules})
+}
+
+func getTriageRule(c *gin.Context) {
+	var rule models.TriageRule
+	if err := DB.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Triage rule not found"})
+		return
+	}
+	c.JSON(200, rule)
+}
+
+func createTriageRule(c *gin.Context) {
+	var req TriageRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rule models.TriageRule
+	req.toRule(&rule)
+	if err := validateTriageRule(rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := DB.Create(&rule).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create triage rule"})
+		return
+	}
+	c.JSON(201, rule)
+}
+
+func updateTriageRule(c *gin.Context) {
+	var rule models.TriageRule
+	if err := DB.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Triage rule not found"})
+		return
+	}
+
+	var req TriageRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.toRule(&rule)
+	if err := validateTriageRule(rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := DB.Save(&rule).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update triage rule"})
+		return
+	}
+	c.JSON(200, rule)
+}
+
+func deleteTriageRule(c *gin.Context) {
+	result := DB.Delete(&models.TriageRule{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete triage rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Triage rule not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Triage rule deleted successfully"})
+}
+
+// dryRunTriageRule evaluates a rule against recent complaints without saving
+// anything. The rule is taken from the request body, or from the database
+// when called for an existing rule ID.
+func dryRunTriageRule(c *gin.Context) {
+	var rule models.TriageRule
+	if id := c.Param("id"); id != "" {
+		if err := DB.First(&rule, id).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Triage rule not found"})
+			return
+		}
+	} else {
+		var req TriageRuleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		req.toRule(&rule)
+		if err := validateTriageRule(rule); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(dryRunDefaultLimit)))
+	if limit <= 0 || limit > dryRunMaxLimit {
+		limit = dryRunDefaultLimit
+	}
+
+	var complaints []models.Complaint
+	DB.Order("created_at DESC").Limit(limit).Find(&complaints)
+
+	compiled, err := compileTriageRule(rule)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	matches := []gin.H{}
+	for _, complaint := range complaints {
+		if !ruleMatches(compiled, complaint) {
+			continue
+		}
+		updated := complaint
+		applyRuleActions(rule, &updated)
+
+		changes := gin.H{}
+		if updated.CategoryID != complaint.CategoryID {
+			changes["category_id"] = gin.H{"from": complaint.CategoryID, "to": updated.CategoryID}
+		}
+		if updated.Priority != complaint.Priority {
+			changes["priority"] = gin.H{"from": complaint.Priority, "to": updated.Priority}
+		}
+		if updated.Tags != complaint.Tags {
+			changes["tags"] = gin.H{"from": complaint.Tags, "to": updated.Tags}
+		}
+		if updated.AssignedToID != nil && (complaint.AssignedToID == nil || *updated.AssignedToID != *complaint.AssignedToID) {
+			changes["assigned_to_id"] = gin.H{"from": complaint.AssignedToID, "to": updated.AssignedToID}
+		}
+
+		matches = append(matches, gin.H{
+			"id":        complaint.ID,
+			"ticket_id": complaint.TicketID,
+			"title":     complaint.Title,
+			"changes":   changes,
+		})
+	}
+
+	c.JSON(200, gin.H{
+		"evaluated": len(complaints),
+		"matched":   len(matches),
+		"matches":   matches,
+	})
+}
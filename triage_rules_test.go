@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"simplee-k/models"
+)
+
+func TestDeleteTriageRule(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	rule := models.TriageRule{Name: "WiFi", Enabled: true, Field: models.RuleFieldAny, MatchType: models.RuleMatchKeyword, Pattern: "wifi"}
+	DB.Create(&rule)
+
+	route := "/api/triage-rules/:id"
+	path := fmt.Sprintf("/api/triage-rules/%d", rule.ID)
+	if w := callAs(deleteTriageRule, admin.ID, "admin", "DELETE", route, "/api/triage-rules/999", ""); w.Code != 404 {
+		t.Errorf("missing rule = %d, want 404", w.Code)
+	}
+	if w := callAs(deleteTriageRule, admin.ID, "admin", "DELETE", route, path, ""); w.Code != 200 {
+		t.Fatalf("delete = %d: %s", w.Code, w.Body.String())
+	}
+	if w := callAs(deleteTriageRule, admin.ID, "admin", "DELETE", route, path, ""); w.Code != 404 {
+		t.Errorf("second delete = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"simplee-k/config"
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// Every row is hashed with bcrypt, so an import is kept to a size that
+// finishes within a request.
+const maxImportRows = 1000
+
+// Import columns match CreateUserRequest. Password and role are optional.
+var importColumns = []string{"username", "student_id", "email", "password", "name", "role", "phone"}
+
+const (
+	importModeCreate = "create"
+	importModeUpsert = "upsert"
+
+	importPasswordGenerate = "generate"
+	importPasswordInvite   = "invite"
+)
+
+type ImportUsersRequest struct {
+	DryRun       bool   `form:"dry_run"`
+	Mode         string `form:"mode" binding:"omitempty,oneof=create upsert"`
+	PasswordMode string `form:"password_mode" binding:"omitempty,oneof=generate invite"`
+	DefaultRole  string `form:"default_role"`
+}
+
+// ImportRowResult reports what happened, or would happen, to one row.
+type ImportRowResult struct {
+	Row      int      `json:"row"`
+	Username string   `json:"username"`
+	Action   string   `json:"action"`
+	Errors   []string `json:"errors,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Invited  bool     `json:"invited,omitempty"`
+
+	req      CreateUserRequest
+	existing *models.User
+}
+
+// readImportFile returns the rows of an uploaded CSV or XLSX file, header
+// included.
+func readImportFile(file *multipart.FileHeader) ([][]string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(file.Filename)) {
+	case ".csv":
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		return reader.ReadAll()
+	case ".xlsx":
+		book, err := excelize.OpenReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer book.Close()
+		return book.GetRows(book.GetSheetName(0))
+	}
+	return nil, errors.New("Only CSV and XLSX files are supported")
+}
+
+// parseImportRows maps the columns named in the header row to user requests.
+func parseImportRows(rows [][]string) ([]*ImportRowResult, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("File is empty")
+	}
+
+	index := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		index[name] = i
+	}
+	for _, required := range []string{"username", "email", "name"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("Missing column %q, expected columns: %s", required, strings.Join(importColumns, ", "))
+		}
+	}
+
+	var results []*ImportRowResult
+	for i, row := range rows[1:] {
+		value := func(column string) string {
+			if j, ok := index[column]; ok && j < len(row) {
+				return strings.TrimSpace(row[j])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		req := CreateUserRequest{
+			Username:  value("username"),
+			StudentID: value("student_id"),
+			Email:     value("email"),
+			Password:  value("password"),
+			Name:      value("name"),
+			Role:      value("role"),
+			Phone:     value("phone"),
+		}
+		results = append(results, &ImportRowResult{Row: i + 2, Username: req.Username, req: req})
+	}
+	if len(results) > maxImportRows {
+		return nil, fmt.Errorf("File has %d rows, at most %d can be imported at once", len(results), maxImportRows)
+	}
+	return results, nil
+}
+
+// validateImportRow checks one row against the database and the rows before
+// it, and decides whether it creates or updates a user. Rows without a role
+// keep the role of an existing user or get the default role.
+func validateImportRow(c *gin.Context, result *ImportRowResult, req ImportUsersRequest, seen map[string]int) {
+	row := &result.req
+	var existing models.User
+	err := DB.Unscoped().Where("username = ?", row.Username).First(&existing).Error
+	if err == nil && req.Mode == importModeUpsert {
+		result.existing = &existing
+	}
+	if row.Role == "" {
+		row.Role = req.DefaultRole
+		if result.existing != nil {
+			row.Role = string(existing.Role)
+		}
+	}
+	addError := func(format string, args ...interface{}) {
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	if row.Username == "" {
+		addError("Username is required")
+	}
+	if row.Name == "" {
+		addError("Name is required")
+	}
+	if addr, err := mail.ParseAddress(row.Email); err != nil || addr.Address != row.Email {
+		addError("Invalid email %q", row.Email)
+	}
+	if !roleExists(models.UserRole(row.Role)) {
+		addError("Role %q does not exist", row.Role)
+	}
+	if row.Password != "" {
+		if err := validatePassword(row.Password, models.User{Username: row.Username}); err != nil {
+			addError("%s", err.Error())
+		}
+	}
+
+	// Values must be unique within the file as well
+	for _, field := range []struct{ name, value string }{
+		{"username", row.Username}, {"email", row.Email}, {"student ID", row.StudentID},
+	} {
+		if field.value == "" {
+			continue
+		}
+		key := field.name + ":" + strings.ToLower(field.value)
+		if firstRow, ok := seen[key]; ok {
+			addError("Duplicate %s %q, already used in row %d", field.name, field.value, firstRow)
+		} else {
+			seen[key] = result.Row
+		}
+	}
+	if len(result.Errors) > 0 {
+		result.Action = "error"
+		return
+	}
+
+	if result.existing != nil {
+		switch {
+		case existing.DeletedAt.Valid:
+			addError("Username %q belongs to a deleted user, restore it first", row.Username)
+		case existing.IsServiceAccount:
+			addError("Username %q belongs to a service account", row.Username)
+		case isAdministrativeRole(existing.Role) && !hasPermission(c, models.PermRolesManage):
+			addError("You do not have permission to update %q, who has administrative permissions", row.Username)
+		}
+		if existing.Role != models.UserRole(row.Role) {
+			if !hasPermission(c, models.PermRolesManage) {
+				addError("You do not have permission to change the role of %q", row.Username)
+			} else if existing.IsActive && countRoleManagers(DB, existing.ID, models.UserRole(row.Role)) == 0 {
+				addError("At least one user must keep the permission to manage roles")
+			}
+		}
+	} else if err != gorm.ErrRecordNotFound && err != nil {
+		addError("Database error")
+	} else if !canAssignRole(c, models.UserRole(row.Role)) {
+		addError("You do not have permission to assign the role %q", row.Role)
+	}
+
+	var userID uint
+	if result.existing != nil {
+		userID = result.existing.ID
+	}
+	if msg := userConflict(userID, row.Username, row.Email, row.StudentID); msg != "" {
+		addError("%s", msg)
+	}
+
+	switch {
+	case len(result.Errors) > 0:
+		result.Action = "error"
+	case result.existing == nil:
+		result.Action = "create"
+	case importRowChanged(*result.existing, *row):
+		result.Action = "update"
+	default:
+		result.Action = "unchanged"
+	}
+}
+
+func importRowChanged(user models.User, req CreateUserRequest) bool {
+	return studentIDOf(user) != req.StudentID || user.Email != req.Email || user.Name != req.Name ||
+		user.Phone != req.Phone || user.Role != models.UserRole(req.Role)
+}
+
+// applyImportRow creates or updates the user of a validated row.
+func applyImportRow(tx *gorm.DB, result *ImportRowResult, passwordMode string) error {
+	req := result.req
+	if result.Action == "update" {
+		user := result.existing
+		roleChanged := user.Role != models.UserRole(req.Role)
+		emailChanged := user.Email != req.Email
+		updates := map[string]interface{}{
+			"student_id": studentIDColumn(req.StudentID),
+			"email":      req.Email,
+			"name":       req.Name,
+			"phone":      req.Phone,
+			"role":       req.Role,
+		}
+		if emailChanged {
+			updates["email_verified_at"] = nil
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if roleChanged || emailChanged {
+			return revokeUserTokens(tx, user.ID)
+		}
+		return nil
+	}
+	if result.Action != "create" {
+		return nil
+	}
+
+	password := req.Password
+	mustChange := true
+	if password == "" {
+		generated, err := randomToken(12)
+		if err != nil {
+			return err
+		}
+		password = generated
+		if passwordMode == importPasswordInvite {
+			// The user chooses a password through the invitation link
+			mustChange = false
+		} else {
+			result.Password = generated
+		}
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user := models.User{
+		Username:           req.Username,
+		StudentID:          studentIDColumn(req.StudentID),
+		Email:              req.Email,
+		Password:           hashedPassword,
+		Name:               req.Name,
+		Role:               models.UserRole(req.Role),
+		Phone:              req.Phone,
+		MustChangePassword: mustChange,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return err
+	}
+	result.existing = &user
+	return nil
+}
+
+// sendInvitation emails a link to choose a password. It reuses the password
+// reset flow with a longer expiry.
+func sendInvitation(user models.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().AddDate(0, 0, config.AppConfig.InvitationDays),
+	}
+	if err := DB.Create(&resetToken).Error; err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimRight(config.AppConfig.AppBaseURL, "/"), token)
+	body := fmt.Sprintf("Hello %s,\n\nAn account has been created for you on SIMPEL-K with the username %s. Open the link below to choose your password:\n\n%s\n\nThe link expires in %d days and can only be used once.\n",
+		user.Name, user.Username, link, config.AppConfig.InvitationDays)
+	return sendEmail([]string{user.Email}, "Your SIMPEL-K account", body)
+}
+
+// importUsers creates or updates users from a CSV or XLSX file. Every row is
+// validated first; if any row has an error nothing is imported. With dry_run
+// only the validation report is returned.
+func importUsers(c *gin.Context) {
+	var req ImportUsersRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = importModeCreate
+	}
+	if req.PasswordMode == "" {
+		req.PasswordMode = importPasswordGenerate
+	}
+	if req.DefaultRole == "" {
+		req.DefaultRole = string(models.RoleStudent)
+	}
+	if !canAssignRole(c, models.UserRole(req.DefaultRole)) {
+		c.JSON(403, gin.H{"error": "You do not have permission to assign this role"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "File is required"})
+		return
+	}
+	if file.Size > config.AppConfig.MaxUploadSize {
+		c.JSON(400, gin.H{"error": "File size exceeds the limit"})
+		return
+	}
+	rows, err := readImportFile(file)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	results, err := parseImportRows(rows)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	seen := map[string]int{}
+	summary := map[string]int{"create": 0, "update": 0, "unchanged": 0, "error": 0}
+	for _, result := range results {
+		validateImportRow(c, result, req, seen)
+		summary[result.Action]++
+	}
+
+	response := gin.H{"dry_run": req.DryRun, "total": len(results), "summary": summary, "rows": results}
+	if summary["error"] > 0 {
+		if req.DryRun {
+			response["valid"] = false
+			c.JSON(200, response)
+			return
+		}
+		response["error"] = "Import has errors, nothing was imported"
+		c.JSON(400, response)
+		return
+	}
+	if req.DryRun {
+		response["valid"] = true
+		c.JSON(200, response)
+		return
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, result := range results {
+			if err := applyImportRow(tx, result, req.PasswordMode); err != nil {
+				return fmt.Errorf("row %d: %w", result.Row, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Printf("Error importing users: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to import users, nothing was imported"})
+		return
+	}
+
+	if req.PasswordMode == importPasswordInvite {
+		for _, result := range results {
+			if result.Action != "create" || result.req.Password != "" {
+				continue
+			}
+			if err := sendInvitation(*result.existing); err != nil {
+				log.Printf("Error sending invitation to %s: %v", result.Username, err)
+				continue
+			}
+			result.Invited = true
+		}
+	}
+
+	response["message"] = "Users imported successfully"
+	c.JSON(200, response)
+}
+
+// getImportTemplate returns an empty CSV file with the import columns.
+func getImportTemplate(c *gin.Context) {
+	c.Header("Content-Disposition", `attachment; filename="users-import.csv"`)
+	c.Data(200, "text/csv; charset=utf-8", []byte(strings.Join(importColumns, ",")+"\n"))
+}
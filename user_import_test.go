@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func validateImportAs(role string, req ImportUsersRequest, row CreateUserRequest) *ImportRowResult {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("user_role", role)
+	result := &ImportRowResult{Row: 2, Username: row.Username, req: row}
+	validateImportRow(c, result, req, map[string]int{})
+	return result
+}
+
+func TestValidateImportRowRoles(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "usermanager", models.PermUsersManage)
+	createTestUser(t, "boss", models.RoleAdmin)
+	createTestUser(t, "student", models.RoleStudent)
+
+	create := ImportUsersRequest{Mode: importModeCreate, DefaultRole: string(models.RoleStudent)}
+	upsert := ImportUsersRequest{Mode: importModeUpsert, DefaultRole: string(models.RoleStudent)}
+	tests := []struct {
+		name       string
+		callerRole string
+		req        ImportUsersRequest
+		row        CreateUserRequest
+		wantAction string
+		wantError  string
+	}{
+		{"new student", "usermanager", create,
+			CreateUserRequest{Username: "new", Email: "new@example.com", Name: "New"}, "create", ""},
+		{"new handler without roles.manage", "usermanager", create,
+			CreateUserRequest{Username: "new", Email: "new@example.com", Name: "New", Role: "handler"}, "error", "assign the role"},
+		{"new user with a staff default role", "usermanager", ImportUsersRequest{Mode: importModeCreate, DefaultRole: "admin"},
+			CreateUserRequest{Username: "new", Email: "new@example.com", Name: "New"}, "error", "assign the role"},
+		{"new handler with roles.manage", "admin", create,
+			CreateUserRequest{Username: "new", Email: "new@example.com", Name: "New", Role: "handler"}, "create", ""},
+		{"email of an administrator", "usermanager", upsert,
+			CreateUserRequest{Username: "boss", StudentID: "S-boss", Email: "mine@example.com", Name: "boss"}, "error", "administrative permissions"},
+		{"email of a student", "usermanager", upsert,
+			CreateUserRequest{Username: "student", StudentID: "S-student", Email: "new-student@example.com", Name: "student"}, "update", ""},
+		{"administrator as role manager", "admin", upsert,
+			CreateUserRequest{Username: "boss", StudentID: "S-boss", Email: "boss2@example.com", Name: "boss"}, "update", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := validateImportAs(tt.callerRole, tt.req, tt.row)
+			if result.Action != tt.wantAction {
+				t.Errorf("action = %s, want %s (errors: %v)", result.Action, tt.wantAction, result.Errors)
+			}
+			if tt.wantError != "" && !strings.Contains(strings.Join(result.Errors, "; "), tt.wantError) {
+				t.Errorf("errors = %v, want one mentioning %q", result.Errors, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestApplyImportRowEmailChangeSignsOut(t *testing.T) {
+	setupTestDB(t)
+	student := createTestUser(t, "student", models.RoleStudent)
+
+	result := validateImportAs(string(models.RoleAdmin), ImportUsersRequest{Mode: importModeUpsert},
+		CreateUserRequest{Username: "student", StudentID: "S-student", Email: "new@example.com", Name: "student"})
+	if err := applyImportRow(DB, result, importPasswordGenerate); err != nil {
+		t.Fatal(err)
+	}
+	var updated models.User
+	DB.First(&updated, student.ID)
+	if updated.Email != "new@example.com" || updated.EmailVerifiedAt != nil || updated.TokenVersion == student.TokenVersion {
+		t.Errorf("after the email change: %+v", updated)
+	}
+}
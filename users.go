@@ -0,0 +1,284 @@
+package main
+
+import (
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateUserRequest struct {
+	Username  string `json:"username" binding:"required"`
+	StudentID string `json:"student_id"`
+	Email     string `json:"email" binding:"required,email"`
+	Name      string `json:"name" binding:"required"`
+	Phone     string `json:"phone"`
+	Role      string `json:"role"`
+}
+
+// userSummary is the user as returned by the user management endpoints.
+func userSummary(user models.User) gin.H {
+	summary := gin.H{
+		"id":                 user.ID,
+		"username":           user.Username,
+		"student_id":         user.StudentID,
+		"email":              user.Email,
+		"name":               user.Name,
+		"role":               user.Role,
+		"phone":              user.Phone,
+		"org_unit_id":        user.OrgUnitID,
+		"auth_provider":      user.AuthProvider,
+		"is_service_account": user.IsServiceAccount,
+		"is_active":          user.IsActive,
+		"deactivated_at":     user.DeactivatedAt,
+		"created_at":         user.CreatedAt,
+	}
+	if user.DeletedAt.Valid {
+		summary["deleted_at"] = user.DeletedAt.Time
+	}
+	if user.ErasedAt != nil {
+		summary["erased_at"] = user.ErasedAt
+	}
+	return summary
+}
+
+// userConflict reports which unique field of another user, deleted users
+// included, already has the given value.
+func userConflict(userID uint, username, email, studentID string) string {
+	var count int64
+	DB.Unscoped().Model(&models.User{}).Where("username = ? AND id <> ?", username, userID).Count(&count)
+	if count > 0 {
+		return "Username already exists"
+	}
+	DB.Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", email, userID).Count(&count)
+	if count > 0 {
+		return "Email already exists"
+	}
+	if studentID != "" {
+		DB.Unscoped().Model(&models.User{}).Where("student_id = ? AND id <> ?", studentID, userID).Count(&count)
+		if count > 0 {
+			return "Student ID already exists"
+		}
+	}
+	return ""
+}
+
+// studentIDColumn returns the student ID to store, NULL when there is none.
+func studentIDColumn(studentID string) *string {
+	if studentID == "" {
+		return nil
+	}
+	return &studentID
+}
+
+// studentIDOf returns the user's student ID, or "" when they have none.
+func studentIDOf(user models.User) string {
+	if user.StudentID == nil {
+		return ""
+	}
+	return *user.StudentID
+}
+
+// isLastRoleManager reports whether removing the user would leave nobody
+// able to manage roles.
+func isLastRoleManager(user models.User) bool {
+	return roleHasPermission(user.Role, models.PermRolesManage) && countRoleManagers(DB, user.ID, "") == 0
+}
+
+// canManageUser reports whether the caller may edit the user or change the
+// account's status. Accounts with administrative permissions need
+// roles.manage, so users.manage alone cannot lock out, delete or take over an
+// administrator.
+func canManageUser(c *gin.Context, user models.User) bool {
+	return !isAdministrativeRole(user.Role) || hasPermission(c, models.PermRolesManage)
+}
+
+// loadManagedUser loads the user named in the route for a lifecycle action.
+// Administrators cannot use these endpoints on their own account.
+func loadManagedUser(c *gin.Context, query *gorm.DB) (models.User, bool) {
+	var user models.User
+	if err := query.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return user, false
+	}
+	if user.ID == getUserID(c) {
+		c.JSON(400, gin.H{"error": "You cannot change the status of your own account"})
+		return user, false
+	}
+	if !canManageUser(c, user) {
+		c.JSON(403, gin.H{"error": "You do not have permission to manage administrators"})
+		return user, false
+	}
+	if user.ErasedAt != nil {
+		c.JSON(400, gin.H{"error": "User has been erased"})
+		return user, false
+	}
+	return user, true
+}
+
+func getUser(c *gin.Context) {
+	var user models.User
+	if err := DB.Unscoped().First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(200, gin.H{"data": userSummary(user)})
+}
+
+// updateUser edits a user's details. Changing the role as well needs the
+// roles.manage permission, and signs the user out like assignUserRole.
+func updateUser(c *gin.Context) {
+	var user models.User
+	if err := DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ErasedAt != nil {
+		c.JSON(400, gin.H{"error": "User has been erased"})
+		return
+	}
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// Changing the email of an administrator would let the caller reset their
+	// password, so only role managers may edit them
+	if !canManageUser(c, user) {
+		c.JSON(403, gin.H{"error": "You do not have permission to edit administrators"})
+		return
+	}
+
+	role := user.Role
+	if req.Role != "" && models.UserRole(req.Role) != user.Role {
+		if !hasPermission(c, models.PermRolesManage) {
+			c.JSON(403, gin.H{"error": "You do not have permission to change roles"})
+			return
+		}
+		role = models.UserRole(req.Role)
+		if !roleExists(role) {
+			c.JSON(400, gin.H{"error": "Role does not exist"})
+			return
+		}
+		if user.IsActive && countRoleManagers(DB, user.ID, role) == 0 {
+			c.JSON(400, gin.H{"error": "At least one user must keep the permission to manage roles"})
+			return
+		}
+	}
+	// Directory accounts are linked by username
+	if req.Username != user.Username && user.AuthProvider == models.AuthProviderLDAP {
+		c.JSON(400, gin.H{"error": "The username of an LDAP account cannot be changed"})
+		return
+	}
+	if msg := userConflict(user.ID, req.Username, req.Email, req.StudentID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	emailChanged := req.Email != user.Email
+	signOut := role != user.Role || req.Username != user.Username || emailChanged
+	updates := map[string]interface{}{
+		"username":   req.Username,
+		"student_id": studentIDColumn(req.StudentID),
+		"email":      req.Email,
+		"name":       req.Name,
+		"phone":      req.Phone,
+		"role":       role,
+	}
+	// Nobody proved owning the new address yet
+	if emailChanged {
+		updates["email_verified_at"] = nil
+	}
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if signOut {
+			return revokeUserTokens(tx, user.ID)
+		}
+		return nil
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update user"})
+		return
+	}
+	DB.First(&user, user.ID)
+	c.JSON(200, gin.H{"message": "User updated successfully", "data": userSummary(user)})
+}
+
+// deactivateUser blocks a user, e.g. a graduated student, from logging in
+// while keeping their complaints. Their sessions and API keys stop working.
+func deactivateUser(c *gin.Context) {
+	user, ok := loadManagedUser(c, DB)
+	if !ok {
+		return
+	}
+	if !user.IsActive {
+		c.JSON(200, gin.H{"message": "User is already deactivated"})
+		return
+	}
+	if isLastRoleManager(user) {
+		c.JSON(400, gin.H{"error": "At least one active user must keep the permission to manage roles"})
+		return
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"is_active": false, "deactivated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return revokeUserTokens(tx, user.ID)
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to deactivate user"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User deactivated successfully"})
+}
+
+func reactivateUser(c *gin.Context) {
+	user, ok := loadManagedUser(c, DB)
+	if !ok {
+		return
+	}
+	if err := DB.Model(&user).Updates(map[string]interface{}{"is_active": true, "deactivated_at": nil}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reactivate user"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User reactivated successfully"})
+}
+
+// deleteUser soft deletes a user. The account and its complaints stay in the
+// database and can be restored.
+func deleteUser(c *gin.Context) {
+	user, ok := loadManagedUser(c, DB)
+	if !ok {
+		return
+	}
+	if user.IsActive && isLastRoleManager(user) {
+		c.JSON(400, gin.H{"error": "At least one active user must keep the permission to manage roles"})
+		return
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeUserTokens(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User deleted successfully"})
+}
+
+func restoreUser(c *gin.Context) {
+	user, ok := loadManagedUser(c, DB.Unscoped().Where("deleted_at IS NOT NULL"))
+	if !ok {
+		return
+	}
+	if err := DB.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to restore user"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User restored successfully"})
+}
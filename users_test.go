@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpdateUserAdministratorsNeedRolesManage(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "usermanager", models.PermUsersManage)
+	admin := createTestUser(t, "boss", models.RoleAdmin)
+	student := createTestUser(t, "student", models.RoleStudent)
+
+	path := "/api/users/" + strconv.Itoa(int(admin.ID))
+	body := `{"username":"boss","student_id":"S-boss","email":"mine@example.com","name":"boss"}`
+	if w := callAs(updateUser, 0, "usermanager", "PUT", "/api/users/:id", path, body); w.Code != 403 {
+		t.Errorf("manager editing an admin = %d, want 403", w.Code)
+	}
+
+	path = "/api/users/" + strconv.Itoa(int(student.ID))
+	body = `{"username":"student","student_id":"S-student","email":"student@example.com","name":"Renamed"}`
+	if w := callAs(updateUser, 0, "usermanager", "PUT", "/api/users/:id", path, body); w.Code != 200 {
+		t.Errorf("manager editing a student = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUpdateUserEmailChange(t *testing.T) {
+	setupTestDB(t)
+	student := createTestUser(t, "student", models.RoleStudent)
+	DB.Model(&student).Update("email_verified_at", time.Now())
+
+	path := "/api/users/" + strconv.Itoa(int(student.ID))
+	body := `{"username":"student","student_id":"S-student","email":"new@example.com","name":"student"}`
+	if w := callAs(updateUser, 0, string(models.RoleAdmin), "PUT", "/api/users/:id", path, body); w.Code != 200 {
+		t.Fatalf("update = %d: %s", w.Code, w.Body.String())
+	}
+	var updated models.User
+	DB.First(&updated, student.ID)
+	if updated.EmailVerifiedAt != nil {
+		t.Error("email_verified_at was kept for the new email")
+	}
+	if updated.TokenVersion == student.TokenVersion {
+		t.Error("tokens were not revoked after the email change")
+	}
+}
+
+func TestUserLifecycleAdministratorsNeedRolesManage(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "usermanager", models.PermUsersManage)
+	manager := createTestUser(t, "manager", "usermanager")
+	// Keeps a role manager active while the others are deactivated
+	createTestUser(t, "keeper", models.RoleAdmin)
+
+	tests := []struct {
+		name    string
+		handler func(*gin.Context)
+		action  string
+		deleted bool
+	}{
+		{"deactivate", deactivateUser, "deactivate", false},
+		{"reactivate", reactivateUser, "reactivate", false},
+		{"delete", deleteUser, "", false},
+		{"restore", restoreUser, "restore", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := createTestUser(t, "admin-"+tt.name, models.RoleAdmin)
+			student := createTestUser(t, "student-"+tt.name, models.RoleStudent)
+			if tt.deleted {
+				DB.Delete(&admin)
+				DB.Delete(&student)
+			}
+			method, route := "POST", "/api/users/:id/"+tt.action
+			if tt.action == "" {
+				method, route = "DELETE", "/api/users/:id"
+			}
+			path := func(user models.User) string {
+				return strings.Replace(route, ":id", strconv.Itoa(int(user.ID)), 1)
+			}
+
+			if w := callAs(tt.handler, manager.ID, "usermanager", method, route, path(admin), ""); w.Code != 403 {
+				t.Errorf("manager on an admin = %d, want 403", w.Code)
+			}
+			if w := callAs(tt.handler, manager.ID, "usermanager", method, route, path(student), ""); w.Code != 200 {
+				t.Errorf("manager on a student = %d, want 200: %s", w.Code, w.Body.String())
+			}
+			if w := callAs(tt.handler, manager.ID, "admin", method, route, path(admin), ""); w.Code != 200 {
+				t.Errorf("role manager on an admin = %d, want 200: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// A refused deactivation leaves the administrator untouched
+	admin := createTestUser(t, "untouched", models.RoleAdmin)
+	callAs(deactivateUser, manager.ID, "usermanager", "POST", "/api/users/:id/deactivate", "/api/users/"+strconv.Itoa(int(admin.ID))+"/deactivate", "")
+	var stored models.User
+	DB.First(&stored, admin.ID)
+	if !stored.IsActive || stored.TokenVersion != admin.TokenVersion {
+		t.Errorf("refused deactivation changed the admin to %+v", stored)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"simplee-k/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AddWatcherRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+// isWatching reports whether the user follows the complaint.
+func isWatching(complaintID, userID uint) bool {
+	var count int64
+	DB.Model(&models.ComplaintWatcher{}).Where("complaint_id = ? AND user_id = ?", complaintID, userID).Count(&count)
+	return count > 0
+}
+
+// canViewComplaint reports whether the current user may see the complaint:
+// staff see the complaints of their unit (or all of them), students see their
+// own complaints and the ones they watch.
+func canViewComplaint(c *gin.Context, complaint models.Complaint) bool {
+	if hasPermission(c, models.PermComplaintsReadAll) {
+		return inUserUnit(c, complaint)
+	}
+	userID := getUserID(c)
+	return complaint.UserID == userID || isWatching(complaint.ID, userID)
+}
+
+// addWatcher makes a user follow a complaint. Watching twice is a no-op.
+func addWatcher(tx *gorm.DB, complaintID, userID, addedByID uint) error {
+	watcher := models.ComplaintWatcher{ComplaintID: complaintID, UserID: userID, AddedByID: addedByID}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&watcher).Error
+}
+
+// copyWatchers adds every watcher of the source complaint to the target one.
+func copyWatchers(tx *gorm.DB, sourceID, targetID uint) error {
+	var watchers []models.ComplaintWatcher
+	if err := tx.Where("complaint_id = ?", sourceID).Find(&watchers).Error; err != nil {
+		return err
+	}
+	for _, watcher := range watchers {
+		if err := addWatcher(tx, targetID, watcher.UserID, watcher.AddedByID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyWatchers sends a notification to every watcher of a complaint except
+// the users in skipUserIDs (usually the reporter and whoever made the change)
+// and those who turned complaint updates off. Complaints have no comments
+// yet; a comment feature should notify watchers through here as well.
+func notifyWatchers(complaint models.Complaint, title, message string, skipUserIDs ...uint) {
+	skip := map[uint]bool{}
+	for _, id := range skipUserIDs {
+		skip[id] = true
+	}
+
+	var watchers []models.ComplaintWatcher
+	DB.Joins("JOIN users ON users.id = complaint_watchers.user_id").
+		Where("complaint_watchers.complaint_id = ? AND users.notify_complaint_updates = ?", complaint.ID, true).
+		Find(&watchers)
+
+	complaintID := complaint.ID
+	for _, watcher := range watchers {
+		if skip[watcher.UserID] {
+			continue
+		}
+		notification := models.Notification{
+			UserID:    watcher.UserID,
+			Title:     title,
+			Message:   message,
+			Type:      models.NotificationComplaintUpdate,
+			RelatedID: &complaintID,
+			IsRead:    false,
+		}
+		DB.Create(&notification)
+	}
+}
+
+// notifyWatchersOfUpdate tells watchers about a status change or admin
+// response, mirroring the notification sent to the reporter.
+func notifyWatchersOfUpdate(complaint models.Complaint, statusChanged, responseAdded bool, actorID uint) {
+	var message string
+	statusText := getStatusTextForNotification(complaint.Status)
+	if statusChanged && responseAdded {
+		message = fmt.Sprintf("Complaint \"%s\" (Ticket: %s) you are watching was updated to %s and admin has responded.", complaint.Title, complaint.TicketID, statusText)
+	} else if statusChanged {
+		message = fmt.Sprintf("Complaint \"%s\" (Ticket: %s) you are watching was updated to %s.", complaint.Title, complaint.TicketID, statusText)
+	} else if responseAdded {
+		message = fmt.Sprintf("Admin has responded to complaint \"%s\" (Ticket: %s) you are watching.", complaint.Title, complaint.TicketID)
+	} else {
+		return
+	}
+	notifyWatchers(complaint, "Watched Ticket Update", message, complaint.UserID, actorID)
+}
+
+// loadWatchableComplaint loads the complaint named in the route and writes an
+// error response if it does not exist.
+func loadWatchableComplaint(c *gin.Context) (models.Complaint, bool) {
+	var complaint models.Complaint
+	if err := DB.First(&complaint, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Complaint not found"})
+			return complaint, false
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return complaint, false
+	}
+	return complaint, true
+}
+
+func getWatchers(c *gin.Context) {
+	complaint, ok := loadWatchableComplaint(c)
+	if !ok {
+		return
+	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var watchers []models.ComplaintWatcher
+	DB.Preload("User").Where("complaint_id = ?", complaint.ID).Order("created_at ASC").Find(&watchers)
+
+	watcherList := make([]gin.H, len(watchers))
+	for i, watcher := range watchers {
+		watcherList[i] = gin.H{
+			"user_id":    watcher.UserID,
+			"username":   watcher.User.Username,
+			"name":       watcher.User.Name,
+			"role":       watcher.User.Role,
+			"created_at": watcher.CreatedAt,
+		}
+	}
+
+	c.JSON(200, gin.H{"data": watcherList, "watching": isWatching(complaint.ID, getUserID(c))})
+}
+
+// watchComplaint lets the current user follow a complaint they can see.
+func watchComplaint(c *gin.Context) {
+	complaint, ok := loadWatchableComplaint(c)
+	if !ok {
+		return
+	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+
+	userID := getUserID(c)
+	if err := addWatcher(DB, complaint.ID, userID, userID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to watch complaint"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "You are now watching this complaint"})
+}
+
+func unwatchComplaint(c *gin.Context) {
+	complaint, ok := loadWatchableComplaint(c)
+	if !ok {
+		return
+	}
+
+	if err := DB.Where("complaint_id = ? AND user_id = ?", complaint.ID, getUserID(c)).Delete(&models.ComplaintWatcher{}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unwatch complaint"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "You are no longer watching this complaint"})
+}
+
+// addComplaintWatcher lets an admin subscribe another user, e.g. a dorm
+// supervisor or another affected student, to a complaint.
+func addComplaintWatcher(c *gin.Context) {
+	complaint, ok := loadWatchableComplaint(c)
+	if !ok {
+		return
+	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req AddWatcherRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := DB.First(&user, req.UserID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := addWatcher(DB, complaint.ID, user.ID, getUserID(c)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to add watcher"})
+		return
+	}
+
+	complaintID := complaint.ID
+	DB.Create(&models.Notification{
+		UserID:    user.ID,
+		Title:     "Added as Watcher",
+		Message:   fmt.Sprintf("You have been added as a watcher of complaint \"%s\" (Ticket: %s).", complaint.Title, complaint.TicketID),
+		Type:      models.NotificationComplaintUpdate,
+		RelatedID: &complaintID,
+	})
+
+	c.JSON(201, gin.H{"message": "Watcher added successfully"})
+}
+
+func removeComplaintWatcher(c *gin.Context) {
+	complaint, ok := loadWatchableComplaint(c)
+	if !ok {
+		return
+	}
+	if !canViewComplaint(c, complaint) {
+		c.JSON(403, gin.H{"error": "Access denied"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := DB.Where("complaint_id = ? AND user_id = ?", complaint.ID, userID).Delete(&models.ComplaintWatcher{}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to remove watcher"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Watcher removed successfully"})
+}
@@ -0,0 +1,57 @@
+// Pages opened from the links in password reset, invitation and email
+// verification emails. The token is taken from the ?token= parameter.
+document.addEventListener('DOMContentLoaded', () => {
+    const token = new URLSearchParams(window.location.search).get('token');
+    const messageBox = document.getElementById('message');
+
+    function showMessage(message, success) {
+        messageBox.textContent = message;
+        messageBox.className = success
+            ? 'rounded-lg p-4 text-sm bg-green-50 border border-green-200 text-green-700'
+            : 'rounded-lg p-4 text-sm bg-red-50 border border-red-200 text-red-700';
+    }
+
+    const resetForm = document.getElementById('resetPasswordForm');
+    if (resetForm) {
+        if (!token) {
+            resetForm.classList.add('hidden');
+            showMessage('This link is invalid. Request a new password reset from the login page.', false);
+            return;
+        }
+        resetForm.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            const newPassword = document.getElementById('newPassword').value;
+            if (newPassword !== document.getElementById('confirmPassword').value) {
+                showMessage('The passwords do not match.', false);
+                return;
+            }
+            const button = document.getElementById('resetPasswordBtn');
+            button.disabled = true;
+            try {
+                const response = await AuthAPI.resetPassword(token, newPassword);
+                resetForm.classList.add('hidden');
+                showMessage(response.message, true);
+            } catch (error) {
+                showMessage(error.message || 'Failed to reset password.', false);
+            } finally {
+                button.disabled = false;
+            }
+        });
+        return;
+    }
+
+    const verifyStatus = document.getElementById('verifyStatus');
+    if (verifyStatus) {
+        if (!token) {
+            verifyStatus.textContent = 'This link is invalid.';
+            return;
+        }
+        AuthAPI.verifyEmail(token).then((response) => {
+            verifyStatus.textContent = response.message;
+            showMessage(response.message, true);
+        }).catch((error) => {
+            verifyStatus.textContent = 'Your email address could not be verified.';
+            showMessage(error.message || 'Invalid or expired verification link', false);
+        });
+    }
+});
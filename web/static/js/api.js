@@ -6,13 +6,38 @@ const TokenManager = {
     getToken: () => localStorage.getItem('token'),
     setToken: (token) => localStorage.setItem('token', token),
     removeToken: () => localStorage.removeItem('token'),
+    getRefreshToken: () => localStorage.getItem('refresh_token'),
+    setRefreshToken: (token) => localStorage.setItem('refresh_token', token),
+    removeRefreshToken: () => localStorage.removeItem('refresh_token'),
     getUser: () => JSON.parse(localStorage.getItem('user') || '{}'),
     setUser: (user) => localStorage.setItem('user', JSON.stringify(user)),
     removeUser: () => localStorage.removeItem('user'),
 };
 
+// Exchange the stored refresh token for a new token pair
+async function refreshAccessToken() {
+    const refreshToken = TokenManager.getRefreshToken();
+    if (!refreshToken) {
+        return false;
+    }
+
+    const response = await fetch(`${API_BASE_URL}/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+    });
+    if (!response.ok) {
+        return false;
+    }
+
+    const data = await response.json();
+    TokenManager.setToken(data.token);
+    TokenManager.setRefreshToken(data.refresh_token);
+    return true;
+}
+
 // API Request helper
-async function apiRequest(endpoint, options = {}) {
+async function apiRequest(endpoint, options = {}, retried = false) {
     const token = TokenManager.getToken();
     const headers = {
         'Content-Type': 'application/json',
@@ -28,9 +53,14 @@ async function apiRequest(endpoint, options = {}) {
         headers,
     });
 
-    if (response.status === 401) {
+    if (response.status === 401 && !endpoint.startsWith('/login')) {
+        // Access token expired - try once with a refreshed token
+        if (!retried && await refreshAccessToken()) {
+            return await apiRequest(endpoint, options, true);
+        }
         // Unauthorized - redirect to login
         TokenManager.removeToken();
+        TokenManager.removeRefreshToken();
         TokenManager.removeUser();
         window.location.href = '/login';
         return null;
@@ -53,21 +83,87 @@ const AuthAPI = {
             body: JSON.stringify({ username, password }),
         });
 
-        if (response) {
+        if (response && response.token) {
             TokenManager.setToken(response.token);
+            TokenManager.setRefreshToken(response.refresh_token);
             TokenManager.setUser(response.user);
         }
 
         return response;
     },
-    logout: () => {
+    verifyTwoFactor: async (mfaToken, code) => {
+        const response = await apiRequest('/login/2fa', {
+            method: 'POST',
+            body: JSON.stringify({ mfa_token: mfaToken, code }),
+        });
+
+        if (response && response.token) {
+            TokenManager.setToken(response.token);
+            TokenManager.setRefreshToken(response.refresh_token);
+            TokenManager.setUser(response.user);
+        }
+
+        return response;
+    },
+    logout: async () => {
+        try {
+            await apiRequest('/logout', { method: 'POST' });
+        } catch (error) {
+            console.error('Logout request failed:', error);
+        }
         TokenManager.removeToken();
+        TokenManager.removeRefreshToken();
         TokenManager.removeUser();
         window.location.href = '/login';
     },
     getProfile: async () => {
         return await apiRequest('/profile');
     },
+    getProviders: async () => {
+        return await apiRequest('/auth/providers');
+    },
+    // Stores the tokens handed back by the SSO callback
+    completeSSOLogin: async (token, refreshToken) => {
+        TokenManager.setToken(token);
+        TokenManager.setRefreshToken(refreshToken);
+        const profile = await apiRequest('/profile');
+        if (profile) {
+            TokenManager.setUser(profile);
+        }
+        return profile;
+    },
+    changePassword: async (currentPassword, newPassword) => {
+        const response = await apiRequest('/profile/password', {
+            method: 'PUT',
+            body: JSON.stringify({ current_password: currentPassword, new_password: newPassword }),
+        });
+
+        if (response) {
+            TokenManager.setToken(response.token);
+            TokenManager.setRefreshToken(response.refresh_token);
+        }
+
+        return response;
+    },
+    forgotPassword: async (email) => {
+        return await apiRequest('/password/forgot', {
+            method: 'POST',
+            body: JSON.stringify({ email }),
+        });
+    },
+    // Sets a new password with the token from a reset or invitation email
+    resetPassword: async (token, newPassword) => {
+        return await apiRequest('/password/reset', {
+            method: 'POST',
+            body: JSON.stringify({ token, new_password: newPassword }),
+        });
+    },
+    verifyEmail: async (token) => {
+        return await apiRequest('/email/verify', {
+            method: 'POST',
+            body: JSON.stringify({ token }),
+        });
+    },
 };
 
 // Complaint API
@@ -217,6 +313,7 @@ function getStatusBadgeClass(status) {
         'in_process': 'bg-blue-100 text-blue-800 dark:bg-blue-900/30 dark:text-blue-300',
         'completed': 'bg-green-100 text-green-800 dark:bg-green-900/30 dark:text-green-300',
         'rejected': 'bg-red-100 text-red-800 dark:bg-red-900/30 dark:text-red-300',
+        'merged': 'bg-gray-100 text-gray-800 dark:bg-gray-900/30 dark:text-gray-300',
     };
     return statusMap[status] || statusMap.pending;
 }
@@ -227,6 +324,7 @@ function getStatusText(status) {
         'in_process': 'In Process',
         'completed': 'Completed',
         'rejected': 'Rejected',
+        'merged': 'Merged',
     };
     return statusMap[status] || status;
 }
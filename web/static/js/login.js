@@ -67,11 +67,30 @@ document.addEventListener('DOMContentLoaded', () => {
             }
 
             try {
-                const response = await AuthAPI.login(username, password);
+                let response = await AuthAPI.login(username, password);
+
+                if (response && response.two_factor_required) {
+                    const code = window.prompt('Enter the 6-digit code from your authenticator app:');
+                    if (!code) {
+                        showError('Verification code is required.');
+                        return;
+                    }
+                    response = await AuthAPI.verifyTwoFactor(response.mfa_token, code.trim());
+                }
                 
+                if (response && response.must_change_password) {
+                    // Accounts with an assigned password must pick their own first
+                    const newPassword = window.prompt('Please choose a new password to continue:');
+                    if (!newPassword) {
+                        showError('You must change your password before continuing.');
+                        return;
+                    }
+                    await AuthAPI.changePassword(password, newPassword);
+                }
+
                 if (response) {
                     // Redirect based on role
-                    if (response.role === 'admin') {
+                    if (response.role !== 'student') {
                         window.location.href = '/admin/dashboard';
                     } else {
                         window.location.href = '/student/dashboard';
@@ -83,6 +102,64 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     }
 
+    // Forgot password: the API answers the same whether or not the email exists
+    const forgotLink = document.getElementById('forgotPassword');
+    if (forgotLink) {
+        forgotLink.addEventListener('click', async (e) => {
+            e.preventDefault();
+            const email = window.prompt('Enter the email address of your account:');
+            if (!email) return;
+            try {
+                const response = await AuthAPI.forgotPassword(email.trim());
+                window.alert(response.message);
+            } catch (error) {
+                showError(error.message || 'Failed to request a password reset.');
+            }
+        });
+    }
+
+    // SSO login: show the button when configured and pick up the callback result
+    AuthAPI.getProviders().then((providers) => {
+        if (!providers || !providers.oidc || !loginForm) return;
+        const ssoButton = document.createElement('a');
+        ssoButton.href = '/api/auth/oidc/login';
+        ssoButton.className = 'flex w-full items-center justify-center rounded-lg h-12 px-5 mt-3 border border-slate-300 dark:border-slate-700 text-slate-700 dark:text-slate-200 text-sm font-bold hover:bg-slate-50 dark:hover:bg-slate-800 transition-colors';
+        ssoButton.textContent = 'Log in with Campus SSO';
+        loginForm.appendChild(ssoButton);
+    }).catch(() => {});
+
+    const ssoResult = new URLSearchParams(window.location.hash.slice(1));
+    const ssoPending = ssoResult.has('sso_error') || ssoResult.has('token') || ssoResult.has('mfa_token');
+    if (ssoPending) {
+        history.replaceState(null, '', window.location.pathname);
+        handleSSOResult(ssoResult);
+    }
+
+    async function handleSSOResult(result) {
+        try {
+            if (result.has('sso_error')) {
+                showError(result.get('sso_error'));
+                return;
+            }
+            let role = result.get('role');
+            if (result.has('mfa_token')) {
+                const code = window.prompt('Enter the 6-digit code from your authenticator app:');
+                if (!code) {
+                    showError('Verification code is required.');
+                    return;
+                }
+                const response = await AuthAPI.verifyTwoFactor(result.get('mfa_token'), code.trim());
+                role = response.role;
+            } else {
+                const profile = await AuthAPI.completeSSOLogin(result.get('token'), result.get('refresh_token'));
+                role = profile.role;
+            }
+            window.location.href = role !== 'student' ? '/admin/dashboard' : '/student/dashboard';
+        } catch (error) {
+            showError(error.message || 'SSO login failed.');
+        }
+    }
+
     function showError(message) {
         // Create or update alert
         let alertDiv = document.querySelector('.alert-error');
@@ -106,9 +183,9 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     // Check if already logged in
-    if (TokenManager.getToken()) {
+    if (!ssoPending && TokenManager.getToken()) {
         const user = TokenManager.getUser();
-        if (user.role === 'admin') {
+        if (user.role !== 'student') {
             window.location.href = '/admin/dashboard';
         } else {
             window.location.href = '/student/dashboard';
@@ -9,7 +9,7 @@ document.addEventListener('DOMContentLoaded', async () => {
     }
 
     const user = TokenManager.getUser();
-    if (user.role !== 'admin') {
+    if (user.role === 'student') {
         window.location.href = '/student/dashboard';
         return;
     }
@@ -79,18 +79,36 @@ function updatePendingCount(stats) {
     }
 }
 
+// Format a YYYY-MM-DD period as e.g. "Sep 20, 2026 - Oct 19, 2026"
+function formatPeriod(period) {
+    const format = (date) => new Date(`${date}T00:00:00`).toLocaleDateString('en-US', { month: 'short', day: 'numeric', year: 'numeric' });
+    return `${format(period.start_date)} - ${format(period.end_date)}`;
+}
+
 function updateReportStats(stats) {
     // Format number with thousand separator
     const formatNumber = (num) => {
         return num ? num.toLocaleString('en-US') : '0';
     };
 
+    // The figures only cover the reported period, so label it
+    if (stats.period) {
+        const dateRangeEl = document.getElementById('dateRange');
+        if (dateRangeEl) {
+            dateRangeEl.textContent = formatPeriod(stats.period);
+        }
+        const noteEl = document.getElementById('statsPeriodNote');
+        if (noteEl && stats.previous_period) {
+            noteEl.textContent = `Figures cover complaints submitted ${formatPeriod(stats.period)}. Changes compare with ${formatPeriod(stats.previous_period)}.`;
+        }
+    }
+
     // Update Total Complaints
     const totalEl = document.getElementById('totalComplaints');
     if (totalEl && stats.total) {
         totalEl.textContent = formatNumber(stats.total.value);
     }
-    if (stats.total && stats.total.change) {
+    if (stats.total && stats.total.change != null) {
         const changeEl = document.getElementById('totalChange');
         if (changeEl) {
             const isPositive = stats.total.change >= 0;
@@ -104,7 +122,7 @@ function updateReportStats(stats) {
     if (pendingEl && stats.pending) {
         pendingEl.textContent = formatNumber(stats.pending.value);
     }
-    if (stats.pending && stats.pending.change) {
+    if (stats.pending && stats.pending.change != null) {
         const changeEl = document.getElementById('pendingChange');
         if (changeEl) {
             const isPositive = stats.pending.change >= 0;
@@ -118,7 +136,7 @@ function updateReportStats(stats) {
     if (resolvedEl && stats.resolved) {
         resolvedEl.textContent = formatNumber(stats.resolved.value);
     }
-    if (stats.resolved && stats.resolved.change) {
+    if (stats.resolved && stats.resolved.change != null) {
         const changeEl = document.getElementById('resolvedChange');
         if (changeEl) {
             const isPositive = stats.resolved.change >= 0;
@@ -133,7 +151,7 @@ function updateReportStats(stats) {
         const days = stats.avg_resolution_time.days || 0;
         resolutionTimeEl.textContent = `${days.toFixed(1)} Days`;
     }
-    if (stats.avg_resolution_time && stats.avg_resolution_time.change) {
+    if (stats.avg_resolution_time && stats.avg_resolution_time.change != null) {
         const changeEl = document.getElementById('resolutionTimeChange');
         if (changeEl) {
             const isPositive = stats.avg_resolution_time.change >= 0;
@@ -7,7 +7,7 @@ document.addEventListener('DOMContentLoaded', async () => {
     }
 
     const user = TokenManager.getUser();
-    if (user.role === 'admin') {
+    if (user.role !== 'student') {
         window.location.href = '/admin/dashboard';
         return;
     }
@@ -201,7 +201,7 @@ document.addEventListener('DOMContentLoaded', async () => {
         }
 
         tbody.innerHTML = users.map(user => {
-            const roleBadge = user.role === 'admin' 
+            const roleBadge = user.role !== 'student' 
                 ? 'bg-purple-100 text-purple-800 dark:bg-purple-900/30 dark:text-purple-300'
                 : 'bg-green-100 text-green-800 dark:bg-green-900/30 dark:text-green-300';
 
@@ -214,7 +214,7 @@ document.addEventListener('DOMContentLoaded', async () => {
                     <td class="px-6 py-4">${user.email || 'N/A'}</td>
                     <td class="px-6 py-4">
                         <span class="inline-flex items-center px-2.5 py-0.5 rounded-full text-xs font-medium ${roleBadge}">
-                            ${user.role === 'admin' ? 'Admin' : 'Student'}
+                            ${user.role.charAt(0).toUpperCase() + user.role.slice(1)}
                         </span>
                     </td>
                     <td class="px-6 py-4">${formatDate(user.created_at)}</td>